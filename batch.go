@@ -0,0 +1,203 @@
+package lights
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BatchPrefix starts a batch message. Batch messages carry one command per
+// line after the prefix, for example:
+//
+//	&
+//	+:ab|#F00,2,1|#00F,2,1
+//	+~8|||0 0 20 * * *|:ab|
+//	!^32|:ab|1|3
+const BatchPrefix = "&"
+
+// Batch result statuses.
+const (
+	BatchOK      = "ok"
+	BatchFailed  = "error"
+	BatchSkipped = "skipped"
+)
+
+// StageFunc stages an add or remove command's changes in a transaction.
+type StageFunc func(tx Store, cmd *Command) error
+
+// BatchResult is the outcome of a single command in a batch.
+type BatchResult struct {
+	Status   string
+	Response string // The response or error message
+}
+
+// BatchReport lists the outcome of every command in a batch. Reports are
+// encoded one line per command as `index|status|response`.
+type BatchReport struct {
+	Committed bool
+	Results   []BatchResult
+}
+
+// String encodes the report.
+func (r *BatchReport) String() string {
+	lines := []string{}
+	for i, result := range r.Results {
+		response := strings.Replace(result.Response, "\n", " ", -1)
+		lines = append(lines, strconv.Itoa(i)+"|"+result.Status+"|"+response)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// BatchError is returned when a batch is not committed.
+type BatchError struct {
+	Index  int // The failed command (-1 if the commit failed)
+	Err    error
+	Report *BatchReport
+}
+
+// Error describes the failed command and includes the report.
+func (e *BatchError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("Batch could not be committed: %s\n%s", e.Err, e.Report)
+	}
+	return fmt.Sprintf("Batch failed at command %d: %s\n%s", e.Index, e.Err, e.Report)
+}
+
+// ParseBatch parses every command in a batch message. Blank lines are
+// ignored. A protocol version header before the prefix (as in `@2;&`)
+// applies to every command.
+func ParseBatch(message string) ([]*Command, error) {
+	_, body, err := SplitVersion(message)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(body, BatchPrefix) {
+		return nil, fmt.Errorf("Batch message must start with '%s'", BatchPrefix)
+	}
+	header := message[:len(message)-len(body)]
+	commands := []*Command{}
+	for _, line := range strings.Split(body[len(BatchPrefix):], "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		cmd, err := NewCommand(header + line)
+		if err != nil {
+			return nil, fmt.Errorf("Batch command %d is invalid: %s", len(commands), err)
+		}
+		commands = append(commands, cmd)
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("Batch message has no commands")
+	}
+	return commands, nil
+}
+
+// isBatch returns true if a message (with or without a protocol version
+// header) is a batch message.
+func isBatch(message string) bool {
+	_, body, err := SplitVersion(message)
+	return err == nil && strings.HasPrefix(body, BatchPrefix)
+}
+
+// Batcher applies batch messages all-or-nothing. The add and remove commands
+// in a batch are staged in a Transaction and committed together, so a
+// failing command leaves the store untouched. This is atomic for an Updater
+// and best effort for other stores (see Transaction). Execute and query
+// commands are then passed to the Dispatcher in order. Patterns, schedules
+// and scenes are staged through their repositories; use Stage for other types.
+type Batcher struct {
+	Store      Store
+	Dispatcher *Dispatcher
+
+	stagers map[string]StageFunc
+}
+
+// NewBatcher creates a batcher staging changes in store and running other
+// commands with d.
+func NewBatcher(store Store, d *Dispatcher) *Batcher {
+	return &Batcher{Store: store, Dispatcher: d, stagers: map[string]StageFunc{
+		"pattern":  func(tx Store, cmd *Command) error { return NewPatternRepo(tx).Apply(cmd) },
+		"schedule": func(tx Store, cmd *Command) error { return NewScheduleRepo(tx).Apply(cmd) },
+		"scene":    func(tx Store, cmd *Command) error { return NewSceneRepo(tx).Apply(cmd) },
+	}}
+}
+
+// Stage registers how add and remove commands of a type are staged.
+func (b *Batcher) Stage(commandType string, fn StageFunc) {
+	if b.stagers == nil {
+		b.stagers = map[string]StageFunc{}
+	}
+	b.stagers[commandType] = fn
+}
+
+// Dispatch applies batch messages returning the encoded report, and passes
+// any other message to the Dispatcher. A BatchError is returned if the
+// batch is not committed.
+func (b *Batcher) Dispatch(message string) (string, error) {
+	if !isBatch(message) {
+		if b.Dispatcher == nil {
+			return "", fmt.Errorf("Batcher has no dispatcher for: %s", message)
+		}
+		return b.Dispatcher.Dispatch(message)
+	}
+	commands, err := ParseBatch(message)
+	if err != nil {
+		return "", err
+	}
+	report, err := b.Apply(commands)
+	if err != nil {
+		return "", err
+	}
+	return report.String(), nil
+}
+
+// Apply stages and commits the changes in commands, then runs the other
+// commands. Nothing is changed if any change fails.
+func (b *Batcher) Apply(commands []*Command) (*BatchReport, error) {
+	report := &BatchReport{Results: make([]BatchResult, len(commands))}
+	tx := NewTransaction(b.Store)
+	fail := func(i int, err error) (*BatchReport, error) {
+		tx.Discard()
+		for j := range report.Results {
+			report.Results[j] = BatchResult{Status: BatchSkipped}
+		}
+		if i >= 0 {
+			report.Results[i] = BatchResult{BatchFailed, err.Error()}
+		}
+		return report, &BatchError{i, err, report}
+	}
+	for i, cmd := range commands {
+		if cmd.Action != "add" && cmd.Action != "remove" {
+			if b.Dispatcher == nil || !b.Dispatcher.Capabilities().Supports(cmd) {
+				return fail(i, &UnsupportedError{cmd.Action, cmd.Type, cmd.ID})
+			}
+			report.Results[i] = BatchResult{Status: BatchSkipped}
+			continue
+		}
+		stage, ok := b.stagers[cmd.Type]
+		if !ok {
+			return fail(i, &UnsupportedError{cmd.Action, cmd.Type, cmd.ID})
+		}
+		if err := stage(tx, cmd); err != nil {
+			return fail(i, err)
+		}
+		report.Results[i] = BatchResult{Status: BatchOK}
+	}
+	if err := tx.Commit(); err != nil {
+		return fail(-1, err)
+	}
+	report.Committed = true
+	for i, cmd := range commands {
+		if cmd.Action == "add" || cmd.Action == "remove" {
+			continue
+		}
+		response, err := b.Dispatcher.Dispatch(cmd.String())
+		if err != nil {
+			report.Results[i] = BatchResult{BatchFailed, err.Error()}
+		} else {
+			report.Results[i] = BatchResult{BatchOK, response}
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,263 @@
+package lights_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// failingStore fails writes to a single ID.
+type failingStore struct {
+	*lights.MockStore
+	failID string
+}
+
+func (s *failingStore) Write(collection, id, value string) error {
+	if id == s.failID {
+		return errors.New("Disk full")
+	}
+	return s.MockStore.Write(collection, id, value)
+}
+
+// lockingStore records whether writes happen under its write lock.
+type lockingStore struct {
+	*lights.MockStore
+	locked   bool
+	unlocked []string
+}
+
+func (s *lockingStore) LockWrites() (func(), error) {
+	s.locked = true
+	return func() { s.locked = false }, nil
+}
+
+func (s *lockingStore) Write(collection, id, value string) error {
+	if !s.locked {
+		s.unlocked = append(s.unlocked, id)
+	}
+	return s.MockStore.Write(collection, id, value)
+}
+
+// batchOnlyStore is a log store that only accepts changes in batches.
+type batchOnlyStore struct {
+	*lights.LogStore
+	updates int
+}
+
+func (s *batchOnlyStore) Write(collection, id, value string) error {
+	return errors.New("Write outside a batch")
+}
+
+func (s *batchOnlyStore) Remove(collection, id string) error {
+	return errors.New("Remove outside a batch")
+}
+
+func (s *batchOnlyStore) RemoveAll(collection string) error {
+	return errors.New("RemoveAll outside a batch")
+}
+
+func (s *batchOnlyStore) Update(fn func(b *lights.Batch) error) error {
+	s.updates++
+	return s.LogStore.Update(fn)
+}
+
+var _ = Describe("Core", func() {
+	Describe("Transaction", func() {
+		It("should stage changes until committed", func() {
+			store := &lights.MockStore{}
+			Ω(store.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			Ω(store.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			tx := lights.NewTransaction(store)
+			Ω(tx.Write("patterns", "ef", ":ef|#00F")).Should(Succeed())
+			Ω(tx.Remove("patterns", "ab")).Should(Succeed())
+			Ω(lights.IsNotFound(tx.Remove("patterns", "zz"))).Should(BeTrue())
+			Ω(tx.List("patterns")).Should(Equal([]string{"cd", "ef"}))
+			Ω(tx.Read("patterns", "ef")).Should(Equal(":ef|#00F"))
+			Ω(store.List("patterns")).Should(Equal([]string{"ab", "cd"}))
+			Ω(tx.Commit()).Should(Succeed())
+			Ω(store.List("patterns")).Should(Equal([]string{"cd", "ef"}))
+			Ω(tx.Write("patterns", "gh", ":gh|#FFF")).Should(Equal(lights.ErrTransactionDone))
+		})
+
+		It("should stage collection removal", func() {
+			store := &lights.MockStore{}
+			Ω(store.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			tx := lights.NewTransaction(store)
+			Ω(tx.RemoveAll("patterns")).Should(Succeed())
+			Ω(tx.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			Ω(tx.LoadMap("patterns")).Should(Equal(map[string]string{"cd": ":cd|#0F0"}))
+			tx.Discard()
+			Ω(tx.Commit()).Should(Equal(lights.ErrTransactionDone))
+			Ω(store.List("patterns")).Should(Equal([]string{"ab"}))
+		})
+
+		It("should undo partial commits", func() {
+			store := &failingStore{&lights.MockStore{}, "zz"}
+			Ω(store.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			tx := lights.NewTransaction(store)
+			Ω(tx.Write("patterns", "ab", ":ab|#000")).Should(Succeed())
+			Ω(tx.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			Ω(tx.Write("patterns", "zz", ":zz|#00F")).Should(Succeed())
+			Ω(tx.Commit()).Should(MatchError("Disk full"))
+			Ω(store.LoadMap("patterns")).Should(Equal(map[string]string{"ab": ":ab|#F00"}))
+		})
+
+		It("should hold the write lock while applying commits", func() {
+			store := &lockingStore{MockStore: &lights.MockStore{}}
+			tx := lights.NewTransaction(store)
+			Ω(tx.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			Ω(tx.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			Ω(tx.Commit()).Should(Succeed())
+			Ω(store.unlocked).Should(BeEmpty())
+			Ω(store.locked).Should(BeFalse())
+			Ω(store.List("patterns")).Should(Equal([]string{"ab", "cd"}))
+		})
+
+		It("should commit atomically through store wrappers", func() {
+			dir, err := ioutil.TempDir("", "lights-tx")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			ls, err := lights.NewLogStore(filepath.Join(dir, "data.log"))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer ls.Close()
+			raw := &batchOnlyStore{LogStore: ls}
+			Ω(raw.LogStore.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			encrypted, err := lights.NewEncryptedStore(raw, lights.DeriveKey([]byte("device secret"), "store"))
+			Ω(err).ShouldNot(HaveOccurred())
+			encrypted.AllowPlaintext = true
+			versioned := lights.NewVersionedStore(encrypted, 0, "gateway")
+
+			tx := lights.NewTransaction(versioned)
+			Ω(tx.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			Ω(tx.Write("patterns", "cd", ":cd|#00F")).Should(Succeed())
+			Ω(tx.Remove("patterns", "ab")).Should(Succeed())
+			Ω(tx.Commit()).Should(Succeed())
+			Ω(raw.updates).Should(Equal(1))
+
+			Ω(versioned.LoadMap("patterns")).Should(Equal(map[string]string{"cd": ":cd|#00F"}))
+			Ω(ls.Read("patterns", "cd")).ShouldNot(ContainSubstring("#00F"))
+			Ω(versioned.Revision("patterns", "cd")).Should(Equal(2))
+			history, err := versioned.History("patterns", "ab")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(history).Should(HaveLen(1))
+			Ω(history[0].Deleted).Should(BeTrue())
+			Ω(history[0].Author).Should(Equal("gateway"))
+
+			tx = lights.NewTransaction(versioned)
+			Ω(tx.RemoveAll("patterns")).Should(Succeed())
+			Ω(tx.Commit()).Should(Succeed())
+			Ω(raw.updates).Should(Equal(2))
+			Ω(versioned.List("patterns")).Should(BeEmpty())
+			Ω(versioned.Revision("patterns", "cd")).Should(Equal(3))
+		})
+
+		It("should fall back when wrapped stores can not apply batches", func() {
+			store := &lights.MockStore{}
+			encrypted, err := lights.NewEncryptedStore(store, lights.DeriveKey([]byte("device secret"), "store"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(encrypted.Update(func(b *lights.Batch) error { return nil })).Should(Equal(lights.ErrBatchUnsupported))
+			tx := lights.NewTransaction(encrypted)
+			Ω(tx.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			Ω(tx.Commit()).Should(Succeed())
+			Ω(encrypted.Read("patterns", "ab")).Should(Equal(":ab|#F00"))
+		})
+	})
+
+	Describe("Batcher", func() {
+		var store *lights.MockStore
+		var d *lights.Dispatcher
+		var b *lights.Batcher
+		var executed []string
+
+		BeforeEach(func() {
+			store = &lights.MockStore{}
+			executed = []string{}
+			d = lights.NewDispatcher()
+			d.OnExecute("scene", func(cmd *lights.Command) (string, error) {
+				executed = append(executed, cmd.ID)
+				return "running " + cmd.ID, nil
+			})
+			b = lights.NewBatcher(store, d)
+		})
+
+		It("should apply batches and report each command", func() {
+			report, err := b.Dispatch("&\n+:ab|#F00,2,1|#00F,2,1\n\n+~8|||0 0 20 * * *|:ab|\n!^32|:ab|1|3\n")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(report).Should(Equal("0|ok|\n1|ok|\n2|ok|running 32"))
+			Ω(store.List(lights.PatternCollection)).Should(Equal([]string{"ab"}))
+			Ω(store.List(lights.ScheduleCollection)).Should(Equal([]string{"8"}))
+			Ω(executed).Should(Equal([]string{"32"}))
+		})
+
+		It("should apply nothing if a command fails", func() {
+			_, err := b.Dispatch("&\n+:ab|#F00,2,1\n+~8|||0 20 * * *|:ab|\n!^32|:ab|1|3")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.BatchError{}))
+			batchErr := err.(*lights.BatchError)
+			Ω(batchErr.Index).Should(Equal(1))
+			Ω(batchErr.Report.Committed).Should(BeFalse())
+			Ω(batchErr.Report.Results[0].Status).Should(Equal(lights.BatchSkipped))
+			Ω(batchErr.Report.Results[1].Status).Should(Equal(lights.BatchFailed))
+			Ω(store.List(lights.PatternCollection)).Should(BeEmpty())
+			Ω(executed).Should(BeEmpty())
+
+			_, err = b.Dispatch("&\n+:ab|#F00\n!:ab")
+			Ω(err.(*lights.BatchError).Index).Should(Equal(1))
+			_, err = b.Dispatch("&\n+:ab|#F00\n+#F00")
+			Ω(err.(*lights.BatchError).Index).Should(Equal(1))
+			Ω(store.List(lights.PatternCollection)).Should(BeEmpty())
+		})
+
+		It("should parse every command before applying any", func() {
+			_, err := b.Dispatch("&\n+:ab|#F00\n*:ab")
+			Ω(err).Should(MatchError(ContainSubstring("Batch command 1 is invalid")))
+			_, err = b.Dispatch("&\n\n")
+			Ω(err).Should(HaveOccurred())
+			Ω(store.List(lights.PatternCollection)).Should(BeEmpty())
+		})
+
+		It("should pass other messages to the dispatcher", func() {
+			Ω(b.Dispatch("!^32|:ab|1")).Should(Equal("running 32"))
+			Ω(b.Dispatch("@2;!^32|:ab|1")).Should(Equal("running 32"))
+			_, err := lights.NewBatcher(store, nil).Dispatch("!^32|:ab|1")
+			Ω(err).Should(MatchError(ContainSubstring("no dispatcher")))
+		})
+
+		It("should apply batches with a protocol version header", func() {
+			report, err := b.Dispatch("@2;&\n+:ab|#F00,2,1\n!^32|:ab|1|3")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(report).Should(Equal("0|ok|\n1|ok|running 32"))
+			Ω(store.List(lights.PatternCollection)).Should(Equal([]string{"ab"}))
+
+			_, err = b.Dispatch("@9;&\n+:cd|#F00\n!=ab|1")
+			Ω(err).Should(HaveOccurred())
+			Ω(err).Should(MatchError(ContainSubstring("Batch command 1 is invalid")))
+			Ω(store.List(lights.PatternCollection)).Should(Equal([]string{"ab"}))
+		})
+
+		It("should commit atomically to a log store", func() {
+			dir, err := ioutil.TempDir("", "lights-batch")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			ls, err := lights.NewLogStore(filepath.Join(dir, "data.log"))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer ls.Close()
+			b = lights.NewBatcher(ls, d)
+			b.Stage("property", func(tx lights.Store, cmd *lights.Command) error {
+				if cmd.Action == "remove" {
+					return tx.Remove("properties", cmd.ID)
+				}
+				return tx.Write("properties", cmd.ID, cmd.Body())
+			})
+			_, err = b.Dispatch("&\n+:ab|#F00\n+-level|40\n-:ab")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ls.List(lights.PatternCollection)).Should(BeEmpty())
+			Ω(ls.Read("properties", "level")).Should(Equal("level|40"))
+		})
+	})
+})
@@ -0,0 +1,353 @@
+package lights
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image/color"
+	"strings"
+	"time"
+)
+
+// BinaryVersion is the version of the binary command encoding written by
+// MarshalBinary.
+const BinaryVersion = 1
+
+// Binary payload kinds.
+const (
+	binaryParts   = 0 // Raw `|` separated parts
+	binaryPattern = 1 // Typed pattern
+)
+
+// Binary slot flags.
+const binaryHasColor = 1
+
+// binaryActions and binaryTypes are the enum codes used by the binary
+// encoding. Zero is never used so truncated data is easy to spot.
+var (
+	binaryActions = []string{"", "execute", "add", "remove", "query"}
+	binaryTypes   = []string{"", "color", "pattern", "schedule", "scene", "property"}
+)
+
+// ErrBinaryChecksum is returned when binary data fails its CRC check.
+var ErrBinaryChecksum = errors.New("Binary command checksum does not match")
+
+// MarshalBinary encodes the command in the compact binary format for
+// constrained links such as mesh and serial connections:
+//
+//	version | action<<4 + type | kind | payload | CRC-32 (big endian)
+//
+// Pattern commands with valid single color slots (and no effect) carry a
+// typed pattern (24-bit colors, durations as varint milliseconds), every
+// other command carries its length prefixed parts. Decoding gives the same
+// command as parsing the canonical text form.
+func (c *Command) MarshalBinary() ([]byte, error) {
+	action := enumCode(binaryActions, c.Action)
+	commandType := enumCode(binaryTypes, c.Type)
+	if action == 0 || commandType == 0 {
+		return nil, fmt.Errorf("Can not encode %s %s command", c.Action, c.Type)
+	}
+	data := []byte{BinaryVersion, byte(action<<4 | commandType)}
+	if c.Type == "pattern" {
+		if p, err := NewPattern(":" + c.Body()); err == nil {
+			if payload, err := appendPattern(nil, p); err == nil {
+				data = append(data, binaryPattern)
+				return appendChecksum(append(data, payload...)), nil
+			}
+		}
+	}
+	data = append(data, binaryParts)
+	data = binary.AppendUvarint(data, uint64(len(c.Parts)))
+	for _, part := range c.Parts {
+		data = appendString(data, part)
+	}
+	return appendChecksum(data), nil
+}
+
+// UnmarshalBinary decodes a binary command, verifying its version and
+// checksum.
+func (c *Command) UnmarshalBinary(data []byte) error {
+	r, err := newBinaryReader(data)
+	if err != nil {
+		return err
+	}
+	codes := r.byte()
+	action, commandType := int(codes>>4), int(codes&0x0f)
+	if r.err == nil && (action == 0 || action >= len(binaryActions) || commandType == 0 || commandType >= len(binaryTypes)) {
+		return fmt.Errorf("Unknown binary command code %#x", codes)
+	}
+	body := ""
+	switch kind := r.byte(); {
+	case r.err != nil:
+	case kind == binaryPattern:
+		p := r.pattern()
+		if r.err == nil {
+			body = strings.TrimPrefix(p.String(), ":")
+		}
+	case kind == binaryParts:
+		count := r.count()
+		parts := []string{}
+		for i := 0; i < count && r.err == nil; i++ {
+			part := r.string()
+			if strings.Contains(part, "|") {
+				r.fail("Binary command part contains '|'")
+			}
+			parts = append(parts, part)
+		}
+		body = strings.Join(parts, "|")
+	default:
+		return fmt.Errorf("Unknown binary payload kind %d", kind)
+	}
+	if err = r.finish(); err != nil {
+		return err
+	}
+	code, _ := commandCode(binaryActions[action], binaryTypes[commandType])
+	cmd, err := NewCommand(code + body)
+	if err != nil {
+		return err
+	}
+	*c = *cmd
+	return nil
+}
+
+// ParseBinaryCommand decodes a binary command.
+func ParseBinaryCommand(data []byte) (*Command, error) {
+	cmd := &Command{}
+	if err := cmd.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// MarshalBinary encodes the pattern in the compact binary format:
+//
+//	version | pattern | CRC-32 (big endian)
+//
+// Slot durations must be whole, non-negative milliseconds.
+func (p *Pattern) MarshalBinary() ([]byte, error) {
+	payload, err := appendPattern([]byte{BinaryVersion}, p)
+	if err != nil {
+		return nil, err
+	}
+	return appendChecksum(payload), nil
+}
+
+// UnmarshalBinary decodes a binary pattern, verifying its version and
+// checksum.
+func (p *Pattern) UnmarshalBinary(data []byte) error {
+	r, err := newBinaryReader(data)
+	if err != nil {
+		return err
+	}
+	decoded := r.pattern()
+	if err = r.finish(); err != nil {
+		return err
+	}
+	*p = *decoded
+	return nil
+}
+
+// appendPattern appends the binary encoding of a pattern:
+//
+//	id | loops (signed varint) | slot count | slots
+//
+// where each slot is flags | [RGB] | fade ms | hold ms | transition (empty for
+// the default "ease" transition).
+func appendPattern(data []byte, p *Pattern) ([]byte, error) {
+	if strings.ContainsAny(p.ID, ":|") {
+		return nil, errors.New("Pattern ID can not be encoded: " + p.ID)
+	}
+	if p.Effect != nil {
+		return nil, errors.New("Effect patterns can not be encoded: " + p.ID)
+	}
+	data = appendString(data, p.ID)
+	data = binary.AppendVarint(data, int64(p.Loops))
+	data = binary.AppendUvarint(data, uint64(len(p.Slots)))
+	for _, s := range p.Slots {
+		transition := strings.TrimSpace(s.Transition)
+		if len(transition) == 0 {
+			transition = "ease"
+		}
+		if strings.ContainsAny(transition, ",|") {
+			return nil, errors.New("Slot transition can not be encoded: " + transition)
+		}
+		if len(s.Segments) > 0 || s.Motion != nil {
+			return nil, errors.New("Slot segments and motion can not be encoded")
+		}
+		if s.Color == nil {
+			data = append(data, 0)
+		} else {
+			r, g, b := rgb(s.Color)
+			data = append(data, binaryHasColor, r, g, b)
+		}
+		for _, d := range []time.Duration{s.Fade, s.Hold} {
+			if d < 0 || d%time.Millisecond != 0 {
+				return nil, fmt.Errorf("Slot duration %s is not whole milliseconds", d)
+			}
+			data = binary.AppendUvarint(data, uint64(d/time.Millisecond))
+		}
+		if transition == "ease" {
+			transition = "" // Default transition
+		}
+		data = appendString(data, transition)
+	}
+	return data, nil
+}
+
+// appendString appends a length prefixed string.
+func appendString(data []byte, s string) []byte {
+	return append(binary.AppendUvarint(data, uint64(len(s))), s...)
+}
+
+// appendChecksum appends the CRC-32 of the data.
+func appendChecksum(data []byte) []byte {
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
+
+// enumCode returns the index of a name in a binary enum (zero if missing).
+func enumCode(names []string, name string) int {
+	for i, n := range names {
+		if i > 0 && n == name {
+			return i
+		}
+	}
+	return 0
+}
+
+// binaryReader decodes binary data recording the first error.
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+// newBinaryReader checks the version and checksum of binary data and
+// returns a reader for its payload.
+func newBinaryReader(data []byte) (*binaryReader, error) {
+	if len(data) < 5 {
+		return nil, errors.New("Binary data is truncated")
+	}
+	if data[0] != BinaryVersion {
+		return nil, fmt.Errorf("Unsupported binary version %d", data[0])
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return nil, ErrBinaryChecksum
+	}
+	return &binaryReader{data: body[1:]}, nil
+}
+
+// fail records an error if none has been recorded.
+func (r *binaryReader) fail(message string) {
+	if r.err == nil {
+		r.err = errors.New(message)
+	}
+}
+
+// finish returns the first error or an error if data remains.
+func (r *binaryReader) finish() error {
+	if r.err == nil && len(r.data) > 0 {
+		r.fail("Unexpected data after binary payload")
+	}
+	return r.err
+}
+
+// byte reads a single byte.
+func (r *binaryReader) byte() byte {
+	if r.err != nil || len(r.data) == 0 {
+		r.fail("Binary data is truncated")
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+// uvarint reads an unsigned varint.
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail("Invalid binary varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// varint reads a signed varint.
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail("Invalid binary varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// count reads an item count that can not exceed the remaining data.
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.fail("Binary count exceeds data")
+		return 0
+	}
+	return int(n)
+}
+
+// string reads a length prefixed string.
+func (r *binaryReader) string() string {
+	n := r.count()
+	if r.err != nil {
+		return ""
+	}
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+// pattern reads a pattern.
+func (r *binaryReader) pattern() *Pattern {
+	p := &Pattern{ID: r.string(), Slots: []*Slot{}}
+	loops := r.varint()
+	if loops < -1 || int64(int(loops)) != loops {
+		r.fail("Invalid binary pattern loop count")
+	}
+	p.Loops = int(loops)
+	if strings.ContainsAny(p.ID, ":|") || strings.TrimSpace(p.ID) != p.ID {
+		r.fail("Invalid binary pattern ID")
+	}
+	count := r.count()
+	for i := 0; i < count && r.err == nil; i++ {
+		s := &Slot{}
+		switch flags := r.byte(); flags {
+		case 0:
+		case binaryHasColor:
+			s.Color = color.RGBA{r.byte(), r.byte(), r.byte(), 0}
+		default:
+			r.fail("Invalid binary slot flags")
+		}
+		for _, d := range []*time.Duration{&s.Fade, &s.Hold} {
+			ms := r.uvarint()
+			if ms > uint64(1<<63-1)/uint64(time.Millisecond) {
+				r.fail("Binary slot duration is too long")
+			}
+			*d = time.Duration(ms) * time.Millisecond
+		}
+		s.Transition = r.string()
+		if len(s.Transition) == 0 {
+			s.Transition = "ease"
+		}
+		if strings.ContainsAny(s.Transition, ",|") || strings.TrimSpace(s.Transition) != s.Transition {
+			r.fail("Invalid binary slot transition")
+		}
+		p.Slots = append(p.Slots, s)
+	}
+	return p
+}
@@ -0,0 +1,61 @@
+package lights_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/inceptionllc/go-lights"
+)
+
+// FuzzBinaryCommand checks decoding arbitrary data never panics and that
+// anything decoded encodes back to data that decodes to the same command.
+func FuzzBinaryCommand(f *testing.F) {
+	for _, message := range []string{"!:ab|#F00,2,1|#FFF,2,1", "+~8|||0 0 20 * * *|:ab|", "?-version", "!#F00"} {
+		cmd, _ := lights.NewCommand(message)
+		data, _ := cmd.MarshalBinary()
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cmd, err := lights.ParseBinaryCommand(data)
+		if err != nil {
+			return
+		}
+		encoded, err := cmd.MarshalBinary()
+		if err != nil {
+			t.Fatalf("decoded command %q does not encode: %s", cmd, err)
+		}
+		again, err := lights.ParseBinaryCommand(encoded)
+		if err != nil {
+			t.Fatalf("encoded command %q does not decode: %s", cmd, err)
+		}
+		if again.String() != cmd.String() {
+			t.Fatalf("round trip changed %q to %q", cmd, again)
+		}
+	})
+}
+
+// FuzzBinaryText checks every command accepted by NewCommand round trips
+// through the binary encoding to the same canonical text.
+func FuzzBinaryText(f *testing.F) {
+	for _, message := range []string{"!:ab:3|#F00,2,1|#00f,1,", "!:ab|,250ms,1s,linear", "-^32", "!-brightness|40"} {
+		f.Add(message)
+	}
+	f.Fuzz(func(t *testing.T, message string) {
+		cmd, err := lights.NewCommand(message)
+		if err != nil {
+			return
+		}
+		data, err := cmd.MarshalBinary()
+		if err != nil {
+			t.Fatalf("command %q does not encode: %s", message, err)
+		}
+		decoded, err := lights.ParseBinaryCommand(data)
+		if err != nil {
+			t.Fatalf("command %q does not decode: %s", message, err)
+		}
+		again, err := decoded.MarshalBinary()
+		if err != nil || !bytes.Equal(again, data) {
+			t.Fatalf("command %q decoded as %q does not re-encode identically", message, decoded)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package lights_test
+
+import (
+	"image/color"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Binary", func() {
+		encode := func(message string) []byte {
+			cmd, err := lights.NewCommand(message)
+			Ω(err).ShouldNot(HaveOccurred())
+			data, err := cmd.MarshalBinary()
+			Ω(err).ShouldNot(HaveOccurred())
+			return data
+		}
+
+		It("should encode patterns compactly", func() {
+			message := "!:ab|#F00,2,1|#FFF,2,1|#00F,2,1"
+			data := encode(message)
+			cmd, err := lights.ParseBinaryCommand(data)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(cmd.String()).Should(Equal("!:ab|#FF0000,2s,1s,ease|#FFFFFF,2s,1s,ease|#0000FF,2s,1s,ease"))
+			Ω(len(data)).Should(BeNumerically("<", len(cmd.String())*2/3))
+		})
+
+		It("should round trip through the text form", func() {
+			for _, message := range []string{
+				"!:ab|#F00,2,1|#FFF,2,1|#00F,2,1",
+				"!:1:3|#F00,1,2|#0F0,1,2|#00f,1,",
+				"+:ab:0|,250ms,1s,linear",
+				"!::7000000000",
+				"!:ab|#F00,1ns",
+				"?:ab",
+				"!#F00",
+				"+~8|2015-07-04|2015-07-05|0 0 20 * * *|:ab|1",
+				"!^32|#F00,2|1|3|ab",
+				"!-brightness|40",
+				"?-",
+			} {
+				original, err := lights.NewCommand(message)
+				Ω(err).ShouldNot(HaveOccurred())
+				decoded, err := lights.ParseBinaryCommand(encode(message))
+				Ω(err).ShouldNot(HaveOccurred(), message)
+				Ω(decoded.Action).Should(Equal(original.Action))
+				Ω(decoded.Type).Should(Equal(original.Type))
+				Ω(decoded.ID).Should(Equal(original.ID))
+				if original.Type == "pattern" {
+					p1, _ := lights.NewPattern(":" + original.Body())
+					p2, _ := lights.NewPattern(":" + decoded.Body())
+					Ω(p2).Should(Equal(p1), message)
+				} else {
+					Ω(decoded.String()).Should(Equal(message))
+				}
+				again, err := decoded.MarshalBinary()
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(again).Should(Equal(encode(decoded.String())))
+			}
+		})
+
+		It("should encode patterns", func() {
+			p := &lights.Pattern{ID: "ab", Loops: 2, Slots: []*lights.Slot{
+				{Color: color.RGBA{0x12, 0x34, 0x56, 0}, Fade: 1500 * time.Millisecond, Transition: "ease"},
+				{Hold: time.Minute, Transition: "linear"},
+			}}
+			data, err := p.MarshalBinary()
+			Ω(err).ShouldNot(HaveOccurred())
+			decoded := &lights.Pattern{}
+			Ω(decoded.UnmarshalBinary(data)).Should(Succeed())
+			Ω(decoded).Should(Equal(p))
+			p.Slots[0].Fade = time.Microsecond
+			_, err = p.MarshalBinary()
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should reject corrupt data", func() {
+			data := encode("!:ab|#F00,2,1")
+			for i := range data {
+				corrupt := append([]byte{}, data...)
+				corrupt[i] ^= 0x40
+				_, err := lights.ParseBinaryCommand(corrupt)
+				Ω(err).Should(HaveOccurred())
+			}
+			_, err := lights.ParseBinaryCommand(data[:len(data)-1])
+			Ω(err).Should(Equal(lights.ErrBinaryChecksum))
+			_, err = lights.ParseBinaryCommand(data[:3])
+			Ω(err).Should(HaveOccurred())
+			_, err = lights.ParseBinaryCommand(nil)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,303 @@
+package lights
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Brightness defaults.
+const (
+	DefaultBrightnessFade  = 500 * time.Millisecond
+	DefaultBrightnessGamma = 2.2
+)
+
+// brightnessLevel is a percentage fading from one value to another.
+type brightnessLevel struct {
+	from, to float64
+	start    time.Time
+}
+
+// at returns the level at now while fading for fade.
+func (l *brightnessLevel) at(now time.Time, fade time.Duration) float64 {
+	elapsed := now.Sub(l.start)
+	if fade <= 0 || elapsed >= fade {
+		return l.to
+	}
+	return l.from + (l.to-l.from)*Ease("ease", float64(elapsed)/float64(fade))
+}
+
+// Brightness dims rendered frames independently of pattern colors. The
+// level of a device is the master level (0 to 100%) scaled by the device's
+// own level, kept within the limits (devices that are off stay off), and
+// mapped through a gamma curve so equal steps look equally bright. Level
+// changes fade smoothly over Fade. Controllers are created with
+// NewBrightness.
+type Brightness struct {
+	Fade  time.Duration
+	Gamma float64          // 1 dims linearly
+	Now   func() time.Time // Clock used for fades (time.Now if nil)
+
+	lock     sync.Mutex
+	min, max int
+	master   brightnessLevel
+	devices  map[string]*brightnessLevel
+}
+
+// NewBrightness creates a brightness controller at full brightness.
+func NewBrightness() *Brightness {
+	return &Brightness{
+		Fade:    DefaultBrightnessFade,
+		Gamma:   DefaultBrightnessGamma,
+		max:     100,
+		master:  brightnessLevel{from: 100, to: 100},
+		devices: map[string]*brightnessLevel{},
+	}
+}
+
+// SetMaster starts fading the master level to a percentage.
+func (b *Brightness) SetMaster(percent int) error {
+	if err := checkPercent("Brightness", percent); err != nil {
+		return err
+	}
+	now := b.now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.master = brightnessLevel{b.master.at(now, b.Fade), float64(percent), now}
+	return nil
+}
+
+// Master returns the master level being faded to.
+func (b *Brightness) Master() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return int(b.master.to)
+}
+
+// SetDevice starts fading a device's level to a percentage of the master.
+func (b *Brightness) SetDevice(device string, percent int) error {
+	if err := checkPercent("Device brightness", percent); err != nil {
+		return err
+	}
+	now := b.now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.devices == nil {
+		b.devices = map[string]*brightnessLevel{}
+	}
+	from := 100.0
+	if level, ok := b.devices[device]; ok {
+		from = level.at(now, b.Fade)
+	}
+	b.devices[device] = &brightnessLevel{from, float64(percent), now}
+	return nil
+}
+
+// Device returns the level a device is being faded to (100 unless set).
+func (b *Brightness) Device(device string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if level, ok := b.devices[device]; ok {
+		return int(level.to)
+	}
+	return 100
+}
+
+// SetLimits sets the lowest and highest levels of devices that are on.
+func (b *Brightness) SetLimits(min, max int) error {
+	if err := checkPercent("Minimum brightness", min); err != nil {
+		return err
+	}
+	if err := checkPercent("Maximum brightness", max); err != nil {
+		return err
+	}
+	if min > max {
+		return fmt.Errorf("Minimum brightness %d is above the maximum %d", min, max)
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.min, b.max = min, max
+	return nil
+}
+
+// Limits returns the lowest and highest levels of devices that are on.
+func (b *Brightness) Limits() (int, int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.min, b.max
+}
+
+// Level returns a device's current level as a percentage.
+func (b *Brightness) Level(device string) float64 {
+	now := b.now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.level(device, now)
+}
+
+// Apply scales a frame rendered for a device to the device's current
+// level.
+func (b *Brightness) Apply(device string, frame []color.RGBA) []color.RGBA {
+	factor := b.factor(device)
+	dimmed := make([]color.RGBA, len(frame))
+	for i, c := range frame {
+		dimmed[i] = MixColors(nil, c, factor)
+	}
+	return dimmed
+}
+
+// ApplyColor scales a color shown by a device to the device's current
+// level.
+func (b *Brightness) ApplyColor(device string, c color.Color) color.RGBA {
+	return MixColors(nil, c, b.factor(device))
+}
+
+// Install registers settable brightness properties:
+//
+//	brightness          master level (int percentage)
+//	brightness-min      lowest level of devices that are on
+//	brightness-max      highest level
+//	brightness-fade     how long level changes take
+//	brightness-devices  device levels as `device=level,...` (setting
+//	                    changes the listed devices and leaves the others)
+func (b *Brightness) Install(props *Properties) {
+	props.Register(&Property{Name: "brightness", Type: PropertyInt,
+		Get: func() interface{} { return b.Master() },
+		Set: func(value interface{}) error { return b.SetMaster(value.(int)) },
+	})
+	props.Register(&Property{Name: "brightness-min", Type: PropertyInt,
+		Get: func() interface{} {
+			min, _ := b.Limits()
+			return min
+		},
+		Set: func(value interface{}) error {
+			_, max := b.Limits()
+			return b.SetLimits(value.(int), max)
+		},
+	})
+	props.Register(&Property{Name: "brightness-max", Type: PropertyInt,
+		Get: func() interface{} {
+			_, max := b.Limits()
+			return max
+		},
+		Set: func(value interface{}) error {
+			min, _ := b.Limits()
+			return b.SetLimits(min, value.(int))
+		},
+	})
+	props.Register(&Property{Name: "brightness-fade", Type: PropertyDuration,
+		Get: func() interface{} {
+			b.lock.Lock()
+			defer b.lock.Unlock()
+			return b.Fade
+		},
+		Set: func(value interface{}) error {
+			if value.(time.Duration) < 0 {
+				return errors.New("Brightness fade must not be negative")
+			}
+			b.lock.Lock()
+			defer b.lock.Unlock()
+			b.Fade = value.(time.Duration)
+			return nil
+		},
+	})
+	props.Register(&Property{Name: "brightness-devices", Type: PropertyString,
+		Get: func() interface{} { return b.deviceLevels() },
+		Set: func(value interface{}) error {
+			levels, err := parseDeviceLevels(value.(string))
+			if err != nil {
+				return err
+			}
+			for _, level := range levels {
+				if err := b.SetDevice(level.device, level.percent); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// deviceLevel is a device's level from a `device=level` pair.
+type deviceLevel struct {
+	device  string
+	percent int
+}
+
+// parseDeviceLevels parses and checks `device=level,...` pairs.
+func parseDeviceLevels(value string) ([]deviceLevel, error) {
+	levels := []deviceLevel{}
+	for _, item := range strings.Split(value, ",") {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || len(strings.TrimSpace(pair[0])) == 0 {
+			return nil, errors.New("Device brightness must be device=level: " + item)
+		}
+		percent, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+		if err != nil {
+			return nil, errors.New("Device brightness was not an integer: " + item)
+		}
+		if err = checkPercent("Device brightness", percent); err != nil {
+			return nil, err
+		}
+		levels = append(levels, deviceLevel{strings.TrimSpace(pair[0]), percent})
+	}
+	return levels, nil
+}
+
+// deviceLevels encodes the device levels in device order.
+func (b *Brightness) deviceLevels() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	devices := []string{}
+	for device, level := range b.devices {
+		devices = append(devices, device+"="+strconv.Itoa(int(level.to)))
+	}
+	sort.Strings(devices)
+	return strings.Join(devices, ",")
+}
+
+// level computes a device's limited level. The caller must hold the lock.
+func (b *Brightness) level(device string, now time.Time) float64 {
+	level := b.master.at(now, b.Fade)
+	if scale, ok := b.devices[device]; ok {
+		level = level * scale.at(now, b.Fade) / 100
+	}
+	if level <= 0 {
+		return 0
+	}
+	return math.Min(math.Max(level, float64(b.min)), float64(b.max))
+}
+
+// factor returns the channel scale for a device's current level.
+func (b *Brightness) factor(device string) float64 {
+	now := b.now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	gamma := b.Gamma
+	if gamma <= 0 {
+		gamma = 1
+	}
+	return math.Pow(b.level(device, now)/100, gamma)
+}
+
+// now returns the current time from the controller's clock.
+func (b *Brightness) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
+
+// checkPercent checks a level is a percentage.
+func checkPercent(name string, percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("%s must be between 0 and 100 - found %d", name, percent)
+	}
+	return nil
+}
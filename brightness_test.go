@@ -0,0 +1,130 @@
+package lights_test
+
+import (
+	"image/color"
+	"strings"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Brightness", func() {
+		white := color.RGBA{0xff, 0xff, 0xff, 0}
+		var (
+			now        time.Time
+			brightness *lights.Brightness
+		)
+
+		BeforeEach(func() {
+			now = time.Date(2015, 7, 4, 20, 0, 0, 0, time.UTC)
+			brightness = lights.NewBrightness()
+			brightness.Now = func() time.Time { return now }
+		})
+
+		It("should scale devices by the master level", func() {
+			brightness.Fade = 0
+			brightness.Gamma = 1
+			Ω(brightness.ApplyColor("1", white)).Should(Equal(white))
+			Ω(brightness.SetMaster(50)).Should(Succeed())
+			Ω(brightness.ApplyColor("1", white)).Should(Equal(color.RGBA{0x80, 0x80, 0x80, 0}))
+			Ω(brightness.SetDevice("1", 50)).Should(Succeed())
+			Ω(brightness.Level("1")).Should(BeNumerically("~", 25))
+			Ω(brightness.Level("2")).Should(BeNumerically("~", 50))
+			Ω(brightness.Apply("1", []color.RGBA{white, {0x80, 0, 0, 0}})).Should(Equal([]color.RGBA{{0x40, 0x40, 0x40, 0}, {0x20, 0, 0, 0}}))
+			Ω(brightness.SetMaster(101)).ShouldNot(Succeed())
+			Ω(brightness.SetDevice("1", -1)).ShouldNot(Succeed())
+			Ω(brightness.Master()).Should(Equal(50))
+			Ω(brightness.Device("1")).Should(Equal(50))
+			Ω(brightness.Device("2")).Should(Equal(100))
+		})
+
+		It("should limit the level of devices that are on", func() {
+			brightness.Fade = 0
+			Ω(brightness.SetLimits(10, 80)).Should(Succeed())
+			Ω(brightness.Level("1")).Should(BeNumerically("~", 80))
+			Ω(brightness.SetMaster(5)).Should(Succeed())
+			Ω(brightness.Level("1")).Should(BeNumerically("~", 10))
+			Ω(brightness.SetMaster(0)).Should(Succeed())
+			Ω(brightness.Level("1")).Should(BeZero())
+			Ω(brightness.ApplyColor("1", white)).Should(Equal(color.RGBA{}))
+			Ω(brightness.SetLimits(50, 40)).ShouldNot(Succeed())
+			Ω(brightness.SetLimits(0, 101)).ShouldNot(Succeed())
+		})
+
+		It("should dim perceptually", func() {
+			brightness.Fade = 0
+			Ω(brightness.SetMaster(50)).Should(Succeed())
+			half := brightness.ApplyColor("1", white)
+			Ω(half.R).Should(BeNumerically("<", 0x40))
+			Ω(half.R).Should(BeNumerically(">", 0x30))
+		})
+
+		It("should fade between levels", func() {
+			brightness.Gamma = 1
+			Ω(brightness.SetMaster(0)).Should(Succeed())
+			Ω(brightness.Level("1")).Should(BeNumerically("~", 100))
+			now = now.Add(lights.DefaultBrightnessFade / 2)
+			Ω(brightness.Level("1")).Should(BeNumerically("~", 50))
+			Ω(brightness.SetMaster(100)).Should(Succeed())
+			Ω(brightness.Level("1")).Should(BeNumerically("~", 50))
+			now = now.Add(lights.DefaultBrightnessFade)
+			Ω(brightness.Level("1")).Should(BeNumerically("~", 100))
+		})
+
+		It("should be adjustable with property commands", func() {
+			props := lights.NewProperties()
+			brightness.Install(props)
+			d := lights.NewDispatcher()
+			props.Install(d)
+			Ω(d.Dispatch("?-brightness")).Should(Equal("brightness|int|100"))
+			Ω(d.Dispatch("!-brightness|40")).Should(Equal("brightness|int|40"))
+			Ω(d.Dispatch("!-brightness-fade|2s")).Should(Equal("brightness-fade|duration|2s"))
+			Ω(d.Dispatch("!-brightness-min|5")).Should(Equal("brightness-min|int|5"))
+			Ω(d.Dispatch("!-brightness-max|90")).Should(Equal("brightness-max|int|90"))
+			Ω(d.Dispatch("!-brightness-devices|3=50")).Should(Equal("brightness-devices|string|3=50"))
+			Ω(d.Dispatch("!-brightness-devices|1=20")).Should(Equal("brightness-devices|string|1=20,3=50"))
+			Ω(brightness.Device("3")).Should(Equal(50))
+			Ω(d.Dispatch("!-brightness-devices|1=30,2=60")).Should(Equal("brightness-devices|string|1=30,2=60,3=50"))
+			levels, err := d.Dispatch("?-brightness-devices")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(d.Dispatch("!-brightness-devices|" + strings.TrimPrefix(levels, "brightness-devices|string|"))).Should(Equal(levels))
+			for _, bad := range []string{"!-brightness|120", "!-brightness-min|95", "!-brightness-fade|-1s", "!-brightness-devices|3", "!-brightness-devices|3=x", "!-brightness-devices|1=10,2=101", "!-brightness-devices|1=10,"} {
+				_, err := d.Dispatch(bad)
+				Ω(err).Should(HaveOccurred(), bad)
+			}
+			Ω(brightness.Device("1")).Should(Equal(30))
+		})
+
+		It("should dim rendered output", func() {
+			brightness.Fade = 0
+			brightness.Gamma = 1
+			Ω(brightness.SetMaster(50)).Should(Succeed())
+
+			player := lights.NewPlayer(1)
+			player.Brightness = brightness
+			p, _ := lights.NewPattern(":ab|#FFF")
+			player.Play(p)
+			Ω(player.Frame()).Should(Equal([]color.RGBA{{0x80, 0x80, 0x80, 0}}))
+
+			Ω(brightness.SetDevice("strip", 0)).Should(Succeed())
+			compositor := lights.NewCompositor(1)
+			compositor.Brightness = brightness
+			compositor.Push(&lights.Layer{Pattern: p, Opacity: 1, Blend: "normal"})
+			Ω(compositor.Frame()).Should(Equal([]color.RGBA{{0x80, 0x80, 0x80, 0}}))
+			compositor.Device = "strip"
+			Ω(compositor.Frame()).Should(Equal([]color.RGBA{{}}))
+
+			engine := lights.NewSceneEngine(&lights.MockStore{})
+			engine.Brightness = brightness
+			scene, _ := lights.NewScene("1|#FFF,0|1")
+			_, err := engine.Execute(scene)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(engine.Output("1")).Should(Equal(color.RGBA{0x80, 0x80, 0x80, 0}))
+			Ω(engine.Outputs()).Should(Equal(map[string]color.Color{"1": color.RGBA{0x80, 0x80, 0x80, 0}}))
+		})
+	})
+})
@@ -45,3 +45,40 @@ func ParseColorCode(colorCode string) (color.Color, error) {
 		return nil, fmt.Errorf("Color code must be 4 or 6 characters - found %d", len(colorCode))
 	}
 }
+
+// FormatColorCode formats a color as an upper case #RRGGBB color code.
+func FormatColorCode(c color.Color) string {
+	r, g, b := rgb(c)
+	return fmt.Sprintf("#%02X%02X%02X", r, g, b)
+}
+
+// MixColors blends from one color to another where t is between 0 (from)
+// and 1 (to). A nil color is treated as black.
+func MixColors(from, to color.Color, t float64) color.RGBA {
+	switch {
+	case t <= 0:
+		t = 0
+	case t >= 1:
+		t = 1
+	}
+	var r1, g1, b1, r2, g2, b2 byte
+	if from != nil {
+		r1, g1, b1 = rgb(from)
+	}
+	if to != nil {
+		r2, g2, b2 = rgb(to)
+	}
+	mix := func(a, b byte) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*t + 0.5)
+	}
+	return color.RGBA{mix(r1, r2), mix(g1, g2), mix(b1, b2), 0}
+}
+
+// rgb returns the 8-bit red, green and blue channels of a color.
+func rgb(c color.Color) (byte, byte, byte) {
+	if rgba, ok := c.(color.RGBA); ok {
+		return rgba.R, rgba.G, rgba.B
+	}
+	r, g, b, _ := c.RGBA()
+	return byte(r >> 8), byte(g >> 8), byte(b >> 8)
+}
@@ -0,0 +1,237 @@
+package lights
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlendModes lists the blend modes of compositor layers.
+var BlendModes = []string{"normal", "add", "multiply", "screen", "max"}
+
+// Layer is a pattern playing in a Compositor. Layers are drawn in priority
+// order (earlier layers first among equal priorities, so later layers end up
+// on top) with their opacity and blend mode. Layers are identified by their
+// pattern ID.
+type Layer struct {
+	Pattern  *Pattern
+	Priority int
+	Opacity  float64 // 0 (invisible) to 1
+	Blend    string
+
+	start time.Time
+	order int
+}
+
+// NewLayer creates a layer for a pattern from layer options of the form
+// `priority[,opacity[,blend]]`. Options default to priority 0, opacity 1
+// and the normal blend mode.
+func NewLayer(p *Pattern, options string) (*Layer, error) {
+	l := &Layer{Pattern: p, Opacity: 1, Blend: "normal"}
+	items := strings.Split(options, ",")
+	if len(items) > 3 {
+		return nil, errors.New("Layer options must be priority,opacity,blend: " + options)
+	}
+	var err error
+	if value := strings.TrimSpace(items[0]); len(value) > 0 {
+		if l.Priority, err = strconv.Atoi(value); err != nil {
+			return nil, errors.New("Layer priority was not an integer: " + options)
+		}
+	}
+	if len(items) > 1 && len(strings.TrimSpace(items[1])) > 0 {
+		l.Opacity, err = strconv.ParseFloat(strings.TrimSpace(items[1]), 64)
+		if err != nil || !(l.Opacity >= 0 && l.Opacity <= 1) {
+			return nil, errors.New("Layer opacity must be between 0 and 1: " + options)
+		}
+	}
+	if len(items) > 2 && len(strings.TrimSpace(items[2])) > 0 {
+		l.Blend = strings.TrimSpace(items[2])
+	}
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Validate checks the layer has a pattern, an opacity between 0 and 1 and a
+// known blend mode.
+func (l *Layer) Validate() error {
+	if l.Pattern == nil {
+		return errors.New("Layer has no pattern")
+	}
+	if !(l.Opacity >= 0 && l.Opacity <= 1) {
+		return fmt.Errorf("Layer opacity must be between 0 and 1 - found %g", l.Opacity)
+	}
+	for _, mode := range BlendModes {
+		if l.Blend == mode {
+			return nil
+		}
+	}
+	return errors.New("Unknown blend mode: " + l.Blend)
+}
+
+// Options returns the canonical layer options.
+func (l *Layer) Options() string {
+	return strconv.Itoa(l.Priority) + "," + strconv.FormatFloat(l.Opacity, 'g', -1, 64) + "," + l.Blend
+}
+
+// Compositor stacks patterns as layers on a strip of pixels so a pattern
+// (an alert for example) can temporarily override another without losing
+// it. Every layer plays from when it was pushed, and layers expire when
+// their pattern's loops finish.
+type Compositor struct {
+	Pixels     int
+	Device     string           // Device ID used for per-device brightness
+	Brightness *Brightness      // Dims frames if set
+	Now        func() time.Time // Clock used for playback (time.Now if nil)
+
+	lock   sync.Mutex
+	layers []*Layer
+	pushed int
+}
+
+// NewCompositor creates a compositor for a strip of pixels.
+func NewCompositor(pixels int) *Compositor {
+	return &Compositor{Pixels: pixels}
+}
+
+// Push starts playing a layer, replacing any layer with the same pattern ID.
+func (c *Compositor) Push(l *Layer) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	now := c.now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.remove(l.Pattern.ID)
+	c.pushed++
+	l.start, l.order = now, c.pushed
+	c.layers = append(c.layers, l)
+	sort.SliceStable(c.layers, func(i, j int) bool {
+		if c.layers[i].Priority != c.layers[j].Priority {
+			return c.layers[i].Priority < c.layers[j].Priority
+		}
+		return c.layers[i].order < c.layers[j].order
+	})
+	return nil
+}
+
+// Remove stops a layer, returning false if there was no such layer.
+func (c *Compositor) Remove(id string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.remove(id)
+}
+
+// Layers returns copies of the playing layers from the bottom up.
+func (c *Compositor) Layers() []*Layer {
+	now := c.now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.expire(now)
+	layers := make([]*Layer, len(c.layers))
+	for i, l := range c.layers {
+		copied := *l
+		layers[i] = &copied
+	}
+	return layers
+}
+
+// Frame renders the layers over black (at the device brightness) and
+// returns whether no layers are left playing.
+func (c *Compositor) Frame() ([]color.RGBA, bool) {
+	now := c.now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pixels := c.Pixels
+	if pixels < 0 {
+		pixels = 0
+	}
+	frame := make([]color.RGBA, pixels)
+	playing := c.layers[:0]
+	for _, l := range c.layers {
+		src, finished := l.Pattern.Frame(now.Sub(l.start), pixels)
+		if finished {
+			continue
+		}
+		for i := range frame {
+			frame[i] = Blend(l.Blend, frame[i], src[i], l.Opacity)
+		}
+		playing = append(playing, l)
+	}
+	c.layers = playing
+	if c.Brightness != nil {
+		frame = c.Brightness.Apply(c.Device, frame)
+	}
+	return frame, len(playing) == 0
+}
+
+// Run renders a frame every tick until stop is closed or render returns an
+// error.
+func (c *Compositor) Run(tick time.Duration, stop <-chan struct{}, render func(frame []color.RGBA) error) error {
+	return runFrames(tick, stop, c.Frame, render)
+}
+
+// remove drops a layer. The caller must hold the lock.
+func (c *Compositor) remove(id string) bool {
+	for i, l := range c.layers {
+		if l.Pattern.ID == id {
+			c.layers = append(c.layers[:i], c.layers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// expire drops layers whose patterns have finished. The caller must hold
+// the lock.
+func (c *Compositor) expire(now time.Time) {
+	playing := c.layers[:0]
+	for _, l := range c.layers {
+		if duration := l.Pattern.Duration(); duration < 0 || now.Sub(l.start) < duration {
+			playing = append(playing, l)
+		}
+	}
+	c.layers = playing
+}
+
+// now returns the current time from the compositor's clock.
+func (c *Compositor) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// Blend draws src over dst with a blend mode and opacity (0 to 1). Unknown
+// modes blend normally.
+func Blend(mode string, dst, src color.RGBA, opacity float64) color.RGBA {
+	channel := func(d, s uint8) float64 {
+		a, b := float64(d)/255, float64(s)/255
+		switch mode {
+		case "add":
+			return math.Min(1, a+b)
+		case "multiply":
+			return a * b
+		case "screen":
+			return 1 - (1-a)*(1-b)
+		case "max":
+			return math.Max(a, b)
+		default: // "normal"
+			return b
+		}
+	}
+	blended := color.RGBA{
+		uint8(channel(dst.R, src.R)*255 + 0.5),
+		uint8(channel(dst.G, src.G)*255 + 0.5),
+		uint8(channel(dst.B, src.B)*255 + 0.5),
+		0,
+	}
+	return MixColors(dst, blended, opacity)
+}
@@ -0,0 +1,127 @@
+package lights_test
+
+import (
+	"image/color"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Compositor", func() {
+		red := color.RGBA{0xff, 0, 0, 0}
+		blue := color.RGBA{0, 0, 0xff, 0}
+		grey := color.RGBA{0x80, 0x80, 0x80, 0}
+		var (
+			now        time.Time
+			compositor *lights.Compositor
+		)
+
+		BeforeEach(func() {
+			now = time.Date(2015, 7, 4, 20, 0, 0, 0, time.UTC)
+			compositor = lights.NewCompositor(2)
+			compositor.Now = func() time.Time { return now }
+		})
+
+		// push plays a pattern as a layer with the options.
+		push := func(spec, options string) error {
+			p, err := lights.NewPattern(spec)
+			Ω(err).ShouldNot(HaveOccurred())
+			l, err := lights.NewLayer(p, options)
+			if err != nil {
+				return err
+			}
+			return compositor.Push(l)
+		}
+
+		// ids lists the playing layers from the bottom up.
+		ids := func() []string {
+			found := []string{}
+			for _, l := range compositor.Layers() {
+				found = append(found, l.Pattern.ID)
+			}
+			return found
+		}
+
+		It("should blend colors", func() {
+			Ω(lights.Blend("normal", red, blue, 1)).Should(Equal(blue))
+			Ω(lights.Blend("normal", red, blue, 0)).Should(Equal(red))
+			Ω(lights.Blend("normal", red, blue, 0.5)).Should(Equal(color.RGBA{0x80, 0, 0x80, 0}))
+			Ω(lights.Blend("add", red, blue, 1)).Should(Equal(color.RGBA{0xff, 0, 0xff, 0}))
+			Ω(lights.Blend("add", grey, grey, 1)).Should(Equal(color.RGBA{0xff, 0xff, 0xff, 0}))
+			Ω(lights.Blend("multiply", grey, red, 1)).Should(Equal(color.RGBA{0x80, 0, 0, 0}))
+			Ω(lights.Blend("screen", grey, grey, 1)).Should(Equal(color.RGBA{0xc0, 0xc0, 0xc0, 0}))
+			Ω(lights.Blend("max", grey, red, 1)).Should(Equal(color.RGBA{0xff, 0x80, 0x80, 0}))
+		})
+
+		It("should parse layer options", func() {
+			p, _ := lights.NewPattern(":ab|#F00")
+			l, err := lights.NewLayer(p, "")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(l.Options()).Should(Equal("0,1,normal"))
+			l, err = lights.NewLayer(p, "10, 0.5, screen")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(l.Priority).Should(Equal(10))
+			Ω(l.Opacity).Should(Equal(0.5))
+			Ω(l.Blend).Should(Equal("screen"))
+			for _, options := range []string{"x", "1,2", "1,0.5,burn", "1,1,normal,4"} {
+				_, err := lights.NewLayer(p, options)
+				Ω(err).Should(HaveOccurred(), options)
+			}
+		})
+
+		It("should stack layers by priority", func() {
+			Ω(push(":alert|#F00", "10,1,normal")).Should(Succeed())
+			Ω(push(":ambient|#00F", "0")).Should(Succeed())
+			Ω(compositor.Frame()).Should(Equal([]color.RGBA{red, red}))
+			Ω(ids()).Should(Equal([]string{"ambient", "alert"}))
+			Ω(compositor.Layers()[1].Options()).Should(Equal("10,1,normal"))
+
+			Ω(push(":alert|#F00", "10,0.5,add")).Should(Succeed())
+			Ω(compositor.Frame()).Should(Equal([]color.RGBA{{0x80, 0, 0xff, 0}, {0x80, 0, 0xff, 0}}))
+			Ω(compositor.Remove("alert")).Should(BeTrue())
+			Ω(compositor.Frame()).Should(Equal([]color.RGBA{blue, blue}))
+			Ω(compositor.Remove("alert")).Should(BeFalse())
+
+			Ω(compositor.Push(&lights.Layer{Blend: "normal", Opacity: 1})).ShouldNot(Succeed())
+			p, _ := lights.NewPattern(":ab|#F00")
+			Ω(compositor.Push(&lights.Layer{Pattern: p, Blend: "burn", Opacity: 1})).ShouldNot(Succeed())
+			Ω(ids()).Should(Equal([]string{"ambient"}))
+		})
+
+		It("should expire layers when their loops finish", func() {
+			Ω(push(":ambient|#00F", "0")).Should(Succeed())
+			now = now.Add(10 * time.Second)
+			Ω(push(":blink:2|#F00,0,0.5|#000,0,0.5", "5")).Should(Succeed())
+			layers := compositor.Layers()
+			Ω(layers).Should(HaveLen(2))
+			layers[1].Opacity = 0
+			Ω(compositor.Frame()).Should(Equal([]color.RGBA{red, red}))
+			now = now.Add(1500 * time.Millisecond)
+			Ω(compositor.Frame()).Should(Equal([]color.RGBA{{}, {}}))
+			now = now.Add(500 * time.Millisecond)
+			frame, done := compositor.Frame()
+			Ω(frame).Should(Equal([]color.RGBA{blue, blue}))
+			Ω(done).Should(BeFalse())
+			Ω(ids()).Should(Equal([]string{"ambient"}))
+
+			compositor.Remove("ambient")
+			frame, done = compositor.Frame()
+			Ω(frame).Should(Equal([]color.RGBA{{}, {}}))
+			Ω(done).Should(BeTrue())
+		})
+
+		It("should layer effects", func() {
+			Ω(push(":ambient|#F00", "0")).Should(Succeed())
+			Ω(push(":fx.strobe:1|color=#00F", "1,1,max")).Should(Succeed())
+			Ω(compositor.Frame()).Should(Equal([]color.RGBA{{0xff, 0, 0xff, 0}, {0xff, 0, 0xff, 0}}))
+			now = now.Add(60 * time.Millisecond)
+			Ω(compositor.Frame()).Should(Equal([]color.RGBA{red, red}))
+			now = now.Add(time.Second)
+			Ω(compositor.Layers()).Should(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,160 @@
+package lights
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// started records when the agent started for the uptime property.
+var started = time.Now()
+
+// CommandFunc handles a parsed command returning an optional response.
+type CommandFunc func(cmd *Command) (string, error)
+
+// UnsupportedError is returned by a Dispatcher for commands that have no
+// registered handler.
+type UnsupportedError struct {
+	Action string
+	Type   string
+	ID     string
+}
+
+// Error describes the unsupported command.
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("Unsupported %s %s command: %s", e.Action, e.Type, e.ID)
+}
+
+// Dispatcher parses incoming messages and routes each command to the handler
+// registered for its action and type, replacing the switch every agent would
+// otherwise write. Queries for properties (`?-name`) that have no handler
+// are answered with the built-in `id`, `uptime`, `commands` and
+// `capabilities` properties (install a Properties registry to expose more).
+// Register a dispatcher with a Worker using:
+//
+//	worker.Responder("/command", dispatcher.Dispatch)
+type Dispatcher struct {
+	lock     sync.RWMutex
+	handlers map[string]CommandFunc
+}
+
+// NewDispatcher creates a dispatcher with no handlers.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: map[string]CommandFunc{}}
+}
+
+// On registers the handler for commands with an action and type (for
+// example "execute" and "pattern"), replacing any existing handler.
+func (d *Dispatcher) On(action, commandType string, fn CommandFunc) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.handlers == nil {
+		d.handlers = map[string]CommandFunc{}
+	}
+	d.handlers[action+" "+commandType] = fn
+}
+
+// OnExecute registers the handler for `!` commands of a type.
+func (d *Dispatcher) OnExecute(commandType string, fn CommandFunc) {
+	d.On("execute", commandType, fn)
+}
+
+// OnAdd registers the handler for `+` commands of a type.
+func (d *Dispatcher) OnAdd(commandType string, fn CommandFunc) {
+	d.On("add", commandType, fn)
+}
+
+// OnRemove registers the handler for `-` commands of a type.
+func (d *Dispatcher) OnRemove(commandType string, fn CommandFunc) {
+	d.On("remove", commandType, fn)
+}
+
+// OnQuery registers the handler for `?` commands of a type.
+func (d *Dispatcher) OnQuery(commandType string, fn CommandFunc) {
+	d.On("query", commandType, fn)
+}
+
+// Skipped is the response to commands from a newer protocol version that
+// use codes this agent does not know.
+const Skipped = "SKIPPED"
+
+// Dispatch parses a message and passes the command to its handler,
+// returning the handler's response. An UnsupportedError is returned for
+// commands without a handler. Commands with unknown codes from a newer
+// protocol version are skipped rather than rejected.
+func (d *Dispatcher) Dispatch(message string) (string, error) {
+	cmd, err := NewCommand(message)
+	if unknown, ok := err.(*UnknownCodeError); ok && unknown.Newer() {
+		log.Println("Skipping command from protocol version", unknown.Version, err)
+		return Skipped, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	d.lock.RLock()
+	fn, ok := d.handlers[cmd.Action+" "+cmd.Type]
+	d.lock.RUnlock()
+	switch {
+	case ok:
+		return fn(cmd)
+	case cmd.Action == "query" && cmd.Type == "property":
+		return d.queryProperty(cmd)
+	default:
+		return "", &UnsupportedError{cmd.Action, cmd.Type, cmd.ID}
+	}
+}
+
+// Handle dispatches a message discarding the response so a dispatcher can
+// be used as a WorkerFunc.
+func (d *Dispatcher) Handle(message string) error {
+	_, err := d.Dispatch(message)
+	return err
+}
+
+// Commands lists the registered action and type pairs in sorted order.
+func (d *Dispatcher) Commands() []string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	commands := []string{}
+	for key := range d.handlers {
+		commands = append(commands, key)
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+// Capabilities describes the command types and handlers of the dispatcher.
+func (d *Dispatcher) Capabilities() *Capabilities {
+	commands := d.Commands()
+	if !matchList(commands, "query property") {
+		commands = append(commands, "query property")
+		sort.Strings(commands)
+	}
+	return &Capabilities{ProtocolVersion, CommandTypes(), commands}
+}
+
+// queryProperty answers the built-in property queries encoded as property
+// values like the queries answered by Properties.
+func (d *Dispatcher) queryProperty(cmd *Command) (string, error) {
+	value := &PropertyValue{Name: cmd.ID, Type: PropertyString}
+	switch cmd.ID {
+	case "id":
+		id, err := NewID()
+		if err != nil {
+			return "", err
+		}
+		value.Value = id.ID
+	case "uptime":
+		value.Type, value.Value = PropertyDuration, time.Since(started).Truncate(time.Second)
+	case "commands":
+		value.Value = strings.Join(d.Commands(), ",")
+	case "capabilities":
+		value.Value = d.Capabilities().String()
+	default:
+		return "", &UnsupportedError{cmd.Action, cmd.Type, cmd.ID}
+	}
+	return value.String(), nil
+}
@@ -0,0 +1,97 @@
+package lights_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Dispatcher", func() {
+		var d *lights.Dispatcher
+		var seen []*lights.Command
+
+		BeforeEach(func() {
+			d = lights.NewDispatcher()
+			seen = []*lights.Command{}
+			record := func(cmd *lights.Command) (string, error) {
+				seen = append(seen, cmd)
+				return "", nil
+			}
+			d.OnExecute("pattern", record)
+			d.OnAdd("pattern", record)
+			d.OnQuery("pattern", func(cmd *lights.Command) (string, error) {
+				return ":" + cmd.ID + "|#F00", nil
+			})
+		})
+
+		It("should route commands by action and type", func() {
+			Ω(d.Dispatch("!:ab|#F00,2,1")).Should(BeEmpty())
+			Ω(d.Handle("+:cd|#0F0")).Should(Succeed())
+			Ω(seen).Should(HaveLen(2))
+			Ω(seen[0].Action).Should(Equal("execute"))
+			Ω(seen[0].ID).Should(Equal("ab"))
+			Ω(seen[1].Action).Should(Equal("add"))
+			Ω(d.Dispatch("?:ab")).Should(Equal(":ab|#F00"))
+		})
+
+		It("should reject unknown and unsupported commands", func() {
+			_, err := d.Dispatch("*:ab")
+			Ω(err).Should(HaveOccurred())
+			_, err = d.Dispatch("-:ab")
+			Ω(err).Should(Equal(&lights.UnsupportedError{Action: "remove", Type: "pattern", ID: "ab"}))
+			_, err = d.Dispatch("!#F00")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.UnsupportedError{}))
+			Ω(seen).Should(BeEmpty())
+		})
+
+		It("should pass handler errors through", func() {
+			failed := errors.New("failed")
+			d.OnExecute("color", func(cmd *lights.Command) (string, error) {
+				return "", failed
+			})
+			Ω(d.Handle("!#F00")).Should(Equal(failed))
+		})
+
+		It("should answer built-in property queries", func() {
+			Ω(d.Dispatch("?-uptime")).ShouldNot(BeEmpty())
+			Ω(d.Dispatch("?-commands")).Should(Equal("commands|string|add pattern,execute pattern,query pattern"))
+			response, err := d.Dispatch("?-uptime")
+			Ω(err).ShouldNot(HaveOccurred())
+			value, err := lights.ParsePropertyValue(response)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(value.Type).Should(Equal(lights.PropertyDuration))
+			_, err = d.Dispatch("?-missing")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.UnsupportedError{}))
+			d.OnQuery("property", func(cmd *lights.Command) (string, error) {
+				return "custom " + cmd.ID, nil
+			})
+			Ω(d.Dispatch("?-uptime")).Should(Equal("custom uptime"))
+		})
+
+		It("should respond to worker requests", func() {
+			w, err := lights.NewWorker("gateway")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(w.Responder("/test/dispatch", d.Dispatch)).Should(Succeed())
+			post := func(body string) *httptest.ResponseRecorder {
+				resp := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", "/test/dispatch", strings.NewReader(body))
+				http.DefaultServeMux.ServeHTTP(resp, req)
+				return resp
+			}
+			resp := post("?:ab")
+			Ω(resp.Code).Should(Equal(http.StatusOK))
+			Ω(resp.Body.String()).Should(Equal(":ab|#F00"))
+			Ω(post("!:ab|#F00").Body.String()).Should(Equal("OK"))
+			resp = post("-:ab")
+			Ω(resp.Code).Should(Equal(http.StatusInternalServerError))
+			Ω(resp.Body.String()).Should(ContainSubstring("Unsupported remove pattern"))
+		})
+	})
+})
@@ -34,5 +34,29 @@ it is better to set the nsqlookupd address manually. The environment variable
 can contain more than one address separated by commas. For example:
 
 	INC_NSQLOOKUPD=192.168.0.64:4161,192.168.0.61:4161
+
+### INC_STORE
+
+Selects the Store implementation returned by NewStore. The default is a
+FileStore in /var/lib/inception/lighting/data. Use `file:<folder>` for a
+FileStore in another folder, `log:<file>` for a single-file LogStore
+that supports atomic batches or `memory:[<file>]` for a MemoryStore that
+is optionally snapshotted to a file. For example:
+
+	INC_STORE=log:/var/lib/inception/lighting/data.log
+
+### INC_STORE_KEY
+
+Names a file holding a device secret. When set, NewStore encrypts every
+stored value with AES-GCM using a key derived from the secret. Keep the
+file readable only by the agents.
+
+### INC_STORE_PLAINTEXT
+
+Set to `true` to keep reading values stored before INC_STORE_KEY was set.
+Without it, existing plaintext values can not be read once encryption is
+enabled. To migrate an existing install, set both variables, call Rotate on
+the EncryptedStore returned by NewStore to encrypt every value, then unset
+INC_STORE_PLAINTEXT so plaintext values are rejected again.
 */
 package lights
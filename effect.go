@@ -0,0 +1,292 @@
+package lights
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EffectPrefix is the reserved pattern ID prefix of built-in effects. An
+// effect pattern's ID is the prefix, the effect name and optionally a
+// `.name` so several configurations of one effect can be stored, for
+// example `fx.candle` or `fx.candle.desk`.
+const EffectPrefix = "fx."
+
+// Effects lists the built-in procedural effects.
+var Effects = []string{"rainbow", "breathe", "candle", "strobe", "twinkle", "fire", "noise"}
+
+// effectParam is an effect specific parameter with its default and range.
+type effectParam struct {
+	value, min, max float64
+}
+
+// effectDefaults holds each effect's default period, base color and
+// parameters.
+var effectDefaults = map[string]struct {
+	period time.Duration
+	color  color.RGBA
+	params map[string]effectParam
+}{
+	"rainbow": {10 * time.Second, color.RGBA{0xff, 0xff, 0xff, 0}, map[string]effectParam{"spread": {1, 0, 100}}},
+	"breathe": {4 * time.Second, color.RGBA{0xff, 0xff, 0xff, 0}, map[string]effectParam{"min": {0, 0, 1}}},
+	"candle":  {100 * time.Millisecond, color.RGBA{0xff, 0x93, 0x29, 0}, map[string]effectParam{"depth": {0.4, 0, 1}}},
+	"strobe":  {100 * time.Millisecond, color.RGBA{0xff, 0xff, 0xff, 0}, map[string]effectParam{"duty": {0.5, 0, 1}}},
+	"twinkle": {time.Second, color.RGBA{0xff, 0xff, 0xff, 0}, map[string]effectParam{"density": {0.2, 0, 1}}},
+	"fire":    {500 * time.Millisecond, color.RGBA{0xff, 0x60, 0, 0}, map[string]effectParam{"height": {0.6, 0, 1}}},
+	"noise":   {2 * time.Second, color.RGBA{0xff, 0xff, 0xff, 0}, map[string]effectParam{"scale": {0.1, 0, 100}}},
+}
+
+// Effect is a procedural effect rendered from the time a pattern has played.
+// Effects are deterministic: the same seed renders the same frames. The
+// effect of a pattern is given as the first part after the pattern header,
+// as `name=value` parameters separated by `,`:
+//
+//	!:fx.candle|seed=7,depth=0.6
+//	!:fx.rainbow.slow:3|period=30s,spread=2
+//
+// The parameters every effect takes are period (how long one cycle or
+// flicker step lasts), seed, color (the base color) and mix (how much of
+// the effect is mixed over the base, 0 to 1). The effects and their own
+// parameters are:
+//
+//	rainbow  spread   hue cycle with spread rainbows across the strip
+//	breathe  min      brightness rises and falls down to min
+//	candle   depth    brightness flickers down by up to depth
+//	strobe   duty     on for duty of each period and then off
+//	twinkle  density  density of the pixels twinkle each period
+//	fire     height   flames fill height of the strip
+//	noise    scale    smooth noise with scale changes per pixel
+//
+// Effect patterns may also have slots after the parameters. The slots are
+// played as usual and provide the base colors the effect modulates (fire
+// and rainbow replace the base colors as far as mix allows).
+type Effect struct {
+	Name   string
+	Period time.Duration
+	Seed   int64
+	Color  color.Color // Base color without slots (nil for the effect's default)
+	Mix    float64
+	Params map[string]float64
+}
+
+// IsEffectID returns true if a pattern ID refers to a built-in effect.
+func IsEffectID(id string) bool {
+	return strings.HasPrefix(id, EffectPrefix)
+}
+
+// EffectName returns the effect name of an effect pattern ID.
+func EffectName(id string) string {
+	return strings.SplitN(strings.TrimPrefix(id, EffectPrefix), ".", 2)[0]
+}
+
+// ParseEffect parses the parameters of a named effect. Missing parameters
+// take the effect's defaults.
+func ParseEffect(name, spec string) (*Effect, error) {
+	defaults, ok := effectDefaults[name]
+	if !ok {
+		return nil, errors.New("Unknown effect: " + name)
+	}
+	e := &Effect{Name: name, Period: defaults.period, Mix: 1, Params: map[string]float64{}}
+	for param, p := range defaults.params {
+		e.Params[param] = p.value
+	}
+	if len(strings.TrimSpace(spec)) == 0 {
+		return e, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		pair := strings.SplitN(item, "=", 2)
+		key := strings.TrimSpace(pair[0])
+		if len(pair) != 2 {
+			return nil, errors.New("Effect parameter must be name=value: " + item)
+		}
+		value := strings.TrimSpace(pair[1])
+		var err error
+		switch key {
+		case "period":
+			if e.Period, err = ParseDuration(value); err == nil && e.Period <= 0 {
+				err = errors.New("Effect period must be positive")
+			}
+		case "seed":
+			e.Seed, err = strconv.ParseInt(value, 10, 64)
+		case "color":
+			e.Color, err = ParseColorCode(value)
+		case "mix":
+			e.Mix, err = parseEffectValue(value, effectParam{1, 0, 1})
+		default:
+			p, ok := defaults.params[key]
+			if !ok {
+				return nil, fmt.Errorf("Unknown %s effect parameter: %s", name, key)
+			}
+			e.Params[key], err = parseEffectValue(value, p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid effect parameter %s: %s", item, err)
+		}
+	}
+	return e, nil
+}
+
+// parseEffectValue parses a number in a parameter's range.
+func parseEffectValue(value string, p effectParam) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if !(f >= p.min && f <= p.max) {
+		return 0, fmt.Errorf("Value must be between %g and %g", p.min, p.max)
+	}
+	return f, nil
+}
+
+// String returns the canonical effect parameters.
+func (e *Effect) String() string {
+	items := []string{"period=" + e.Period.String(), "seed=" + strconv.FormatInt(e.Seed, 10)}
+	if e.Color != nil {
+		items = append(items, "color="+FormatColorCode(e.Color))
+	}
+	if e.Mix != 1 {
+		items = append(items, "mix="+strconv.FormatFloat(e.Mix, 'g', -1, 64))
+	}
+	params := []string{}
+	for param := range e.Params {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		items = append(items, param+"="+strconv.FormatFloat(e.Params[param], 'g', -1, 64))
+	}
+	return strings.Join(items, ",")
+}
+
+// base returns the base color of the effect.
+func (e *Effect) base() color.Color {
+	if e.Color != nil {
+		return e.Color
+	}
+	return effectDefaults[e.Name].color
+}
+
+// effectFrame renders an effect pattern. Patterns without slots render the
+// effect over its base color and finish after Loops periods.
+func (p *Pattern) effectFrame(elapsed time.Duration, pixels int) ([]color.RGBA, bool) {
+	if pixels < 0 {
+		pixels = 0
+	}
+	if duration := p.Duration(); duration >= 0 && elapsed > duration {
+		elapsed = duration
+	}
+	var frame []color.RGBA
+	finished := false
+	if len(p.Slots) > 0 {
+		frame, finished = p.slotsFrame(elapsed, pixels)
+	} else {
+		frame = make([]color.RGBA, pixels)
+		base := MixColors(nil, p.Effect.base(), 1)
+		for i := range frame {
+			frame[i] = base
+		}
+		duration := p.Duration()
+		finished = duration >= 0 && elapsed >= duration
+	}
+	p.Effect.render(frame, elapsed)
+	return frame, finished
+}
+
+// render applies the effect to a frame of base colors.
+func (e *Effect) render(frame []color.RGBA, elapsed time.Duration) {
+	t := 0.0
+	if e.Period > 0 {
+		t = float64(elapsed) / float64(e.Period)
+	}
+	n := float64(len(frame))
+	for i, base := range frame {
+		x := float64(i)
+		var out color.RGBA
+		switch e.Name {
+		case "rainbow":
+			out = hue(t + e.Params["spread"]*x/n)
+		case "breathe":
+			min := e.Params["min"]
+			out = scaleColor(base, min+(1-min)*(1-math.Cos(2*math.Pi*t))/2)
+		case "candle":
+			out = scaleColor(base, 1-e.Params["depth"]*effectNoise(e.Seed, x, t))
+		case "strobe":
+			if t-math.Floor(t) < e.Params["duty"] {
+				out = base
+			}
+		case "twinkle":
+			phase := t + effectHash(e.Seed, int64(i), -1)
+			cycle := math.Floor(phase)
+			if effectHash(e.Seed, int64(i), int64(cycle)) < e.Params["density"] {
+				out = scaleColor(base, math.Sin(math.Pi*(phase-cycle)))
+			}
+		case "fire":
+			height := e.Params["height"] * n
+			heat := effectNoise(e.Seed, x/2, t) * (1 - x/(height+1))
+			out = fireColor(heat)
+		case "noise":
+			out = scaleColor(base, effectNoise(e.Seed, x*e.Params["scale"], t))
+		}
+		frame[i] = MixColors(base, out, e.Mix)
+	}
+}
+
+// effectHash returns a deterministic pseudo random number in [0, 1) for a
+// seed and two coordinates.
+func effectHash(seed, x, y int64) float64 {
+	h := uint64(seed)*0x9e3779b97f4a7c15 ^ uint64(x)*0xbf58476d1ce4e5b9 ^ uint64(y)*0x94d049bb133111eb
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return float64(h>>11) / (1 << 53)
+}
+
+// effectNoise returns smooth value noise in [0, 1] at x (space) and t
+// (time).
+func effectNoise(seed int64, x, t float64) float64 {
+	x0, t0 := math.Floor(x), math.Floor(t)
+	fx, ft := Ease("ease", x-x0), Ease("ease", t-t0)
+	at := func(dx, dt float64) float64 {
+		return effectHash(seed, int64(x0+dx), int64(t0+dt))
+	}
+	top := at(0, 0) + (at(1, 0)-at(0, 0))*fx
+	bottom := at(0, 1) + (at(1, 1)-at(0, 1))*fx
+	return top + (bottom-top)*ft
+}
+
+// hue returns the fully saturated color of a hue (0 to 1, wrapping).
+func hue(h float64) color.RGBA {
+	h = (h - math.Floor(h)) * 6
+	channel := func(offset float64) uint8 {
+		k := math.Mod(offset+h, 6)
+		v := 1 - math.Max(0, math.Min(math.Min(k, 4-k), 1))
+		return uint8(v*255 + 0.5)
+	}
+	return color.RGBA{channel(5), channel(3), channel(1), 0}
+}
+
+// fireColor maps heat (0 to 1) to black, red, orange, yellow and white.
+func fireColor(heat float64) color.RGBA {
+	palette := []color.Color{
+		color.RGBA{}, color.RGBA{0xc0, 0, 0, 0}, color.RGBA{0xff, 0x60, 0, 0},
+		color.RGBA{0xff, 0xd0, 0x20, 0}, color.RGBA{0xff, 0xff, 0xc0, 0},
+	}
+	at := math.Max(0, math.Min(heat, 1)) * float64(len(palette)-1)
+	i := int(at)
+	if i >= len(palette)-1 {
+		return MixColors(nil, palette[i], 1)
+	}
+	return MixColors(palette[i], palette[i+1], at-float64(i))
+}
+
+// scaleColor scales a color's brightness by level (0 to 1).
+func scaleColor(c color.RGBA, level float64) color.RGBA {
+	return MixColors(nil, c, level)
+}
@@ -0,0 +1,171 @@
+package lights_test
+
+import (
+	"encoding/json"
+	"image/color"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Effects", func() {
+		red := color.RGBA{0xff, 0, 0, 0}
+		green := color.RGBA{0, 0xff, 0, 0}
+		blue := color.RGBA{0, 0, 0xff, 0}
+		white := color.RGBA{0xff, 0xff, 0xff, 0}
+		black := color.RGBA{}
+
+		frame := func(spec string, elapsed time.Duration, pixels int) []color.RGBA {
+			p, err := lights.NewPattern(spec)
+			Ω(err).ShouldNot(HaveOccurred())
+			f, _ := p.Frame(elapsed, pixels)
+			return f
+		}
+
+		It("should parse and format effect patterns", func() {
+			p, err := lights.NewPattern(":fx.candle.desk:2|seed=7, depth=0.6")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.ID).Should(Equal("fx.candle.desk"))
+			Ω(p.Slots).Should(BeEmpty())
+			Ω(p.Effect.Name).Should(Equal("candle"))
+			Ω(p.Effect.Seed).Should(Equal(int64(7)))
+			Ω(p.Effect.Period).Should(Equal(100 * time.Millisecond))
+			Ω(p.Effect.Params).Should(Equal(map[string]float64{"depth": 0.6}))
+			Ω(p.Validate()).Should(Succeed())
+			Ω(p.String()).Should(Equal(":fx.candle.desk:2|period=100ms,seed=7,depth=0.6"))
+			again, err := lights.NewPattern(p.String())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(again).Should(Equal(p))
+
+			p, err = lights.NewPattern(":fx.breathe|color=#F00,mix=0.5|#00F,1,1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.Slots).Should(HaveLen(1))
+			Ω(p.String()).Should(Equal(":fx.breathe|period=4s,seed=0,color=#FF0000,mix=0.5,min=0|#0000FF,1s,1s,ease"))
+
+			for _, name := range lights.Effects {
+				_, err := lights.NewPattern(":fx." + name)
+				Ω(err).ShouldNot(HaveOccurred(), name)
+			}
+			for _, spec := range []string{":fx.lava", ":fx.candle|duty=1", ":fx.strobe|duty=2", ":fx.rainbow|period=0", ":fx.noise|seed", ":fx.fire|color=red"} {
+				_, err := lights.NewPattern(spec)
+				Ω(err).Should(HaveOccurred(), spec)
+			}
+		})
+
+		It("should render deterministically for a seed", func() {
+			for _, name := range lights.Effects {
+				spec := ":fx." + name + "|seed=42"
+				Ω(frame(spec, 1234*time.Millisecond, 30)).Should(Equal(frame(spec, 1234*time.Millisecond, 30)), name)
+			}
+			Ω(frame(":fx.twinkle|seed=1", 0, 30)).ShouldNot(Equal(frame(":fx.twinkle|seed=2", 0, 30)))
+			Ω(frame(":fx.candle|seed=1", 50*time.Millisecond, 1)).ShouldNot(Equal(frame(":fx.candle|seed=2", 50*time.Millisecond, 1)))
+		})
+
+		It("should render effects", func() {
+			Ω(frame(":fx.rainbow", 0, 3)).Should(Equal([]color.RGBA{red, green, blue}))
+			Ω(frame(":fx.rainbow|spread=0", 10*time.Second/3, 2)).Should(Equal([]color.RGBA{green, green}))
+			Ω(frame(":fx.breathe", 0, 1)).Should(Equal([]color.RGBA{black}))
+			Ω(frame(":fx.breathe", 2*time.Second, 1)).Should(Equal([]color.RGBA{white}))
+			Ω(frame(":fx.breathe|min=1", 0, 1)).Should(Equal([]color.RGBA{white}))
+			Ω(frame(":fx.strobe|color=#F00", 0, 2)).Should(Equal([]color.RGBA{red, red}))
+			Ω(frame(":fx.strobe|color=#F00", 60*time.Millisecond, 2)).Should(Equal([]color.RGBA{black, black}))
+			Ω(frame(":fx.twinkle|density=0", 300*time.Millisecond, 5)).Should(Equal(make([]color.RGBA, 5)))
+
+			fire := frame(":fx.fire|height=0.5", 0, 20)
+			Ω(fire[15:]).Should(Equal(make([]color.RGBA, 5)))
+			for _, c := range fire {
+				Ω(c.B).Should(BeNumerically("<=", c.G))
+				Ω(c.G).Should(BeNumerically("<=", c.R))
+			}
+			for _, c := range frame(":fx.candle|depth=0.5", 250*time.Millisecond, 10) {
+				Ω(c.R).Should(BeNumerically(">=", 0x7f))
+				Ω(c.G).Should(BeNumerically("<=", 0x93))
+			}
+		})
+
+		It("should mix effects with slots", func() {
+			p, err := lights.NewPattern(":fx.breathe|period=2s|#F00,0,1|#00F,0,1")
+			Ω(err).ShouldNot(HaveOccurred())
+			c, _ := p.ColorAt(0)
+			Ω(c).Should(Equal(black))
+			c, _ = p.ColorAt(500 * time.Millisecond)
+			Ω(c.(color.RGBA).R).Should(BeNumerically("~", 0x80, 1))
+			Ω(c.(color.RGBA).B).Should(BeZero())
+			c, _ = p.ColorAt(time.Second)
+			Ω(c).Should(Equal(blue))
+			Ω(frame(":fx.strobe|mix=0.5|#F00,0,1", 60*time.Millisecond, 1)).Should(Equal([]color.RGBA{{0x80, 0, 0, 0}}))
+		})
+
+		It("should loop effect periods", func() {
+			p, _ := lights.NewPattern(":fx.strobe:3|color=#F00")
+			Ω(p.Cycle()).Should(Equal(100 * time.Millisecond))
+			Ω(p.Duration()).Should(Equal(300 * time.Millisecond))
+			c, finished := p.ColorAt(240 * time.Millisecond)
+			Ω(c).Should(Equal(red))
+			Ω(finished).Should(BeFalse())
+			_, finished = p.ColorAt(300 * time.Millisecond)
+			Ω(finished).Should(BeTrue())
+		})
+
+		It("should convert effect commands", func() {
+			cmd, err := lights.NewCommand("!:fx.rainbow:2|period=5s,seed=3,spread=2")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(cmd.ID).Should(Equal("fx.rainbow"))
+			data, err := json.Marshal(cmd)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(data)).Should(ContainSubstring(`"effect":{"name":"rainbow","period":"5s","seed":3,"mix":1,"params":{"spread":2}}`))
+			decoded, err := lights.ParseJSONCommand(data)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(decoded.String()).Should(Equal("!:fx.rainbow:2|period=5s,seed=3,spread=2"))
+
+			decoded, err = lights.ParseJSONCommand([]byte(`{"action":"execute","type":"pattern","pattern":{"id":"fx.noise","slots":[]}}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(decoded.String()).Should(Equal("!:fx.noise|period=2s,seed=0,scale=0.1"))
+			for _, bad := range []string{
+				`{"id":"ab","slots":[],"effect":{"name":"noise"}}`,
+				`{"id":"fx.noise","slots":[],"effect":{"name":"fire"}}`,
+				`{"id":"fx.noise","slots":[],"effect":{"name":"noise","params":{"scale":-1}}}`,
+			} {
+				Ω(json.Unmarshal([]byte(bad), &lights.Pattern{})).ShouldNot(Succeed(), bad)
+			}
+
+			bin, err := cmd.MarshalBinary()
+			Ω(err).ShouldNot(HaveOccurred())
+			decoded, err = lights.ParseBinaryCommand(bin)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(decoded.String()).Should(Equal(cmd.String()))
+		})
+
+		It("should play effects from commands", func() {
+			store := &lights.MockStore{}
+			patterns := lights.NewPatternRepo(store)
+			d := lights.NewDispatcher()
+			player := lights.NewPlayer(2)
+			now := time.Date(2015, 7, 4, 20, 0, 0, 0, time.UTC)
+			player.Now = func() time.Time { return now }
+			player.Install(d, patterns)
+
+			Ω(d.Dispatch("!:fx.strobe")).Should(Equal("fx.strobe"))
+			Ω(player.Frame()).Should(Equal([]color.RGBA{white, white}))
+			Ω(d.Dispatch("!:fx.strobe|color=#00F")).Should(Equal("fx.strobe"))
+			Ω(player.Frame()).Should(Equal([]color.RGBA{blue, blue}))
+
+			Ω(patterns.Apply(mustCommand("+:fx.strobe.red|color=#F00"))).Should(Succeed())
+			Ω(d.Dispatch("!:fx.strobe.red")).Should(Equal("fx.strobe.red"))
+			Ω(player.Frame()).Should(Equal([]color.RGBA{red, red}))
+			_, err := d.Dispatch("!:nope")
+			Ω(lights.IsNotFound(err)).Should(BeTrue())
+		})
+	})
+})
+
+// mustCommand parses a command that is known to be valid.
+func mustCommand(cmd string) *lights.Command {
+	c, err := lights.NewCommand(cmd)
+	Ω(err).ShouldNot(HaveOccurred())
+	return c
+}
@@ -0,0 +1,308 @@
+package lights
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// encryptedPrefix starts every value sealed by an EncryptedStore. Sealed
+// values have the form `enc1:<key id>:<base64 nonce and ciphertext>`.
+const encryptedPrefix = "enc1:"
+
+// DeriveKey derives a 256-bit encryption key from a device secret using
+// HKDF-SHA256 with the given context so different uses of a secret get
+// different keys.
+func DeriveKey(secret []byte, context string) []byte {
+	extract := hmac.New(sha256.New, []byte("inception-lights"))
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(context))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+// LoadKeyFile reads a device secret from a file and derives a store
+// encryption key from it.
+func LoadKeyFile(path string) ([]byte, error) {
+	path, err := PrepPath(path)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) < 16 {
+		return nil, errors.New("Key file secret is too short: " + path)
+	}
+	return DeriveKey(secret, "store"), nil
+}
+
+// KeyID returns the short ID recorded with values sealed by a key.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// EncryptedStore wraps a Store sealing every value with AES-256-GCM. The
+// collection and ID are authenticated with each value so sealed values can
+// not be swapped between items. Values sealed with older keys can still be
+// read as long as the key is added, and Rotate re-seals them with the
+// current key.
+type EncryptedStore struct {
+	Store          Store
+	AllowPlaintext bool // Read values written before encryption was enabled
+
+	lock    sync.RWMutex
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewEncryptedStore wraps a store using key for new values. Older keys
+// are used to read values sealed before a key rotation.
+func NewEncryptedStore(store Store, key []byte, oldKeys ...[]byte) (*EncryptedStore, error) {
+	e := &EncryptedStore{Store: store, keys: map[string]cipher.AEAD{}}
+	for _, k := range oldKeys {
+		if err := e.AddKey(k); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.AddKey(key); err != nil {
+		return nil, err
+	}
+	e.current = KeyID(key)
+	return e, nil
+}
+
+// AddKey adds a key that can be used to read values. Keys can be added
+// while the store is in use.
+func (e *EncryptedStore) AddKey(key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("Encryption key must be 32 bytes - found %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.keys[KeyID(key)] = aead
+	return nil
+}
+
+// Read and decrypt a value from the provided collection with a given ID.
+func (e *EncryptedStore) Read(collection, id string) (string, error) {
+	value, err := e.Store.Read(collection, id)
+	if err != nil {
+		return "", err
+	}
+	return e.open(collection, id, value)
+}
+
+// Write an encrypted value to the provided collection with a given ID.
+func (e *EncryptedStore) Write(collection, id, value string) error {
+	if err := validateItem(collection, id, value); err != nil {
+		return err
+	}
+	sealed, err := e.seal(collection, id, value)
+	if err != nil {
+		return err
+	}
+	return e.Store.Write(collection, id, sealed)
+}
+
+// Remove a value from the provided collection with a given ID.
+func (e *EncryptedStore) Remove(collection, id string) error {
+	return e.Store.Remove(collection, id)
+}
+
+// RemoveAll removes all values from the provided collection.
+func (e *EncryptedStore) RemoveAll(collection string) error {
+	return e.Store.RemoveAll(collection)
+}
+
+// List the IDs of all items in a collection in sorted order.
+func (e *EncryptedStore) List(collection string) ([]string, error) {
+	return e.Store.List(collection)
+}
+
+// Each calls fn with every decrypted item in a collection in ID order.
+func (e *EncryptedStore) Each(collection string, fn func(id, value string) error) error {
+	return e.Store.Each(collection, func(id, value string) error {
+		plain, err := e.open(collection, id, value)
+		if err != nil {
+			return err
+		}
+		return fn(id, plain)
+	})
+}
+
+// Load all the decrypted values from a collection in ID order.
+func (e *EncryptedStore) Load(collection string) ([]string, error) {
+	return loadValues(e, collection)
+}
+
+// LoadMap loads all the decrypted items from a collection keyed by ID.
+func (e *EncryptedStore) LoadMap(collection string) (map[string]string, error) {
+	return loadMap(e, collection)
+}
+
+// Update runs fn with a new batch and commits its changes, with every
+// value sealed, in one batch of the wrapped store.
+func (e *EncryptedStore) Update(fn func(b *Batch) error) error {
+	updater, ok := e.Store.(Updater)
+	if !ok {
+		return ErrBatchUnsupported
+	}
+	return updater.Update(func(b *Batch) error {
+		staged := &Batch{}
+		if err := fn(staged); err != nil {
+			return err
+		}
+		for _, op := range staged.ops {
+			if op.op == opWrite {
+				sealed, err := e.seal(op.collection, op.id, op.value)
+				if err != nil {
+					return err
+				}
+				op.value = sealed
+			}
+			b.ops = append(b.ops, op)
+		}
+		return nil
+	})
+}
+
+// LockWrites takes the wrapped store's write lock if it has one.
+func (e *EncryptedStore) LockWrites() (func(), error) {
+	if locker, ok := e.Store.(WriteLocker); ok {
+		return locker.LockWrites()
+	}
+	return func() {}, nil
+}
+
+// Watch reports changes to a collection.
+func (e *EncryptedStore) Watch(collection string) (*Watcher, error) {
+	return e.Store.Watch(collection)
+}
+
+// Collections lists the collections in the wrapped store.
+func (e *EncryptedStore) Collections() ([]string, error) {
+	lister, ok := e.Store.(CollectionLister)
+	if !ok {
+		return nil, errors.New("Wrapped store can not list collections")
+	}
+	return lister.Collections()
+}
+
+// Rotate re-seals every value that is not sealed with the current key
+// (including plaintext values if AllowPlaintext is set). If no collections
+// are named the wrapped store must implement CollectionLister. Rotation
+// holds the wrapped store's write lock (see WriteLocker) and reads each
+// value just before re-sealing it, so values written by writers that take
+// the lock are never overwritten with stale ones.
+func (e *EncryptedStore) Rotate(collections ...string) error {
+	if len(collections) == 0 {
+		var err error
+		if collections, err = e.Collections(); err != nil {
+			return err
+		}
+	}
+	unlock, err := e.LockWrites()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	e.lock.RLock()
+	current := e.current
+	e.lock.RUnlock()
+	for _, collection := range collections {
+		ids, err := e.Store.List(collection)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			value, err := e.Store.Read(collection, id)
+			if IsNotFound(err) || strings.HasPrefix(value, encryptedPrefix+current+":") {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			plain, err := e.open(collection, id, value)
+			if err != nil {
+				return err
+			}
+			if err = e.Write(collection, id, plain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// seal encrypts a value with the current key.
+func (e *EncryptedStore) seal(collection, id, value string) (string, error) {
+	e.lock.RLock()
+	current, aead := e.current, e.keys[e.current]
+	e.lock.RUnlock()
+	if aead == nil {
+		return "", errors.New("Encrypted store has no current key")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), additionalData(collection, id))
+	return encryptedPrefix + current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a sealed value.
+func (e *EncryptedStore) open(collection, id, value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		if e.AllowPlaintext {
+			return value, nil
+		}
+		return "", fmt.Errorf("Value %s/%s is not encrypted", collection, id)
+	}
+	parts := strings.SplitN(value[len(encryptedPrefix):], ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("Value %s/%s is malformed", collection, id)
+	}
+	e.lock.RLock()
+	aead, ok := e.keys[parts[0]]
+	e.lock.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("No key %s to decrypt %s/%s", parts[0], collection, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("Value %s/%s is malformed", collection, id)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData(collection, id))
+	if err != nil {
+		return "", fmt.Errorf("Value %s/%s could not be decrypted: %s", collection, id, err)
+	}
+	return string(plain), nil
+}
+
+// additionalData binds a sealed value to its collection and ID.
+func additionalData(collection, id string) []byte {
+	return []byte(EscapeName(collection) + "/" + EscapeName(id))
+}
@@ -0,0 +1,152 @@
+package lights_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+
+	Describe("Encrypted store", func() {
+		oldKey := lights.DeriveKey([]byte("old device secret"), "store")
+		newKey := lights.DeriveKey([]byte("new device secret"), "store")
+
+		It("should transparently encrypt values", func() {
+			raw := &lights.MockStore{}
+			s, err := lights.NewEncryptedStore(raw, newKey)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("credentials", "wifi", "hunter2")).Should(Succeed())
+			Ω(raw.Data["credentials"]["wifi"]).ShouldNot(ContainSubstring("hunter2"))
+			found, err := s.Read("credentials", "wifi")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(found).Should(Equal("hunter2"))
+			loaded, err := s.Load("credentials")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(loaded).Should(Equal([]string{"hunter2"}))
+		})
+
+		It("should reject tampered or moved values", func() {
+			raw := &lights.MockStore{}
+			s, err := lights.NewEncryptedStore(raw, newKey)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("credentials", "wifi", "hunter2")).Should(Succeed())
+			raw.Write("credentials", "mqtt", raw.Data["credentials"]["wifi"])
+			_, err = s.Read("credentials", "mqtt")
+			Ω(err).Should(HaveOccurred())
+			sealed := raw.Data["credentials"]["wifi"]
+			raw.Write("credentials", "wifi", sealed[:len(sealed)-2]+"AA")
+			_, err = s.Read("credentials", "wifi")
+			Ω(err).Should(HaveOccurred())
+			raw.Write("credentials", "plain", "hunter2")
+			_, err = s.Read("credentials", "plain")
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should rotate keys", func() {
+			raw := &lights.MockStore{}
+			old, err := lights.NewEncryptedStore(raw, oldKey)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(old.Write("credentials", "wifi", "hunter2")).Should(Succeed())
+			raw.Write("credentials", "legacy", "swordfish")
+
+			s, err := lights.NewEncryptedStore(raw, newKey, oldKey)
+			Ω(err).ShouldNot(HaveOccurred())
+			s.AllowPlaintext = true
+			Ω(s.Rotate()).Should(Succeed())
+			for _, value := range raw.Data["credentials"] {
+				Ω(strings.Contains(value, lights.KeyID(newKey))).Should(BeTrue())
+			}
+			current, err := lights.NewEncryptedStore(raw, newKey)
+			Ω(err).ShouldNot(HaveOccurred())
+			items, err := current.LoadMap("credentials")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(items).Should(Equal(map[string]string{"wifi": "hunter2", "legacy": "swordfish"}))
+		})
+
+		It("should not overwrite values written while rotating", func() {
+			dir, err := ioutil.TempDir("", "lights-encrypted")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			raw, err := lights.NewLogStore(filepath.Join(dir, "data.log"))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer raw.Close()
+			old, err := lights.NewEncryptedStore(raw, oldKey)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(old.Write("credentials", "wifi", "hunter2")).Should(Succeed())
+
+			s, err := lights.NewEncryptedStore(raw, newKey, oldKey)
+			Ω(err).ShouldNot(HaveOccurred())
+			unlock, err := s.LockWrites()
+			Ω(err).ShouldNot(HaveOccurred())
+			done := make(chan error)
+			go func() { done <- s.Rotate("credentials") }()
+			Consistently(done, "50ms").ShouldNot(Receive())
+			Ω(s.Write("credentials", "wifi", "correct horse")).Should(Succeed())
+			unlock()
+			Eventually(done).Should(Receive(BeNil()))
+			Ω(s.Read("credentials", "wifi")).Should(Equal("correct horse"))
+		})
+
+		It("should migrate plaintext stores configured from the environment", func() {
+			dir, err := ioutil.TempDir("", "lights-encrypted")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			keyFile := filepath.Join(dir, "secret")
+			Ω(ioutil.WriteFile(keyFile, []byte("a long device secret\n"), 0600)).Should(Succeed())
+			raw, err := lights.NewFileStore(filepath.Join(dir, "data"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(raw.Write("credentials", "wifi", "hunter2")).Should(Succeed())
+			for name, value := range map[string]string{
+				"INC_STORE":           "file:" + raw.Base,
+				"INC_STORE_KEY":       keyFile,
+				"INC_STORE_PLAINTEXT": "true",
+			} {
+				os.Setenv(name, value)
+				defer os.Unsetenv(name)
+			}
+
+			store, err := lights.NewStore()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(store.Read("credentials", "wifi")).Should(Equal("hunter2"))
+			Ω(store.(*lights.EncryptedStore).Rotate()).Should(Succeed())
+			Ω(raw.Read("credentials", "wifi")).Should(HavePrefix("enc1:"))
+
+			os.Setenv("INC_STORE_PLAINTEXT", "false")
+			store, err = lights.NewStore()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(store.Read("credentials", "wifi")).Should(Equal("hunter2"))
+			Ω(raw.Write("credentials", "legacy", "swordfish")).Should(Succeed())
+			_, err = store.Read("credentials", "legacy")
+			Ω(err).Should(MatchError(ContainSubstring("not encrypted")))
+
+			os.Setenv("INC_STORE_PLAINTEXT", "maybe")
+			_, err = lights.NewStore()
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should add keys while in use", func() {
+			s, err := lights.NewEncryptedStore(lights.NewMemoryStore(), newKey)
+			Ω(err).ShouldNot(HaveOccurred())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 50; i++ {
+					s.AddKey(lights.DeriveKey([]byte(fmt.Sprintf("secret %d", i)), "store"))
+				}
+			}()
+			for i := 0; i < 50; i++ {
+				Ω(s.Write("credentials", "wifi", "hunter2")).Should(Succeed())
+				Ω(s.Read("credentials", "wifi")).Should(Equal("hunter2"))
+			}
+			<-done
+		})
+	})
+})
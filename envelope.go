@@ -0,0 +1,303 @@
+package lights
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvelopePrefix marks a message as a signed command envelope. Envelopes
+// have the form `%sender|timestamp|nonce|signature|command` where the
+// timestamp is in Unix seconds, the nonce is hex encoded and the signature
+// is unpadded URL-safe base64.
+const EnvelopePrefix = "%"
+
+// Signer produces signatures for command envelopes.
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+}
+
+// Verifier checks a signature produced by the named sender.
+type Verifier interface {
+	Verify(sender string, data, signature []byte) error
+}
+
+// Authenticator is implemented by verifiers that accept some signatures
+// without proving who the sender is (for example with a shared key).
+// Verifiers that do not implement it prove every sender they verify.
+type Authenticator interface {
+	Authenticates(sender string) bool
+}
+
+// Envelope wraps a command with the information needed to prove who sent it
+// and that it has not been tampered with or replayed. Principal is set by
+// EnvelopeGuard.Open to the sender if the signature proves who sent it and
+// is empty otherwise.
+type Envelope struct {
+	Sender    string
+	Timestamp time.Time
+	Nonce     string
+	Signature []byte
+	Command   string
+	Principal string
+}
+
+// NewEnvelope wraps a command in an envelope signed by the provided signer.
+func NewEnvelope(sender, command string, signer Signer) (*Envelope, error) {
+	if len(sender) == 0 || strings.ContainsAny(sender, "|%") {
+		return nil, fmt.Errorf("Invalid envelope sender: %s", sender)
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	e := &Envelope{
+		Sender:    sender,
+		Timestamp: time.Unix(time.Now().Unix(), 0),
+		Nonce:     hex.EncodeToString(nonce),
+		Command:   command,
+	}
+	if err := e.Sign(signer); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Seal is a convenience for signing a command and returning the envelope
+// ready for transmission.
+func Seal(sender, command string, signer Signer) (string, error) {
+	e, err := NewEnvelope(sender, command, signer)
+	if err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+// ParseEnvelope parses an envelope message. The signature is not checked;
+// use an EnvelopeGuard or Verify for that.
+func ParseEnvelope(msg string) (*Envelope, error) {
+	if !strings.HasPrefix(msg, EnvelopePrefix) {
+		return nil, errors.New("Message is not a signed envelope")
+	}
+	parts := strings.SplitN(msg[len(EnvelopePrefix):], "|", 5)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("Truncated envelope received: %s", msg)
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("Envelope timestamp was not an integer")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, errors.New("Envelope signature was not valid base64")
+	}
+	return &Envelope{
+		Sender:    parts[0],
+		Timestamp: time.Unix(ts, 0),
+		Nonce:     parts[2],
+		Signature: sig,
+		Command:   parts[4],
+	}, nil
+}
+
+// Sign (or re-sign) the envelope using the provided signer.
+func (e *Envelope) Sign(signer Signer) error {
+	sig, err := signer.Sign(e.signed())
+	if err != nil {
+		return err
+	}
+	e.Signature = sig
+	return nil
+}
+
+// Verify checks the envelope signature using the provided verifier.
+func (e *Envelope) Verify(verifier Verifier) error {
+	if len(e.Signature) == 0 {
+		return errors.New("Envelope is not signed")
+	}
+	return verifier.Verify(e.Sender, e.signed(), e.Signature)
+}
+
+// String encodes the envelope in its wire format.
+func (e *Envelope) String() string {
+	return EnvelopePrefix + strings.Join([]string{
+		e.Sender,
+		strconv.FormatInt(e.Timestamp.Unix(), 10),
+		e.Nonce,
+		base64.RawURLEncoding.EncodeToString(e.Signature),
+		e.Command,
+	}, "|")
+}
+
+// signed returns the bytes covered by the signature.
+func (e *Envelope) signed() []byte {
+	return []byte(strings.Join([]string{
+		e.Sender,
+		strconv.FormatInt(e.Timestamp.Unix(), 10),
+		e.Nonce,
+		e.Command,
+	}, "|"))
+}
+
+// HMACSigner signs envelopes with a shared key using HMAC-SHA256.
+type HMACSigner struct {
+	Key []byte
+}
+
+// Sign the data with the shared key.
+func (h *HMACSigner) Sign(data []byte) ([]byte, error) {
+	if len(h.Key) == 0 {
+		return nil, errors.New("Missing HMAC key")
+	}
+	mac := hmac.New(sha256.New, h.Key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// HMACVerifier verifies envelopes signed with shared keys. Keys are looked
+// up by sender so each sender (for example an API token) can have its own
+// key. If no key is registered for a sender the Default key is used. Anyone
+// holding the Default key can sign as any sender, so it only proves the
+// message is from a key holder: Default key envelopes have no principal
+// and are treated as coming from an unauthenticated identity.
+type HMACVerifier struct {
+	Keys    map[string][]byte
+	Default []byte
+}
+
+// Verify the signature for the sender.
+func (h *HMACVerifier) Verify(sender string, data, signature []byte) error {
+	key, ok := h.Keys[sender]
+	if !ok {
+		key = h.Default
+	}
+	if len(key) == 0 {
+		return errors.New("No key found for sender " + sender)
+	}
+	expected, err := (&HMACSigner{key}).Sign(data)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, signature) {
+		return errors.New("Invalid signature from sender " + sender)
+	}
+	return nil
+}
+
+// Authenticates returns true if the sender has its own key.
+func (h *HMACVerifier) Authenticates(sender string) bool {
+	return len(h.Keys[sender]) > 0
+}
+
+// Ed25519Signer signs envelopes with an Ed25519 private key.
+type Ed25519Signer struct {
+	Key ed25519.PrivateKey
+}
+
+// Sign the data with the private key.
+func (s *Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	if len(s.Key) != ed25519.PrivateKeySize {
+		return nil, errors.New("Invalid Ed25519 private key")
+	}
+	return ed25519.Sign(s.Key, data), nil
+}
+
+// Ed25519Verifier verifies envelopes using the public key registered for
+// each sender. The security package can build one from certificates.
+type Ed25519Verifier struct {
+	Keys map[string]ed25519.PublicKey
+}
+
+// Verify the signature for the sender.
+func (v *Ed25519Verifier) Verify(sender string, data, signature []byte) error {
+	key, ok := v.Keys[sender]
+	if !ok {
+		return errors.New("No key found for sender " + sender)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return errors.New("Invalid Ed25519 public key for sender " + sender)
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return errors.New("Invalid signature from sender " + sender)
+	}
+	return nil
+}
+
+// EnvelopeGuard rejects messages that are unsigned, tampered with, stale or
+// replayed. Use Wrap to protect a WorkerFunc.
+type EnvelopeGuard struct {
+	Verifier Verifier
+	MaxAge   time.Duration // Messages older (or newer) than this are rejected
+
+	lock   sync.Mutex
+	nonces map[string]time.Time // Nonces seen and when they can be forgotten
+}
+
+// NewEnvelopeGuard creates a guard using the verifier. A maxAge of zero
+// uses a five minute window.
+func NewEnvelopeGuard(verifier Verifier, maxAge time.Duration) *EnvelopeGuard {
+	if maxAge <= 0 {
+		maxAge = 5 * time.Minute
+	}
+	return &EnvelopeGuard{Verifier: verifier, MaxAge: maxAge}
+}
+
+// Open verifies an envelope message and returns the envelope if it is
+// acceptable, with its Principal set if the verifier proves the sender.
+// Each envelope is only accepted once.
+func (g *EnvelopeGuard) Open(msg string) (*Envelope, error) {
+	e, err := ParseEnvelope(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.Verify(g.Verifier); err != nil {
+		return nil, err
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.nonces == nil {
+		g.nonces = map[string]time.Time{}
+	}
+	now := time.Now()
+	age := now.Sub(e.Timestamp)
+	if age > g.MaxAge || age < -g.MaxAge {
+		return nil, fmt.Errorf("Stale envelope from %s sent at %s", e.Sender, e.Timestamp)
+	}
+	for nonce, expires := range g.nonces {
+		if now.After(expires) {
+			delete(g.nonces, nonce)
+		}
+	}
+	key := e.Sender + "|" + e.Nonce
+	if _, seen := g.nonces[key]; seen {
+		return nil, fmt.Errorf("Replayed envelope from %s", e.Sender)
+	}
+	// Anything older than the window is rejected as stale so nonces only
+	// need to be remembered until then.
+	g.nonces[key] = e.Timestamp.Add(g.MaxAge)
+	if a, ok := g.Verifier.(Authenticator); !ok || a.Authenticates(e.Sender) {
+		e.Principal = e.Sender
+	}
+	return e, nil
+}
+
+// Wrap returns a WorkerFunc that only passes verified commands on to the
+// handler. The handler receives the command without its envelope.
+func (g *EnvelopeGuard) Wrap(handler WorkerFunc) WorkerFunc {
+	return func(message string) error {
+		e, err := g.Open(message)
+		if err != nil {
+			return err
+		}
+		return handler(e.Command)
+	}
+}
@@ -0,0 +1,109 @@
+package lights_test
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+
+	Describe("Envelope", func() {
+		signer := &lights.HMACSigner{Key: []byte("secret")}
+		verifier := &lights.HMACVerifier{Keys: map[string][]byte{"gateway": []byte("secret")}}
+
+		It("should round trip signed commands", func() {
+			msg, err := lights.Seal("gateway", "!:ab:|#F00,2s,1s", signer)
+			Ω(err).ShouldNot(HaveOccurred())
+			e, err := lights.ParseEnvelope(msg)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(e.Sender).Should(Equal("gateway"))
+			Ω(e.Command).Should(Equal("!:ab:|#F00,2s,1s"))
+			Ω(e.Verify(verifier)).Should(Succeed())
+		})
+
+		It("should pass verified commands to the handler", func() {
+			guard := lights.NewEnvelopeGuard(verifier, time.Minute)
+			received := ""
+			handler := guard.Wrap(func(message string) error {
+				received = message
+				return nil
+			})
+			msg, err := lights.Seal("gateway", "!#F00", signer)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(handler(msg)).Should(Succeed())
+			Ω(received).Should(Equal("!#F00"))
+		})
+
+		It("should reject unsigned, tampered, stale and replayed messages", func() {
+			guard := lights.NewEnvelopeGuard(verifier, time.Minute)
+			handler := guard.Wrap(func(message string) error {
+				return errors.New("handler should not run")
+			})
+			Ω(handler("!#F00")).Should(MatchError("Message is not a signed envelope"))
+
+			e, err := lights.NewEnvelope("gateway", "!#F00", signer)
+			Ω(err).ShouldNot(HaveOccurred())
+			e.Command = "!#0F0"
+			Ω(handler(e.String())).Should(MatchError(ContainSubstring("Invalid signature")))
+
+			e.Timestamp = time.Now().Add(-time.Hour)
+			Ω(e.Sign(signer)).Should(Succeed())
+			Ω(handler(e.String())).Should(MatchError(ContainSubstring("Stale envelope")))
+
+			msg, err := lights.Seal("gateway", "!#F00", signer)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = guard.Open(msg)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = guard.Open(msg)
+			Ω(err).Should(MatchError(ContainSubstring("Replayed envelope")))
+
+			msg, err = lights.Seal("intruder", "!#F00", &lights.HMACSigner{Key: []byte("guess")})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(handler(msg)).Should(MatchError(ContainSubstring("No key found")))
+		})
+
+		It("should support Ed25519 signatures", func() {
+			public, private, err := ed25519.GenerateKey(nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			msg, err := lights.Seal("controller", "?-version", &lights.Ed25519Signer{Key: private})
+			Ω(err).ShouldNot(HaveOccurred())
+			guard := lights.NewEnvelopeGuard(&lights.Ed25519Verifier{
+				Keys: map[string]ed25519.PublicKey{"controller": public},
+			}, 0)
+			e, err := guard.Open(msg)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(e.Command).Should(Equal("?-version"))
+			Ω(e.Principal).Should(Equal("controller"))
+		})
+
+		It("should only prove senders with their own keys", func() {
+			guard := lights.NewEnvelopeGuard(&lights.HMACVerifier{
+				Keys:    map[string][]byte{"gateway": []byte("secret")},
+				Default: []byte("shared"),
+			}, 0)
+			msg, err := lights.Seal("gateway", "!#F00", signer)
+			Ω(err).ShouldNot(HaveOccurred())
+			e, err := guard.Open(msg)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(e.Principal).Should(Equal("gateway"))
+
+			msg, err = lights.Seal("admin", "!#F00", &lights.HMACSigner{Key: []byte("shared")})
+			Ω(err).ShouldNot(HaveOccurred())
+			e, err = guard.Open(msg)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(e.Sender).Should(Equal("admin"))
+			Ω(e.Principal).Should(BeEmpty())
+
+			msg, err = lights.Seal("gateway", "!#F00", &lights.HMACSigner{Key: []byte("shared")})
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = guard.Open(msg)
+			Ω(err).Should(MatchError(ContainSubstring("Invalid signature")))
+		})
+	})
+})
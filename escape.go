@@ -0,0 +1,93 @@
+package lights
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxNameLength is the longest collection or ID name (once escaped) that
+// Store implementations accept.
+const MaxNameLength = 200
+
+// NameError is returned by Store implementations when a collection or ID
+// name can not be stored safely.
+type NameError struct {
+	Kind   string // "collection" or "id"
+	Name   string
+	Reason string
+}
+
+// Error describes the invalid name.
+func (e *NameError) Error() string {
+	return fmt.Sprintf("Invalid %s name %q: %s", e.Kind, e.Name, e.Reason)
+}
+
+// ValidateName checks that a collection or ID name can be stored. Any
+// non-empty name is acceptable as long as its escaped form fits within
+// MaxNameLength, so IDs taken from untrusted commands are safe to store.
+func ValidateName(kind, name string) error {
+	if len(name) == 0 {
+		return &NameError{kind, name, "name is empty"}
+	}
+	if len(EscapeName(name)) > MaxNameLength {
+		return &NameError{kind, name, "name is too long"}
+	}
+	return nil
+}
+
+// validateNames checks a collection and (optional) list of IDs.
+func validateNames(collection string, ids ...string) error {
+	if err := ValidateName("collection", collection); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := ValidateName("id", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EscapeName reversibly converts a name into a file name friendly form.
+// Letters, digits, `-` and `_` are kept and every other byte is replaced
+// by `%` followed by two hex digits, so the result never contains path
+// separators or dots.
+func EscapeName(name string) string {
+	escaped := strings.Builder{}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '-' || c == '_' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+// UnescapeName reverses EscapeName returning an error if the escaped name
+// is malformed or not in the form EscapeName gives (such as `%61` for `a`
+// or a lowercase `%2e`), so every name has exactly one escaped form.
+func UnescapeName(escaped string) (string, error) {
+	name := []byte{}
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] != '%' {
+			name = append(name, escaped[i])
+			continue
+		}
+		if i+2 >= len(escaped) {
+			return "", &NameError{"escaped", escaped, "truncated escape"}
+		}
+		c, err := strconv.ParseUint(escaped[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", &NameError{"escaped", escaped, "invalid escape"}
+		}
+		name = append(name, byte(c))
+		i += 2
+	}
+	if EscapeName(string(name)) != escaped {
+		return "", &NameError{"escaped", escaped, "non-canonical escape"}
+	}
+	return string(name), nil
+}
@@ -0,0 +1,92 @@
+package lights_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+
+	Describe("Name escaping", func() {
+		It("should reversibly escape unsafe names", func() {
+			for _, name := range []string{"ab", "../../etc/x", ".", "a.txt.tmp", "100%", "日本"} {
+				escaped := lights.EscapeName(name)
+				Ω(escaped).ShouldNot(ContainSubstring("/"))
+				Ω(escaped).ShouldNot(ContainSubstring("."))
+				unescaped, err := lights.UnescapeName(escaped)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(unescaped).Should(Equal(name))
+			}
+			Ω(lights.EscapeName("a-b_C1")).Should(Equal("a-b_C1"))
+			for _, escaped := range []string{"%2", "%61", "a%2e", "%2F%2"} {
+				_, err := lights.UnescapeName(escaped)
+				Ω(err).Should(HaveOccurred(), escaped)
+			}
+			Ω(lights.UnescapeName("a%2E")).Should(Equal("a."))
+		})
+
+		It("should migrate file store items named before escaping", func() {
+			dir, err := ioutil.TempDir("", "lights-store")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			legacy := map[string]string{
+				"my.patterns/my.pattern.txt": "#F00",
+				"patterns/a b.txt":           "#0F0",
+				"patterns/%61.txt":           "#00F",
+				"patterns/ok.txt":            "#FFF",
+				"patterns/x.y.txt":           "old",
+				"patterns/x%2Ey.txt":         "new",
+			}
+			for name, value := range legacy {
+				path := filepath.Join(dir, name)
+				Ω(os.MkdirAll(filepath.Dir(path), 0700)).Should(Succeed())
+				Ω(ioutil.WriteFile(path, []byte(value), 0600)).Should(Succeed())
+			}
+			s, err := lights.NewFileStore(dir)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.LoadMap("my.patterns")).Should(Equal(map[string]string{"my.pattern": "#F00"}))
+			Ω(s.LoadMap("patterns")).Should(Equal(map[string]string{"a b": "#0F0", "%61": "#00F", "ok": "#FFF", "x.y": "new"}))
+			Ω(s.Collections()).Should(Equal([]string{"my.patterns", "patterns"}))
+			_, err = os.Stat(filepath.Join(dir, "patterns", "x.y.txt"))
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should reject empty and overly long names", func() {
+			s := &lights.MockStore{}
+			err := s.Write("foo", "", "baz")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.NameError{}))
+			err = s.Write("", "bar", "baz")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.NameError{}))
+			err = s.Write("foo", strings.Repeat("/", lights.MaxNameLength), "baz")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.NameError{}))
+		})
+
+		It("should keep untrusted IDs inside the file store", func() {
+			dir, err := ioutil.TempDir("", "lights-store")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			base := filepath.Join(dir, "data")
+			s, err := lights.NewFileStore(base)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("patterns", "../../x", "#F00")).Should(Succeed())
+			Ω(s.Write("..", "y", "#0F0")).Should(Succeed())
+			_, err = os.Stat(filepath.Join(dir, "x.txt"))
+			Ω(os.IsNotExist(err)).Should(BeTrue())
+			_, err = os.Stat(filepath.Join(dir, "y.txt"))
+			Ω(os.IsNotExist(err)).Should(BeTrue())
+			ids, err := s.List("patterns")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ids).Should(Equal([]string{"../../x"}))
+			found, err := s.Read("..", "y")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(found).Should(Equal("#0F0"))
+		})
+	})
+})
@@ -0,0 +1,317 @@
+package lights
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExportFormat identifies store archives created by Export.
+const ExportFormat = "lights-export"
+
+// ExportVersion is the archive format version written by Export.
+const ExportVersion = 1
+
+// maxExportLine is the longest line accepted when importing an archive.
+const maxExportLine = 64 << 20
+
+// ExportHeader is the first line of a store archive.
+type ExportHeader struct {
+	Format      string    `json:"format"`
+	Version     int       `json:"version"`
+	Created     time.Time `json:"created"`
+	Device      string    `json:"device,omitempty"`
+	Collections []string  `json:"collections"`
+}
+
+// exportItem is a single item line in a store archive.
+type exportItem struct {
+	Collection string `json:"c"`
+	ID         string `json:"id"`
+	Value      string `json:"v"`
+}
+
+// exportTrailer is the last line of a store archive. The checksum covers
+// every line before it.
+type exportTrailer struct {
+	Count  int    `json:"count"`
+	SHA256 string `json:"sha256"`
+}
+
+// Export writes the collections of a store to w as a JSON lines archive: a
+// header, one line per item and a trailer with the item count and a
+// SHA-256 checksum. If no collections are named the store must implement
+// CollectionLister and every collection is exported.
+func Export(store Store, w io.Writer, collections ...string) error {
+	if len(collections) == 0 {
+		lister, ok := store.(CollectionLister)
+		if !ok {
+			return errors.New("Store can not list collections - name the collections to export")
+		}
+		var err error
+		if collections, err = lister.Collections(); err != nil {
+			return err
+		}
+	}
+	header := ExportHeader{
+		Format:      ExportFormat,
+		Version:     ExportVersion,
+		Created:     time.Now().UTC(),
+		Collections: collections,
+	}
+	if id, err := NewID(); err == nil {
+		header.Device = id.ID
+	}
+	sum := sha256.New()
+	out := io.MultiWriter(w, sum)
+	if err := writeJSONLine(out, header); err != nil {
+		return err
+	}
+	count := 0
+	for _, collection := range collections {
+		err := store.Each(collection, func(id, value string) error {
+			count++
+			return writeJSONLine(out, exportItem{collection, id, value})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return writeJSONLine(w, exportTrailer{count, hex.EncodeToString(sum.Sum(nil))})
+}
+
+// Import reads an archive created by Export and writes its items to the
+// store. The whole archive is read and its checksum verified before
+// anything is written, and stores implementing Updater apply the archive
+// atomically. Items already in the store that are not in the archive are
+// kept.
+func Import(r io.Reader, store Store) (*ExportHeader, error) {
+	return importArchive(r, store, false)
+}
+
+// Restore is like Import but first removes every collection named in the
+// archive, so those collections end up exactly as they were exported.
+func Restore(r io.Reader, store Store) (*ExportHeader, error) {
+	return importArchive(r, store, true)
+}
+
+// importArchive reads, verifies and applies an archive.
+func importArchive(r io.Reader, store Store, replace bool) (*ExportHeader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxExportLine)
+	sum := sha256.New()
+	header := &ExportHeader{}
+	items := []exportItem{}
+	var trailer *exportTrailer
+	for line := 0; scanner.Scan(); line++ {
+		text := scanner.Bytes()
+		if trailer != nil {
+			return nil, errors.New("Unexpected data after archive trailer")
+		}
+		var err error
+		switch {
+		case line == 0:
+			if err = json.Unmarshal(text, header); err == nil && (header.Format != ExportFormat || header.Version != ExportVersion) {
+				return nil, fmt.Errorf("Unsupported archive format %s version %d", header.Format, header.Version)
+			}
+		case bytes.HasPrefix(text, []byte(`{"count"`)):
+			trailer = &exportTrailer{}
+			err = json.Unmarshal(text, trailer)
+		default:
+			item := exportItem{}
+			if err = json.Unmarshal(text, &item); err == nil {
+				items = append(items, item)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Archive line %d is invalid: %s", line+1, err)
+		}
+		if trailer == nil {
+			sum.Write(text)
+			sum.Write([]byte{'\n'})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if trailer == nil {
+		return nil, errors.New("Archive is truncated - no trailer found")
+	}
+	if trailer.Count != len(items) || trailer.SHA256 != hex.EncodeToString(sum.Sum(nil)) {
+		return nil, errors.New("Archive checksum does not match")
+	}
+	apply := func(s interface {
+		Write(collection, id, value string) error
+		RemoveAll(collection string) error
+	}) error {
+		if replace {
+			for _, collection := range header.Collections {
+				if err := s.RemoveAll(collection); err != nil {
+					return err
+				}
+			}
+		}
+		for _, item := range items {
+			if err := s.Write(item.Collection, item.ID, item.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := ErrBatchUnsupported
+	if updater, ok := store.(Updater); ok {
+		// Apply the whole archive atomically
+		err = updater.Update(func(b *Batch) error { return apply(b) })
+	}
+	if err == ErrBatchUnsupported {
+		err = apply(store)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// writeJSONLine writes v as a single line of JSON.
+func writeJSONLine(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Snapshot exports every collection of the store to a new archive file in
+// dir and returns the snapshot name. Snapshots of an EncryptedStore hold
+// the sealed values of the wrapped store so nothing is decrypted to disk.
+func Snapshot(store Store, dir string) (string, error) {
+	if err := makeStoreDir(dir); err != nil {
+		return "", err
+	}
+	name := time.Now().UTC().Format("20060102T150405.000000000Z") + ".jsonl"
+	tmp, err := ioutil.TempFile(dir, name+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = Export(sealedStore(store), tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		return "", err
+	}
+	return name, syncDir(dir)
+}
+
+// Snapshots lists the snapshot names in dir, oldest first.
+func Snapshots(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, info := range files {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".jsonl") {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RestoreSnapshot restores the store from a snapshot in dir. An empty name
+// restores the latest snapshot. Snapshots are restored into the wrapped
+// store of an EncryptedStore as they hold sealed values.
+func RestoreSnapshot(store Store, dir, name string) error {
+	if len(name) == 0 {
+		names, err := Snapshots(dir)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return errors.New("No snapshots found in " + dir)
+		}
+		name = names[len(names)-1]
+	}
+	if filepath.Base(name) != name || !strings.HasSuffix(name, ".jsonl") {
+		return errors.New("Invalid snapshot name " + name)
+	}
+	file, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = Restore(file, sealedStore(store))
+	return err
+}
+
+// sealedStore returns the store holding the sealed values of an
+// EncryptedStore (or the store itself if it is not encrypted).
+func sealedStore(store Store) Store {
+	for {
+		e, ok := store.(*EncryptedStore)
+		if !ok {
+			return store
+		}
+		store = e.Store
+	}
+}
+
+// SnapshotMiddleware handles the `!-snapshot` and `!-restore[|name]`
+// property commands by snapshotting or restoring the store using dir.
+// All other messages are passed on to the handler. Every message is
+// authorized by the gatekeeper first (so snapshot commands need a policy
+// allowing property commands), which means the middleware replaces the
+// gatekeeper's own:
+//
+//	worker.Use(SnapshotMiddleware(store, dir, gatekeeper))
+func SnapshotMiddleware(store Store, dir string, gatekeeper *Gatekeeper) Middleware {
+	return func(handler WorkerFunc) WorkerFunc {
+		if gatekeeper == nil {
+			return func(message string) error {
+				return errors.New("Snapshot middleware has no gatekeeper to authorize commands")
+			}
+		}
+		return gatekeeper.Wrap(func(message string) error {
+			cmd, err := NewCommand(message)
+			if err != nil || cmd.Action != "execute" || cmd.Type != "property" {
+				return handler(message)
+			}
+			switch cmd.ID {
+			case "snapshot":
+				_, err = Snapshot(store, dir)
+				return err
+			case "restore":
+				name := ""
+				if len(cmd.Parts) > 1 {
+					name = cmd.Parts[1]
+				}
+				return RestoreSnapshot(store, dir, name)
+			default:
+				return handler(message)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package lights_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+
+	Describe("Export", func() {
+		source := func() *lights.MockStore {
+			s := &lights.MockStore{}
+			s.Write("patterns", "ab", ":ab|#F00,2s,1s\n")
+			s.Write("patterns", "cd", ":cd|#0F0")
+			s.Write("schedules", "4", "4|||0 30 * * * *|#000|")
+			return s
+		}
+
+		It("should round trip every collection", func() {
+			buf := &bytes.Buffer{}
+			Ω(lights.Export(source(), buf)).Should(Succeed())
+			target := &lights.MockStore{}
+			header, err := lights.Import(buf, target)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(header.Format).Should(Equal(lights.ExportFormat))
+			Ω(header.Collections).Should(Equal([]string{"patterns", "schedules"}))
+			Ω(target.Data).Should(Equal(source().Data))
+		})
+
+		It("should reject tampered and truncated archives", func() {
+			buf := &bytes.Buffer{}
+			Ω(lights.Export(source(), buf)).Should(Succeed())
+			archive := buf.String()
+			target := &lights.MockStore{}
+			_, err := lights.Import(strings.NewReader(strings.Replace(archive, "#0F0", "#00F", 1)), target)
+			Ω(err).Should(MatchError("Archive checksum does not match"))
+			lines := strings.SplitAfter(archive, "\n")
+			_, err = lights.Import(strings.NewReader(strings.Join(lines[:len(lines)-2], "")), target)
+			Ω(err).Should(HaveOccurred())
+			Ω(target.Data).Should(BeEmpty())
+		})
+
+		It("should restore atomically through store wrappers", func() {
+			dir, err := ioutil.TempDir("", "lights-restore")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			ls, err := lights.NewLogStore(filepath.Join(dir, "data.log"))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer ls.Close()
+			raw := &batchOnlyStore{LogStore: ls}
+			target, err := lights.NewEncryptedStore(raw, lights.DeriveKey([]byte("device secret"), "store"))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			buf := &bytes.Buffer{}
+			Ω(lights.Export(source(), buf)).Should(Succeed())
+			_, err = lights.Restore(buf, target)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(raw.updates).Should(Equal(1))
+			Ω(target.LoadMap("patterns")).Should(Equal(source().Data["patterns"]))
+			Ω(ls.Read("patterns", "cd")).ShouldNot(ContainSubstring("#0F0"))
+		})
+
+		It("should snapshot encrypted stores without decrypting them", func() {
+			dir, err := ioutil.TempDir("", "lights-snapshot")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			raw := &lights.MockStore{}
+			s, err := lights.NewEncryptedStore(raw, lights.DeriveKey([]byte("device secret"), "store"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("credentials", "wifi", "hunter2")).Should(Succeed())
+			name, err := lights.Snapshot(s, dir)
+			Ω(err).ShouldNot(HaveOccurred())
+			data, err := ioutil.ReadFile(filepath.Join(dir, name))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(data)).ShouldNot(ContainSubstring("hunter2"))
+			Ω(string(data)).Should(ContainSubstring("enc1:"))
+
+			Ω(s.Write("credentials", "wifi", "changed")).Should(Succeed())
+			Ω(lights.RestoreSnapshot(s, dir, name)).Should(Succeed())
+			Ω(s.Read("credentials", "wifi")).Should(Equal("hunter2"))
+		})
+
+		It("should snapshot and restore from property commands", func() {
+			dir, err := ioutil.TempDir("", "lights-snapshot")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			ls, err := lights.NewLogStore(filepath.Join(dir, "data.log"))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer ls.Close()
+			Ω(ls.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+
+			policies := &lights.MockStore{}
+			signer := &lights.HMACSigner{Key: []byte("secret")}
+			guard := lights.NewEnvelopeGuard(&lights.HMACVerifier{Keys: map[string][]byte{"admin": []byte("secret")}}, 0)
+			g := lights.NewGatekeeper(policies, &lights.DeviceID{ID: "0123456789ab"}, guard)
+			for id, spec := range map[string]string{"1": "allow|admin|*|*|*", "2": "allow|" + lights.Anonymous + "|execute|color|*"} {
+				p, err := lights.NewPolicy(spec)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(g.AddPolicy(id, p)).Should(Succeed())
+			}
+			passed := []string{}
+			handler := lights.SnapshotMiddleware(ls, filepath.Join(dir, "snapshots"), g)(func(message string) error {
+				passed = append(passed, message)
+				return nil
+			})
+			admin := func(command string) error {
+				msg, err := lights.Seal("admin", command, signer)
+				Ω(err).ShouldNot(HaveOccurred())
+				return handler(msg)
+			}
+			Ω(handler("!-snapshot")).ShouldNot(Succeed())
+			Ω(admin("!-snapshot")).Should(Succeed())
+			names, err := lights.Snapshots(filepath.Join(dir, "snapshots"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(names).Should(HaveLen(1))
+
+			Ω(ls.Write("patterns", "ab", ":ab|#BAD")).Should(Succeed())
+			Ω(ls.Write("patterns", "cd", ":cd|#BAD")).Should(Succeed())
+			Ω(handler("!-restore|" + names[0])).Should(MatchError(ContainSubstring("may not execute property")))
+			Ω(ls.Read("patterns", "cd")).Should(Equal(":cd|#BAD"))
+			Ω(admin("!-restore|" + names[0])).Should(Succeed())
+			items, err := ls.LoadMap("patterns")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(items).Should(Equal(map[string]string{"ab": ":ab|#F00"}))
+			Ω(admin("!-restore|../data.log")).ShouldNot(Succeed())
+
+			Ω(handler("!#F00")).Should(Succeed())
+			Ω(lights.SnapshotMiddleware(ls, dir, nil)(nil)("!-restore")).ShouldNot(Succeed())
+			Ω(passed).Should(Equal([]string{"!#F00"}))
+		})
+	})
+})
@@ -0,0 +1,440 @@
+package lights
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONContentType is the content type of JSON encoded commands.
+const JSONContentType = "application/json"
+
+// jsonCommand is the JSON representation of a Command. Pattern, schedule and
+// scene commands carry a typed payload when their body is valid (and pattern
+// commands have slots or effect parameters); other commands carry the raw
+// `|` separated parts so every command converts losslessly to and from the
+// compact format. For example `!:ab|#F00,2,1` is:
+//
+//	{"action":"execute","type":"pattern","id":"ab","pattern":{"id":"ab",
+//	 "loops":-1,"slots":[{"color":"#FF0000","fade":"2s","hold":"1s",
+//	 "transition":"ease"}]}}
+type jsonCommand struct {
+	Action   string    `json:"action"`
+	Type     string    `json:"type"`
+	ID       string    `json:"id,omitempty"`
+	Parts    []string  `json:"parts,omitempty"`
+	Pattern  *Pattern  `json:"pattern,omitempty"`
+	Schedule *Schedule `json:"schedule,omitempty"`
+	Scene    *Scene    `json:"scene,omitempty"`
+}
+
+// MarshalJSON encodes the command as JSON.
+func (c *Command) MarshalJSON() ([]byte, error) {
+	j := jsonCommand{Action: c.Action, Type: c.Type, ID: c.ID}
+	var err error
+	switch {
+	case c.Type == "pattern" && len(c.Parts) < 2:
+		// Keep ID only commands (such as `!:fx.candle.desk`) referring to
+		// stored patterns instead of filling in effect defaults
+		err = errors.New("No inline pattern")
+	case c.Type == "pattern":
+		j.Pattern, err = NewPattern(":" + c.Body())
+	case c.Type == "schedule":
+		j.Schedule, err = NewSchedule(c.Body())
+	case c.Type == "scene":
+		j.Scene, err = NewScene(c.Body())
+	default:
+		err = errors.New("No typed payload")
+	}
+	if err != nil {
+		j.Pattern, j.Schedule, j.Scene = nil, nil, nil
+		j.Parts = c.Parts
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a JSON command. The command is converted to the
+// compact format and parsed with NewCommand so it is identical to the
+// equivalent compact command.
+func (c *Command) UnmarshalJSON(data []byte) error {
+	j := jsonCommand{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	code, err := commandCode(j.Action, j.Type)
+	if err != nil {
+		return err
+	}
+	body := ""
+	switch {
+	case j.Pattern != nil:
+		body = strings.TrimPrefix(j.Pattern.String(), ":")
+	case j.Schedule != nil:
+		body = j.Schedule.String()
+	case j.Scene != nil:
+		body = j.Scene.String()
+	case len(j.Parts) > 0:
+		body = strings.Join(j.Parts, "|")
+	default:
+		body = strings.TrimPrefix(j.ID, "#")
+	}
+	cmd, err := NewCommand(code + body)
+	if err != nil {
+		return err
+	}
+	*c = *cmd
+	return nil
+}
+
+// ParseJSONCommand decodes a JSON command.
+func ParseJSONCommand(data []byte) (*Command, error) {
+	cmd := &Command{}
+	if err := json.Unmarshal(data, cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// jsonEnvelope is the JSON representation of an Envelope. The signature
+// covers the compact command, which is carried as a JSON command when it
+// converts losslessly and as a compact string otherwise (batches for
+// example). For example:
+//
+//	{"sender":"gateway","ts":1436040000,"nonce":"9f86d081","sig":"...",
+//	 "command":{"action":"execute","type":"color","parts":["F00"]}}
+type jsonEnvelope struct {
+	Sender    string          `json:"sender"`
+	Timestamp int64           `json:"ts"`
+	Nonce     string          `json:"nonce"`
+	Signature string          `json:"sig"`
+	Command   json.RawMessage `json:"command"`
+}
+
+// MarshalJSON encodes the envelope as JSON.
+func (e *Envelope) MarshalJSON() ([]byte, error) {
+	command, err := json.Marshal(e.Command)
+	if err != nil {
+		return nil, err
+	}
+	if cmd, err := NewCommand(e.Command); err == nil && cmd.String() == e.Command {
+		if command, err = json.Marshal(cmd); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(jsonEnvelope{e.Sender, e.Timestamp.Unix(), e.Nonce,
+		base64.RawURLEncoding.EncodeToString(e.Signature), command})
+}
+
+// UnmarshalJSON decodes a JSON envelope. JSON commands are converted to
+// the compact format the signature covers. The signature is not checked;
+// use an EnvelopeGuard or Verify for that.
+func (e *Envelope) UnmarshalJSON(data []byte) error {
+	j := jsonEnvelope{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(j.Signature)
+	if err != nil {
+		return errors.New("Envelope signature was not valid base64")
+	}
+	command := ""
+	if err := json.Unmarshal(j.Command, &command); err != nil {
+		cmd, err := ParseJSONCommand(j.Command)
+		if err != nil {
+			return err
+		}
+		command = cmd.String()
+	}
+	*e = Envelope{Sender: j.Sender, Timestamp: time.Unix(j.Timestamp, 0), Nonce: j.Nonce, Signature: sig, Command: command}
+	return nil
+}
+
+// ParseJSONMessage decodes a JSON command or envelope (an object with a
+// `sig` field) into the compact message a worker handles. Envelopes are
+// not verified; they are returned in the envelope format for the worker's
+// middleware to open.
+func ParseJSONMessage(data []byte) (string, error) {
+	probe := struct {
+		Signature *string `json:"sig"`
+	}{}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return "", err
+	}
+	if probe.Signature != nil {
+		e := &Envelope{}
+		if err := json.Unmarshal(data, e); err != nil {
+			return "", err
+		}
+		return e.String(), nil
+	}
+	cmd, err := ParseJSONCommand(data)
+	if err != nil {
+		return "", err
+	}
+	return cmd.String(), nil
+}
+
+// jsonPattern is the JSON representation of a Pattern.
+type jsonPattern struct {
+	ID     string  `json:"id"`
+	Loops  *int    `json:"loops,omitempty"` // Loops forever if omitted
+	Slots  []*Slot `json:"slots"`
+	Effect *Effect `json:"effect,omitempty"` // Defaults for effect IDs if omitted
+}
+
+// MarshalJSON encodes the pattern as JSON.
+func (p *Pattern) MarshalJSON() ([]byte, error) {
+	loops := p.Loops
+	slots := p.Slots
+	if slots == nil {
+		slots = []*Slot{}
+	}
+	return json.Marshal(jsonPattern{p.ID, &loops, slots, p.Effect})
+}
+
+// UnmarshalJSON decodes a JSON pattern.
+func (p *Pattern) UnmarshalJSON(data []byte) error {
+	j := jsonPattern{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if err := checkSeparators(j.ID); err != nil || strings.Contains(j.ID, ":") {
+		return errors.New("Invalid pattern ID " + j.ID)
+	}
+	p.ID = j.ID
+	p.Loops = -1
+	if j.Loops != nil {
+		p.Loops = *j.Loops
+	}
+	p.Slots = j.Slots
+	if p.Slots == nil {
+		p.Slots = []*Slot{}
+	}
+	p.Effect = j.Effect
+	switch {
+	case !IsEffectID(p.ID) && p.Effect != nil:
+		return errors.New("Only effect patterns may have an effect: " + p.ID)
+	case !IsEffectID(p.ID):
+	case p.Effect == nil:
+		var err error
+		p.Effect, err = ParseEffect(EffectName(p.ID), "")
+		return err
+	case p.Effect.Name != EffectName(p.ID):
+		return fmt.Errorf("Pattern %s can not have a %s effect", p.ID, p.Effect.Name)
+	}
+	return nil
+}
+
+// jsonEffect is the JSON representation of an Effect.
+type jsonEffect struct {
+	Name   string             `json:"name"`
+	Period string             `json:"period,omitempty"`
+	Seed   int64              `json:"seed,omitempty"`
+	Color  string             `json:"color,omitempty"`
+	Mix    *float64           `json:"mix,omitempty"` // Fully mixed if omitted
+	Params map[string]float64 `json:"params,omitempty"`
+}
+
+// MarshalJSON encodes the effect as JSON.
+func (e *Effect) MarshalJSON() ([]byte, error) {
+	j := jsonEffect{Name: e.Name, Period: e.Period.String(), Seed: e.Seed, Mix: &e.Mix, Params: e.Params}
+	if e.Color != nil {
+		j.Color = FormatColorCode(e.Color)
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes and validates a JSON effect.
+func (e *Effect) UnmarshalJSON(data []byte) error {
+	j := jsonEffect{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	items := []string{"seed=" + strconv.FormatInt(j.Seed, 10)}
+	if len(j.Period) > 0 {
+		items = append(items, "period="+j.Period)
+	}
+	if len(j.Color) > 0 {
+		items = append(items, "color="+j.Color)
+	}
+	if j.Mix != nil {
+		items = append(items, "mix="+strconv.FormatFloat(*j.Mix, 'g', -1, 64))
+	}
+	for param, value := range j.Params {
+		items = append(items, param+"="+strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	for _, item := range items {
+		if strings.ContainsAny(item, ",|") || strings.Count(item, "=") != 1 {
+			return errors.New("Invalid effect parameter " + item)
+		}
+	}
+	parsed, err := ParseEffect(j.Name, strings.Join(items, ","))
+	if err != nil {
+		return err
+	}
+	*e = *parsed
+	return nil
+}
+
+// jsonSlot is the JSON representation of a Slot. Durations are strings like
+// "1.5s" or numbers of seconds.
+type jsonSlot struct {
+	Color      string          `json:"color,omitempty"` // A color code or segments
+	Motion     string          `json:"motion,omitempty"`
+	Fade       json.RawMessage `json:"fade,omitempty"`
+	Hold       json.RawMessage `json:"hold,omitempty"`
+	Transition string          `json:"transition,omitempty"`
+}
+
+// MarshalJSON encodes the slot as JSON.
+func (s *Slot) MarshalJSON() ([]byte, error) {
+	j := jsonSlot{
+		Fade:       json.RawMessage(`"` + s.Fade.String() + `"`),
+		Hold:       json.RawMessage(`"` + s.Hold.String() + `"`),
+		Transition: s.Transition,
+	}
+	j.Color = s.ColorSpec()
+	if s.Motion != nil {
+		j.Motion = s.Motion.String()
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a JSON slot.
+func (s *Slot) UnmarshalJSON(data []byte) error {
+	j := jsonSlot{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = Slot{Transition: "ease"}
+	if len(j.Transition) > 0 {
+		if strings.ContainsAny(j.Transition, ",|") {
+			return errors.New("Invalid slot transition " + j.Transition)
+		}
+		s.Transition = j.Transition
+	}
+	if strings.ContainsAny(j.Color, ",|") {
+		return errors.New("Invalid slot color " + j.Color)
+	}
+	err := s.setColorSpec(j.Color)
+	if err != nil {
+		return err
+	}
+	if len(j.Motion) > 0 {
+		if strings.ContainsAny(j.Motion, ",|") {
+			return errors.New("Invalid slot motion " + j.Motion)
+		}
+		if s.Motion, err = ParseMotion(j.Motion); err != nil {
+			return err
+		}
+	}
+	if s.Fade, err = parseJSONDuration(j.Fade); err != nil {
+		return err
+	}
+	s.Hold, err = parseJSONDuration(j.Hold)
+	return err
+}
+
+// parseJSONDuration parses a duration string or number of seconds.
+func parseJSONDuration(data json.RawMessage) (time.Duration, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		return secondsDuration(seconds, string(data))
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return 0, fmt.Errorf("Invalid duration %s", data)
+	}
+	return ParseDuration(text)
+}
+
+// jsonSchedule is the JSON representation of a Schedule.
+type jsonSchedule struct {
+	ID     string `json:"id"`
+	Start  string `json:"start,omitempty"`
+	End    string `json:"end,omitempty"`
+	Cron   string `json:"cron"`
+	Target string `json:"target"`
+	Extra  string `json:"extra,omitempty"`
+}
+
+// MarshalJSON encodes the schedule as JSON.
+func (s *Schedule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonSchedule{
+		s.ID,
+		formatScheduleDate(s.Start),
+		formatScheduleDate(s.End),
+		s.Cron,
+		s.Target,
+		s.Extra,
+	})
+}
+
+// UnmarshalJSON decodes and validates a JSON schedule.
+func (s *Schedule) UnmarshalJSON(data []byte) error {
+	j := jsonSchedule{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if err := checkSeparators(j.ID, j.Start, j.End, j.Cron, j.Target); err != nil {
+		return err
+	}
+	parsed, err := NewSchedule(strings.Join([]string{j.ID, j.Start, j.End, j.Cron, j.Target, j.Extra}, "|"))
+	if err != nil {
+		return err
+	}
+	*s = *parsed
+	return nil
+}
+
+// jsonScene is the JSON representation of a Scene.
+type jsonScene struct {
+	ID      string   `json:"id"`
+	Target  string   `json:"target"`
+	Devices []string `json:"devices"`
+}
+
+// MarshalJSON encodes the scene as JSON.
+func (s *Scene) MarshalJSON() ([]byte, error) {
+	devices := s.Devices
+	if devices == nil {
+		devices = []string{}
+	}
+	return json.Marshal(jsonScene{s.ID, s.Target, devices})
+}
+
+// UnmarshalJSON decodes and validates a JSON scene.
+func (s *Scene) UnmarshalJSON(data []byte) error {
+	j := jsonScene{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	fields := append([]string{j.ID, j.Target}, j.Devices...)
+	if err := checkSeparators(fields...); err != nil {
+		return err
+	}
+	parsed, err := NewScene(strings.Join(fields, "|"))
+	if err != nil {
+		return err
+	}
+	*s = *parsed
+	return nil
+}
+
+// checkSeparators checks JSON fields do not contain the `|` separator used
+// by the compact format.
+func checkSeparators(fields ...string) error {
+	for _, field := range fields {
+		if strings.Contains(field, "|") {
+			return fmt.Errorf("Field %q must not contain '|'", field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package lights_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("JSON", func() {
+		roundTrip := func(message string) *lights.Command {
+			cmd, err := lights.NewCommand(message)
+			Ω(err).ShouldNot(HaveOccurred())
+			data, err := json.Marshal(cmd)
+			Ω(err).ShouldNot(HaveOccurred())
+			decoded, err := lights.ParseJSONCommand(data)
+			Ω(err).ShouldNot(HaveOccurred(), string(data))
+			again, err := json.Marshal(decoded)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(again).Should(MatchJSON(data))
+			return decoded
+		}
+
+		It("should encode pattern commands with a typed payload", func() {
+			cmd, err := lights.NewCommand("!:ab:2|#F00,2,1|#00f,500ms")
+			Ω(err).ShouldNot(HaveOccurred())
+			data, err := json.Marshal(cmd)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(data).Should(MatchJSON(`{"action":"execute","type":"pattern","id":"ab","pattern":{"id":"ab","loops":2,"slots":[
+				{"color":"#FF0000","fade":"2s","hold":"1s","transition":"ease"},
+				{"color":"#0000FF","fade":"500ms","hold":"0s","transition":"ease"}]}}`))
+		})
+
+		It("should convert losslessly to and from the compact format", func() {
+			for _, message := range []string{
+				"!:ab|#F00,2,1|#FFF,2,1|#00F,2,1",
+				"!:1:3|#F00,1,2|#0F0,1,2|#00f,1,",
+				"?:ab",
+				"-:ab",
+				"!#F00",
+				"+~8|2015-07-04|2015-07-05|0 0 20 * * *|:ab|1",
+				"-~8",
+				"!^32|#F00,2|1|3|ab",
+				"?-brightness",
+				"!-brightness|40",
+			} {
+				original, _ := lights.NewCommand(message)
+				decoded := roundTrip(message)
+				Ω(decoded.Action).Should(Equal(original.Action), message)
+				Ω(decoded.Type).Should(Equal(original.Type), message)
+				Ω(decoded.ID).Should(Equal(original.ID), message)
+				if original.Type == "pattern" {
+					p1, _ := lights.NewPattern(":" + original.Body())
+					p2, _ := lights.NewPattern(":" + decoded.Body())
+					Ω(p2).Should(Equal(p1), message)
+				} else {
+					Ω(decoded.String()).Should(Equal(message))
+				}
+			}
+		})
+
+		It("should keep ID only pattern commands referring to stored patterns", func() {
+			for _, message := range []string{"!:fx.candle", "!:fx.candle.desk", "!:fx.candle.desk:2", "!:ab"} {
+				data, err := json.Marshal(mustCommand(message))
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(string(data)).ShouldNot(ContainSubstring(`"pattern":{`), message)
+				Ω(roundTrip(message).String()).Should(Equal(message))
+			}
+		})
+
+		It("should decode web client patterns", func() {
+			cmd, err := lights.ParseJSONCommand([]byte(`{"action":"add","type":"pattern",
+				"pattern":{"id":"ab","slots":[{"color":"#F00","fade":1.5,"hold":"2s"}]}}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(cmd.String()).Should(Equal("+:ab|#FF0000,1.5s,2s,ease"))
+			p, err := lights.NewPattern(":" + cmd.Body())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.Loops).Should(Equal(-1))
+			Ω(p.Slots[0].Fade).Should(Equal(1500 * time.Millisecond))
+		})
+
+		It("should check JSON durations like compact ones", func() {
+			for _, huge := range []string{"1e300", "-1e300", "9223372037"} {
+				_, err := lights.ParseJSONCommand([]byte(`{"action":"add","type":"pattern",
+					"pattern":{"id":"ab","slots":[{"color":"#F00","fade":` + huge + `}]}}`))
+				Ω(err).Should(MatchError("Duration out of range: "+huge), huge)
+			}
+			cmd, err := lights.ParseJSONCommand([]byte(`{"action":"add","type":"pattern",
+				"pattern":{"id":"ab","slots":[{"color":"#F00","fade":-1}]}}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(cmd.String()).Should(Equal("+:ab|#FF0000,-1s,0s,ease"))
+			p, err := lights.NewPattern(":" + cmd.Body())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.Validate()).Should(MatchError(ContainSubstring("negative duration")))
+		})
+
+		It("should reject invalid JSON commands", func() {
+			for _, data := range []string{
+				`{"action":"jump","type":"pattern","id":"ab"}`,
+				`{"action":"add","type":"pattern","pattern":{"id":"a|b","slots":[]}}`,
+				`{"action":"add","type":"pattern","pattern":{"id":"ab","slots":[{"color":"red"}]}}`,
+				`{"action":"add","type":"schedule","schedule":{"id":"8","cron":"0 20 * * *","target":":ab"}}`,
+				`{"action":"add","type":"scene","scene":{"id":"2","target":"#F00","devices":["a|b"]}}`,
+				`[]`,
+			} {
+				_, err := lights.ParseJSONCommand([]byte(data))
+				Ω(err).Should(HaveOccurred(), data)
+			}
+		})
+
+		It("should accept JSON requests in worker handlers", func() {
+			w, err := lights.NewWorker("gateway")
+			Ω(err).ShouldNot(HaveOccurred())
+			received := ""
+			Ω(w.Responder("/test/json", func(message string) (string, error) {
+				received = message
+				return "done", nil
+			})).Should(Succeed())
+			post := func(body, contentType string) *httptest.ResponseRecorder {
+				resp := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", "/test/json", strings.NewReader(body))
+				req.Header.Set("Content-Type", contentType)
+				http.DefaultServeMux.ServeHTTP(resp, req)
+				return resp
+			}
+			resp := post(`{"action":"execute","type":"color","parts":["F00"]}`, "application/json; charset=utf-8")
+			Ω(resp.Code).Should(Equal(http.StatusOK))
+			Ω(resp.Header().Get("Content-Type")).Should(Equal(lights.JSONContentType))
+			Ω(resp.Body.String()).Should(MatchJSON(`{"response":"done"}`))
+			Ω(received).Should(Equal("!#F00"))
+			resp = post("!#0F0", "text/plain")
+			Ω(resp.Body.String()).Should(Equal("done"))
+			Ω(received).Should(Equal("!#0F0"))
+			resp = post(`{"action":`, lights.JSONContentType)
+			Ω(resp.Code).Should(Equal(http.StatusBadRequest))
+			Ω(resp.Body.String()).Should(ContainSubstring(`"error"`))
+		})
+
+		It("should accept signed JSON requests through the guard", func() {
+			signer := &lights.HMACSigner{Key: []byte("secret")}
+			guard := lights.NewEnvelopeGuard(&lights.HMACVerifier{Keys: map[string][]byte{"gateway": []byte("secret")}}, 0)
+			w, err := lights.NewWorker("gateway")
+			Ω(err).ShouldNot(HaveOccurred())
+			w.Use(guard.Wrap)
+			received := []string{}
+			Ω(w.Handler("/test/json/signed", func(message string) error {
+				received = append(received, message)
+				return nil
+			})).Should(Succeed())
+			post := func(body string) *httptest.ResponseRecorder {
+				resp := httptest.NewRecorder()
+				req := httptest.NewRequest("POST", "/test/json/signed", strings.NewReader(body))
+				req.Header.Set("Content-Type", lights.JSONContentType)
+				http.DefaultServeMux.ServeHTTP(resp, req)
+				return resp
+			}
+			encode := func(sender, command string) string {
+				e, err := lights.NewEnvelope(sender, command, signer)
+				Ω(err).ShouldNot(HaveOccurred())
+				data, err := json.Marshal(e)
+				Ω(err).ShouldNot(HaveOccurred())
+				return string(data)
+			}
+
+			body := encode("gateway", "!:ab|#FF0000,1s,1s,ease")
+			Ω(body).Should(ContainSubstring(`"command":{"action":"execute","type":"pattern"`))
+			resp := post(body)
+			Ω(resp.Code).Should(Equal(http.StatusOK))
+			Ω(resp.Body.String()).Should(MatchJSON(`{"response":""}`))
+			Ω(post(body).Code).ShouldNot(Equal(http.StatusOK))
+
+			batch := encode("gateway", "&\n!#F00\n!#00F")
+			Ω(batch).Should(ContainSubstring(`"command":"\u0026\n!#F00\n!#00F"`))
+			Ω(post(batch).Code).Should(Equal(http.StatusOK))
+
+			Ω(post(encode("intruder", "!#F00")).Code).ShouldNot(Equal(http.StatusOK))
+			Ω(post(strings.Replace(encode("gateway", "!#F00"), `"F00"`, `"0F0"`, 1)).Code).ShouldNot(Equal(http.StatusOK))
+			Ω(post(`{"action":"execute","type":"color","parts":["F00"]}`).Code).ShouldNot(Equal(http.StatusOK))
+			Ω(post(`{"sender":"gateway","sig":"!"}`).Code).Should(Equal(http.StatusBadRequest))
+			Ω(received).Should(Equal([]string{"!:ab|#FF0000,1s,1s,ease", "&\n!#F00\n!#00F"}))
+		})
+	})
+})
@@ -0,0 +1,207 @@
+package lights
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lint problem severities. Patterns with errors are rejected by NewPattern
+// or Validate, warnings point out patterns that probably do not do what
+// was intended.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintMaxLoops is the loop count above which patterns are warned about.
+const LintMaxLoops = 1000
+
+// LintProblem is a problem found in a pattern specification. Offset is the
+// byte offset into the specification where the problem starts so a UI can
+// point at it.
+type LintProblem struct {
+	Severity string `json:"severity"`
+	Slot     int    `json:"slot"` // 1-based slot index (0 for the header)
+	Offset   int    `json:"offset"`
+	Message  string `json:"message"`
+}
+
+// String describes the problem, for example
+// `error at 12 (slot 2): Invalid fade "2x"`.
+func (p LintProblem) String() string {
+	where := "header"
+	if p.Slot > 0 {
+		where = "slot " + strconv.Itoa(p.Slot)
+	}
+	return fmt.Sprintf("%s at %d (%s): %s", p.Severity, p.Offset, where, p.Message)
+}
+
+// LintPattern checks a pattern specification and reports every problem
+// found rather than stopping at the first one. The specification may start
+// with a command code (as in `+:ab|#F00`) so commands can be checked
+// before they are sent. No problems are returned for a pattern that
+// parses, validates and plays as written.
+func LintPattern(spec string) []LintProblem {
+	l := &patternLinter{problems: []LintProblem{}}
+	start := 0
+	if len(spec) >= 2 && strings.IndexByte("!+-?", spec[0]) >= 0 && spec[1] == ':' {
+		start = 1
+	}
+	parts, offsets := splitOffsets(spec[start:], "|", start)
+	l.header(parts[0], offsets[0])
+	slots, slotOffsets := parts[1:], offsets[1:]
+	if l.effect {
+		// Effect parameters come before any slots
+		if len(slots) > 0 {
+			if _, known := effectDefaults[EffectName(l.id)]; known {
+				if _, err := ParseEffect(EffectName(l.id), slots[0]); err != nil {
+					l.add(LintError, 0, slotOffsets[0], err.Error())
+				}
+			}
+			slots, slotOffsets = slots[1:], slotOffsets[1:]
+		}
+	} else if len(slots) == 0 {
+		l.add(LintError, 0, offsets[0], "Pattern has no slots")
+	}
+	var cycle time.Duration
+	for i, slot := range slots {
+		cycle += l.slot(i+1, slot, slotOffsets[i])
+	}
+	if len(slots) > 0 && cycle == 0 && l.valid {
+		l.add(LintWarning, 0, offsets[0], "Pattern has zero duration - every slot has no fade or hold")
+	}
+	return l.problems
+}
+
+// LintErrors returns the problems that are errors.
+func LintErrors(problems []LintProblem) []LintProblem {
+	errors := []LintProblem{}
+	for _, p := range problems {
+		if p.Severity == LintError {
+			errors = append(errors, p)
+		}
+	}
+	return errors
+}
+
+// patternLinter collects the problems in a pattern specification.
+type patternLinter struct {
+	problems []LintProblem
+	id       string
+	effect   bool
+	valid    bool // False once a duration could not be checked
+}
+
+// add records a problem.
+func (l *patternLinter) add(severity string, slot, offset int, message string) {
+	l.problems = append(l.problems, LintProblem{severity, slot, offset, message})
+}
+
+// header checks the `:ID[:loops]` header.
+func (l *patternLinter) header(header string, offset int) {
+	l.valid = true
+	if !strings.HasPrefix(header, ":") {
+		l.add(LintError, 0, offset, "Pattern must start with ':'")
+		header = ":" + header
+		offset--
+	}
+	fields, offsets := splitOffsets(header, ":", offset)
+	if len(fields) > 3 {
+		l.add(LintError, 0, offsets[3]-1, "Pattern header must be :ID[:loops]")
+	}
+	l.id = strings.TrimSpace(fields[1])
+	if err := ValidateName("pattern", l.id); err != nil {
+		l.add(LintError, 0, offsets[1], err.Error())
+	}
+	if l.effect = IsEffectID(l.id); l.effect {
+		if _, ok := effectDefaults[EffectName(l.id)]; !ok {
+			l.add(LintError, 0, offsets[1], "Unknown effect: "+EffectName(l.id))
+		}
+	}
+	if len(fields) < 3 || len(strings.TrimSpace(fields[2])) == 0 {
+		return
+	}
+	loops, err := strconv.Atoi(strings.TrimSpace(fields[2]))
+	switch {
+	case err != nil:
+		l.add(LintError, 0, offsets[2], fmt.Sprintf("Loop count %q was not an integer", fields[2]))
+	case loops < -1:
+		l.add(LintError, 0, offsets[2], fmt.Sprintf("Loop count must not be negative - found %d", loops))
+	case loops > LintMaxLoops:
+		l.add(LintWarning, 0, offsets[2], fmt.Sprintf("Loop count %d is very large - use -1 to loop forever", loops))
+	}
+}
+
+// slot checks a slot returning how long it plays.
+func (l *patternLinter) slot(index int, slot string, offset int) time.Duration {
+	if len(strings.TrimSpace(slot)) == 0 {
+		l.add(LintError, index, offset, "Slot is empty")
+		return 0
+	}
+	fields, offsets := splitOffsets(slot, ",", offset)
+	if len(fields) > 5 {
+		l.add(LintError, index, offsets[5], fmt.Sprintf("Slot has %d fields - expected at most 5 (color, fade, hold, transition, motion)", len(fields)))
+	}
+	field := func(i int) string {
+		if i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	if color := field(0); len(color) == 0 {
+		l.add(LintError, index, offsets[0], "Slot has no color")
+	} else if err := (&Slot{}).setColorSpec(color); err != nil {
+		l.add(LintError, index, offsets[0], err.Error())
+	}
+	var played time.Duration
+	for i, name := range []string{"fade", "hold"} {
+		value := field(i + 1)
+		if len(value) == 0 {
+			continue
+		}
+		d, err := ParseDuration(value)
+		switch {
+		case err != nil:
+			l.add(LintError, index, offsets[i+1], fmt.Sprintf("Invalid %s %q: %s", name, value, err))
+			l.valid = false
+		case d < 0:
+			l.add(LintError, index, offsets[i+1], fmt.Sprintf("Slot %s must not be negative - found %s", name, d))
+			l.valid = false
+		default:
+			played += d
+		}
+	}
+	if transition := field(3); len(transition) > 0 && !knownTransition(transition) {
+		l.add(LintWarning, index, offsets[3], fmt.Sprintf("Unknown transition %q plays as ease - expected one of %s", transition, strings.Join(Transitions, ", ")))
+	}
+	if motion := field(4); len(motion) > 0 {
+		if _, err := ParseMotion(motion); err != nil {
+			l.add(LintError, index, offsets[4], err.Error())
+		}
+	}
+	return played
+}
+
+// knownTransition returns true if playback understands a transition.
+func knownTransition(transition string) bool {
+	for _, known := range Transitions {
+		if transition == known {
+			return true
+		}
+	}
+	return false
+}
+
+// splitOffsets splits text returning the parts and the offset of each
+// part's first non-space character (offset is the offset of text).
+func splitOffsets(text, separator string, offset int) ([]string, []int) {
+	parts := strings.Split(text, separator)
+	offsets := make([]int, len(parts))
+	for i, part := range parts {
+		offsets[i] = offset + len(part) - len(strings.TrimLeft(part, " \t"))
+		offset += len(part) + len(separator)
+	}
+	return parts, offsets
+}
@@ -0,0 +1,101 @@
+package lights_test
+
+import (
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Lint", func() {
+		// where summarizes problems as severity, slot and offset.
+		where := func(problems []lights.LintProblem) [][3]interface{} {
+			found := [][3]interface{}{}
+			for _, p := range problems {
+				found = append(found, [3]interface{}{p.Severity, p.Slot, p.Offset})
+			}
+			return found
+		}
+
+		It("should accept clean patterns", func() {
+			for _, spec := range []string{
+				":ab:3|#F00,1,2,linear|#00F,1s",
+				"+:ab|#F00,1",
+				":ab|#F00/#00F@2-4,1,1,ease,scroll:5:left",
+				":fx.candle",
+				"!:fx.strobe|duty=0.5|#F00,0,1",
+			} {
+				Ω(lights.LintPattern(spec)).Should(BeEmpty(), spec)
+			}
+		})
+
+		It("should report every problem with its position", func() {
+			spec := ":ab:x|#F00,2x|#GGG,1,1,bounce|,1|#00F,1,1,ease,spin,extra"
+			problems := lights.LintPattern(spec)
+			Ω(where(problems)).Should(Equal([][3]interface{}{
+				{lights.LintError, 0, 4},
+				{lights.LintError, 1, 11},
+				{lights.LintError, 2, 14},
+				{lights.LintWarning, 2, 23},
+				{lights.LintError, 3, 30},
+				{lights.LintError, 4, 52},
+				{lights.LintError, 4, 47},
+			}))
+			Ω(problems[0].Message).Should(Equal(`Loop count "x" was not an integer`))
+			Ω(problems[1].String()).Should(HavePrefix(`error at 11 (slot 1): Invalid fade "2x"`))
+			Ω(problems[3].Message).Should(ContainSubstring("ease, linear, ease-in, ease-out, step"))
+			Ω(problems[5].Message).Should(ContainSubstring("6 fields"))
+			Ω(lights.LintErrors(problems)).Should(HaveLen(6))
+			Ω(spec[problems[4].Offset:]).Should(HavePrefix(",1"))
+
+			Ω(where(lights.LintPattern("!:ab|#F00,x"))).Should(Equal([][3]interface{}{{lights.LintError, 1, 10}}))
+			Ω(where(lights.LintPattern(":ab|#F00, -1"))).Should(Equal([][3]interface{}{{lights.LintError, 1, 10}}))
+		})
+
+		It("should report header problems", func() {
+			Ω(where(lights.LintPattern("ab|#F00,1"))).Should(Equal([][3]interface{}{{lights.LintError, 0, 0}}))
+			Ω(where(lights.LintPattern(":|#F00,1"))).Should(Equal([][3]interface{}{{lights.LintError, 0, 1}}))
+			Ω(where(lights.LintPattern(":a:1:2|#F00,1"))).Should(Equal([][3]interface{}{{lights.LintError, 0, 4}}))
+			Ω(lights.LintPattern(":ab")[0].Message).Should(Equal("Pattern has no slots"))
+			Ω(where(lights.LintPattern(":ab|#F00,1|"))).Should(Equal([][3]interface{}{{lights.LintError, 2, 11}}))
+			Ω(where(lights.LintPattern(":fx.candle|depth=2"))).Should(Equal([][3]interface{}{{lights.LintError, 0, 11}}))
+			Ω(where(lights.LintPattern(":fx.lava|x=1"))).Should(Equal([][3]interface{}{{lights.LintError, 0, 1}}))
+		})
+
+		It("should warn about suspicious patterns", func() {
+			problems := lights.LintPattern(":ab:5000|#F00")
+			Ω(where(problems)).Should(Equal([][3]interface{}{
+				{lights.LintWarning, 0, 4},
+				{lights.LintWarning, 0, 0},
+			}))
+			Ω(problems[1].Message).Should(ContainSubstring("zero duration"))
+			Ω(lights.LintErrors(problems)).Should(BeEmpty())
+		})
+
+		It("should agree with parsing and validation", func() {
+			for _, spec := range []string{
+				":ab|#F00,1", ":ab:x|#F00,1", ":ab:-2|#F00,1", ":ab|#F00,x", ":ab|#F00,-1", ":ab|,1",
+				":ab|#F00,1|", ":ab|#F0,1", ":ab|#F00,1,1,ease,spin", ":ab|#F00,1,1,ease,chase,x",
+				":|#F00,1", ":a:1:2|#F00,1", ":ab", ":fx.noise", ":fx.noise|scale=x", ":fx.lava",
+			} {
+				p, err := lights.NewPattern(spec)
+				if err == nil {
+					err = p.Validate()
+				}
+				problems := lights.LintErrors(lights.LintPattern(spec))
+				Ω(len(problems) == 0).Should(Equal(err == nil), spec)
+			}
+		})
+
+		It("should say which slot failed to parse", func() {
+			_, err := lights.NewPattern(":ab|#F00,1|#00F,2x")
+			Ω(err).Should(MatchError(HavePrefix(`Invalid slot 2: Invalid fade "2x"`)))
+			_, err = lights.NewSlot("#F00,1,1,ease,chase,x")
+			Ω(err).Should(MatchError(ContainSubstring("6 fields")))
+			p, err := lights.NewPattern(":ab|#F00,-1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.Validate()).ShouldNot(Succeed())
+		})
+	})
+})
@@ -0,0 +1,584 @@
+package lights
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// logMagic starts every LogStore file.
+const logMagic = "LIGHTS01"
+
+// maxRecordSize guards against allocating huge buffers for corrupt records.
+const maxRecordSize = 64 << 20
+
+// errFileLocked is returned by lockFile when another process holds a lock.
+var errFileLocked = errors.New("File is locked by another process")
+
+// errTornHeader is returned by readRecord when the log ends part way
+// through a record header, as it does when an append is interrupted.
+var errTornHeader = errors.New("Truncated record header")
+
+// Operation codes recorded in the LogStore file.
+const (
+	opWrite byte = iota + 1
+	opRemove
+	opRemoveAll
+)
+
+// LogStore implements the Store interface using a single append-only log
+// file. Every change is appended as a checksummed record and the current
+// values are kept in memory, so loading a collection never touches the disk.
+// Changes made in a Batch are written as one record and are applied all
+// together or not at all. A record header torn by a power loss at the end
+// of the log is discarded when the store is opened, while any other damage
+// (including a record whose length runs past the end of the log) stops the
+// store opening so no records are silently lost. Call Compact to
+// rewrite the log without stale records.
+//
+// Values are cached in memory so a log can only be used by one process at a
+// time. The store holds an exclusive lock on a `.lock` file next to the log
+// until it is closed and fails to open while another store holds it.
+type LogStore struct {
+	Path string // The path to the log file
+
+	lock     sync.RWMutex
+	writers  sync.Mutex // Held by LockWrites
+	locked   *os.File   // The held lock file
+	file     *os.File
+	data     map[string]map[string]string
+	stale    int // Records in the log that no longer hold live values
+	watchers storeWatchers
+}
+
+// NewLogStore opens (or creates) a log store at the given path.
+func NewLogStore(path string) (*LogStore, error) {
+	path, err := PrepPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), storeDirMode); err != nil {
+		return nil, err
+	}
+	s := &LogStore{Path: path}
+	if s.locked, err = os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, storeFileMode); err != nil {
+		return nil, err
+	}
+	if err = lockFile(s.locked, false); err != nil {
+		s.locked.Close()
+		if err == errFileLocked {
+			return nil, fmt.Errorf("Log store %s is already open in another process", path)
+		}
+		return nil, err
+	}
+	if err = s.open(); err != nil {
+		s.unlock()
+		return nil, err
+	}
+	return s, nil
+}
+
+// logOp is a single change recorded in the log.
+type logOp struct {
+	op         byte
+	collection string
+	id         string
+	value      string
+}
+
+// Batch collects changes to a store that are committed atomically. Store
+// wrappers stage changes in a batch that is not attached to a store and
+// copy them into the wrapped store's batch.
+type Batch struct {
+	store *LogStore
+	ops   []logOp
+}
+
+// Batch starts a new set of changes. Nothing is changed until Commit is
+// called.
+func (s *LogStore) Batch() *Batch {
+	return &Batch{store: s}
+}
+
+// Write a value to the provided collection with a given ID.
+func (b *Batch) Write(collection, id, value string) error {
+	if err := validateItem(collection, id, value); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, logOp{opWrite, collection, id, value})
+	return nil
+}
+
+// Remove a value from the provided collection with a given ID.
+func (b *Batch) Remove(collection, id string) error {
+	if err := validateNames(collection, id); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, logOp{op: opRemove, collection: collection, id: id})
+	return nil
+}
+
+// RemoveAll removes all values from the provided collection.
+func (b *Batch) RemoveAll(collection string) error {
+	if err := validateNames(collection); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, logOp{op: opRemoveAll, collection: collection})
+	return nil
+}
+
+// Commit writes all the changes in the batch as a single record. Once the
+// record is durable the commit succeeds even if compacting the log fails.
+func (b *Batch) Commit() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	s := b.store
+	if s == nil {
+		return errors.New("Batch is not attached to a store")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.append(b.ops); err != nil {
+		return err
+	}
+	b.ops = nil
+	if err := s.maybeCompact(); err != nil {
+		log.Println("store could not compact", s.Path, err)
+	}
+	return nil
+}
+
+// Update runs fn with a new batch and commits it if fn returns nil.
+func (s *LogStore) Update(fn func(b *Batch) error) error {
+	b := s.Batch()
+	if err := fn(b); err != nil {
+		return err
+	}
+	return b.Commit()
+}
+
+// Read a value from the provided collection with a given ID.
+func (s *LogStore) Read(collection, id string) (string, error) {
+	if err := validateNames(collection, id); err != nil {
+		return "", err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	value, ok := s.data[collection][id]
+	if !ok {
+		return "", &NotFoundError{collection, id}
+	}
+	return value, nil
+}
+
+// Write a value to the provided collection with a given ID.
+func (s *LogStore) Write(collection, id, value string) error {
+	return s.Update(func(b *Batch) error {
+		return b.Write(collection, id, value)
+	})
+}
+
+// Remove a value from the provided collection with a given ID.
+func (s *LogStore) Remove(collection, id string) error {
+	if _, err := s.Read(collection, id); err != nil {
+		return err
+	}
+	return s.Update(func(b *Batch) error {
+		return b.Remove(collection, id)
+	})
+}
+
+// RemoveAll removes all values from the provided collection.
+func (s *LogStore) RemoveAll(collection string) error {
+	return s.Update(func(b *Batch) error {
+		return b.RemoveAll(collection)
+	})
+}
+
+// List the IDs of all items in a collection in sorted order.
+func (s *LogStore) List(collection string) ([]string, error) {
+	if err := validateNames(collection); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	ids := []string{}
+	for id := range s.data[collection] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Collections lists the names of all collections in sorted order.
+func (s *LogStore) Collections() ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	names := []string{}
+	for name, c := range s.data {
+		if len(c) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Each calls fn with every item in a collection in ID order.
+func (s *LogStore) Each(collection string, fn func(id, value string) error) error {
+	ids, err := s.List(collection)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		s.lock.RLock()
+		value, ok := s.data[collection][id]
+		s.lock.RUnlock()
+		if !ok {
+			continue // Removed during iteration
+		}
+		if err = fn(id, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load all the values from a collection in ID order.
+func (s *LogStore) Load(collection string) ([]string, error) {
+	return loadValues(s, collection)
+}
+
+// LoadMap loads all the items from a collection keyed by ID.
+func (s *LogStore) LoadMap(collection string) (map[string]string, error) {
+	return loadMap(s, collection)
+}
+
+// Watch reports changes made through this store to a collection.
+func (s *LogStore) Watch(collection string) (*Watcher, error) {
+	if err := validateNames(collection); err != nil {
+		return nil, err
+	}
+	return s.watchers.watch(collection), nil
+}
+
+// LockWrites holds off other callers of LockWrites. Other processes can not
+// open the log while the store is open.
+func (s *LogStore) LockWrites() (func(), error) {
+	s.writers.Lock()
+	return s.writers.Unlock, nil
+}
+
+// Compact rewrites the log so it only contains the current values.
+func (s *LogStore) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.compact()
+}
+
+// Close the underlying log file and release the lock on it.
+func (s *LogStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	s.unlock()
+	return err
+}
+
+// unlock releases the lock file.
+func (s *LogStore) unlock() {
+	if s.locked != nil {
+		unlockFile(s.locked)
+		s.locked.Close()
+		s.locked = nil
+	}
+}
+
+// open reads the log file into memory, discarding a torn record at the end.
+// A damaged record followed by more of the log is reported as an error.
+func (s *LogStore) open() error {
+	file, err := os.OpenFile(s.Path, os.O_RDWR|os.O_CREATE, storeFileMode)
+	if err != nil {
+		return err
+	}
+	s.data = map[string]map[string]string{}
+	s.stale = 0
+	r := bufio.NewReader(file)
+	magic := make([]byte, len(logMagic))
+	n, err := io.ReadFull(r, magic)
+	switch {
+	case n == 0 && err == io.EOF:
+		// New log
+		if _, err = file.WriteString(logMagic); err == nil {
+			err = file.Sync()
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+		s.file = file
+		return nil
+	case err != nil || string(magic) != logMagic:
+		file.Close()
+		return fmt.Errorf("Not a log store file: %s", s.Path)
+	}
+	good := int64(len(logMagic)) // Offset of the end of the last good record
+	for {
+		ops, size, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A damaged length can make any record look like it runs to the
+			// end of the log, so only a short header is a torn append
+			if err != errTornHeader {
+				file.Close()
+				return fmt.Errorf("Log store %s is corrupt at offset %d: %s", s.Path, good, err)
+			}
+			log.Println("store discarding torn log record in", s.Path, "at", good, err)
+			if err = file.Truncate(good); err != nil {
+				file.Close()
+				return err
+			}
+			break
+		}
+		s.apply(ops)
+		good += size
+	}
+	if _, err = file.Seek(good, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	return nil
+}
+
+// append writes a record for the ops and applies them to memory.
+func (s *LogStore) append(ops []logOp) error {
+	if s.file == nil {
+		return errors.New("Log store is closed " + s.Path)
+	}
+	offset, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(encodeRecord(ops))
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		// Drop any partial record so later records are not lost behind it
+		s.file.Truncate(offset)
+		s.file.Seek(offset, io.SeekStart)
+		return err
+	}
+	s.watchers.notify(s.apply(ops)...)
+	return nil
+}
+
+// apply the ops to the in-memory values returning the resulting changes.
+func (s *LogStore) apply(ops []logOp) []StoreEvent {
+	events := []StoreEvent{}
+	for _, op := range ops {
+		c := s.data[op.collection]
+		switch op.op {
+		case opWrite:
+			if c == nil {
+				c = map[string]string{}
+				s.data[op.collection] = c
+			}
+			event := StoreEvent{EventCreate, op.collection, op.id}
+			if _, ok := c[op.id]; ok {
+				event.Type = EventUpdate
+				s.stale++
+			}
+			c[op.id] = op.value
+			events = append(events, event)
+		case opRemove:
+			if _, ok := c[op.id]; ok {
+				s.stale++
+				delete(c, op.id)
+				events = append(events, StoreEvent{EventDelete, op.collection, op.id})
+			}
+			s.stale++
+		case opRemoveAll:
+			for id := range c {
+				events = append(events, StoreEvent{EventDelete, op.collection, id})
+			}
+			s.stale += len(c) + 1
+			delete(s.data, op.collection)
+		}
+	}
+	return events
+}
+
+// maybeCompact compacts the log once most of it is stale.
+func (s *LogStore) maybeCompact() error {
+	live := 0
+	for _, c := range s.data {
+		live += len(c)
+	}
+	if s.stale < 1000 || s.stale < live {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact writes the live values to a new log and swaps it into place.
+func (s *LogStore) compact() error {
+	if s.file == nil {
+		return errors.New("Log store is closed " + s.Path)
+	}
+	ops := []logOp{}
+	collections := []string{}
+	for collection := range s.data {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	for _, collection := range collections {
+		ids := []string{}
+		for id := range s.data[collection] {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			ops = append(ops, logOp{opWrite, collection, id, s.data[collection][id]})
+		}
+	}
+	tmp := s.Path + ".compact"
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, storeFileMode)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(logMagic)
+	if err == nil && len(ops) > 0 {
+		_, err = file.Write(encodeRecord(ops))
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.Path)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	s.file.Close()
+	s.file = file
+	s.stale = 0
+	return syncDir(filepath.Dir(s.Path))
+}
+
+// encodeRecord encodes ops as a length and checksum prefixed record.
+func encodeRecord(ops []logOp) []byte {
+	payload := &bytes.Buffer{}
+	putUvarint(payload, uint64(len(ops)))
+	for _, op := range ops {
+		payload.WriteByte(op.op)
+		putString(payload, op.collection)
+		if op.op != opRemoveAll {
+			putString(payload, op.id)
+		}
+		if op.op == opWrite {
+			putString(payload, op.value)
+		}
+	}
+	record := make([]byte, 8, 8+payload.Len())
+	binary.BigEndian.PutUint32(record[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	return append(record, payload.Bytes()...)
+}
+
+// readRecord reads the next record returning its ops and size on disk.
+// errTornHeader is returned if the log ends part way through the header.
+func readRecord(r *bufio.Reader) ([]logOp, int64, error) {
+	header := make([]byte, 8)
+	n, err := io.ReadFull(r, header)
+	if n == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		return nil, int64(n), errTornHeader
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	recordSize := int64(len(header)) + int64(size)
+	if size > maxRecordSize {
+		return nil, recordSize, errors.New("Record too large")
+	}
+	payload := make([]byte, size)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return nil, recordSize, errors.New("Truncated record")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, recordSize, errors.New("Record checksum mismatch")
+	}
+	p := bytes.NewReader(payload)
+	count, err := binary.ReadUvarint(p)
+	if err != nil {
+		return nil, recordSize, err
+	}
+	ops := []logOp{}
+	for i := uint64(0); i < count; i++ {
+		op := logOp{}
+		if op.op, err = p.ReadByte(); err != nil {
+			return nil, recordSize, err
+		}
+		if op.collection, err = getString(p); err != nil {
+			return nil, recordSize, err
+		}
+		if op.op != opRemoveAll {
+			if op.id, err = getString(p); err != nil {
+				return nil, recordSize, err
+			}
+		}
+		if op.op == opWrite {
+			if op.value, err = getString(p); err != nil {
+				return nil, recordSize, err
+			}
+		}
+		ops = append(ops, op)
+	}
+	return ops, recordSize, nil
+}
+
+// putUvarint appends a varint to the buffer.
+func putUvarint(b *bytes.Buffer, v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	b.Write(buf[:binary.PutUvarint(buf, v)])
+}
+
+// putString appends a length prefixed string to the buffer.
+func putString(b *bytes.Buffer, s string) {
+	putUvarint(b, uint64(len(s)))
+	b.WriteString(s)
+}
+
+// getString reads a length prefixed string.
+func getString(r *bytes.Reader) (string, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if size > uint64(r.Len()) {
+		return "", errors.New("Truncated string")
+	}
+	buf := make([]byte, size)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
@@ -0,0 +1,214 @@
+package lights_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+
+	Describe("Log store", func() {
+		var dir, path string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "lights-log")
+			Ω(err).ShouldNot(HaveOccurred())
+			path = filepath.Join(dir, "data.log")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should persist values across opens", func() {
+			s, err := lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			Ω(s.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			Ω(s.Write("patterns", "ab", ":ab|#00F")).Should(Succeed())
+			Ω(s.Remove("patterns", "cd")).Should(Succeed())
+			Ω(s.Write("scenes", "1", "1|#FFF")).Should(Succeed())
+			Ω(s.RemoveAll("scenes")).Should(Succeed())
+			Ω(s.Close()).Should(Succeed())
+
+			s, err = lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer s.Close()
+			items, err := s.LoadMap("patterns")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(items).Should(Equal(map[string]string{"ab": ":ab|#00F"}))
+			ids, err := s.List("scenes")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ids).Should(BeEmpty())
+		})
+
+		It("should only be opened by one store at a time", func() {
+			s, err := lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = lights.NewLogStore(path)
+			Ω(err).Should(MatchError(ContainSubstring("already open in another process")))
+			Ω(s.Close()).Should(Succeed())
+			s, err = lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Close()).Should(Succeed())
+		})
+
+		It("should apply batches all or nothing", func() {
+			s, err := lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer s.Close()
+			err = s.Update(func(b *lights.Batch) error {
+				b.Write("patterns", "ab", ":ab|#F00")
+				return errors.New("abort")
+			})
+			Ω(err).Should(MatchError("abort"))
+			_, err = s.Read("patterns", "ab")
+			Ω(err).Should(HaveOccurred())
+
+			b := s.Batch()
+			Ω(b.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			Ω(b.Write("scenes", "1", "1|:ab")).Should(Succeed())
+			Ω(b.Write("", "2", "2|:ab")).ShouldNot(Succeed())
+			Ω(b.Commit()).Should(Succeed())
+			loaded, err := s.Load("scenes")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(loaded).Should(Equal([]string{"1|:ab"}))
+		})
+
+		It("should discard a torn record at the end of the log", func() {
+			s, err := lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			Ω(s.Close()).Should(Succeed())
+			info, err := os.Stat(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			good := info.Size()
+
+			s, err = lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			Ω(s.Close()).Should(Succeed())
+			Ω(os.Truncate(path, good+5)).Should(Succeed())
+
+			s, err = lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			ids, err := s.List("patterns")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ids).Should(Equal([]string{"ab"}))
+			info, err = os.Stat(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(info.Size()).Should(Equal(good))
+			Ω(s.Write("patterns", "ef", ":ef|#00F")).Should(Succeed())
+			Ω(s.Close()).Should(Succeed())
+
+			s, err = lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer s.Close()
+			ids, err = s.List("patterns")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ids).Should(Equal([]string{"ab", "ef"}))
+		})
+
+		It("should refuse to open a log damaged before its end", func() {
+			s, err := lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			info, err := os.Stat(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			good := info.Size()
+			Ω(s.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			Ω(s.Write("patterns", "ef", ":ef|#00F")).Should(Succeed())
+			Ω(s.Close()).Should(Succeed())
+
+			data, err := ioutil.ReadFile(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			data[good+10] ^= 0xff
+			Ω(ioutil.WriteFile(path, data, 0660)).Should(Succeed())
+			_, err = lights.NewLogStore(path)
+			Ω(err).Should(MatchError(ContainSubstring("is corrupt at offset")))
+			after, err := ioutil.ReadFile(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(after).Should(Equal(data))
+		})
+
+		It("should refuse to open a log with a damaged record length", func() {
+			s, err := lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			info, err := os.Stat(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			good := info.Size()
+			Ω(s.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			Ω(s.Write("patterns", "ef", ":ef|#00F")).Should(Succeed())
+			Ω(s.Close()).Should(Succeed())
+
+			// The middle record now claims to run past the end of the log
+			data, err := ioutil.ReadFile(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			binary.BigEndian.PutUint32(data[good:good+4], 4096)
+			Ω(ioutil.WriteFile(path, data, 0660)).Should(Succeed())
+			_, err = lights.NewLogStore(path)
+			Ω(err).Should(MatchError(ContainSubstring("is corrupt at offset")))
+			after, err := ioutil.ReadFile(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(after).Should(Equal(data))
+		})
+
+		It("should commit changes when compaction fails", func() {
+			s, err := lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer s.Close()
+			Ω(os.Mkdir(path+".compact", 0700)).Should(Succeed())
+			for i := 0; i < 1002; i++ {
+				Ω(s.Write("status", "last", strconv.Itoa(i))).Should(Succeed())
+			}
+			Ω(s.Compact()).ShouldNot(Succeed())
+			Ω(s.Read("status", "last")).Should(Equal("1001"))
+		})
+
+		It("should compact the log to the live values", func() {
+			s, err := lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			for i := 0; i < 50; i++ {
+				Ω(s.Write("status", "last", "#F00")).Should(Succeed())
+			}
+			before, err := os.Stat(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Compact()).Should(Succeed())
+			after, err := os.Stat(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(after.Size()).Should(BeNumerically("<", before.Size()))
+			Ω(s.Write("status", "next", "#0F0")).Should(Succeed())
+			Ω(s.Close()).Should(Succeed())
+
+			s, err = lights.NewLogStore(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer s.Close()
+			items, err := s.LoadMap("status")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(items).Should(Equal(map[string]string{"last": "#F00", "next": "#0F0"}))
+		})
+
+		It("should be selectable by configuration", func() {
+			s, err := lights.OpenStore("log:" + path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s).Should(BeAssignableToTypeOf(&lights.LogStore{}))
+			s.(*lights.LogStore).Close()
+			s, err = lights.OpenStore("file:" + dir)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s).Should(BeAssignableToTypeOf(&lights.FileStore{}))
+			_, err = lights.OpenStore("tape:" + dir)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,316 @@
+package lights
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryItem is a value held by a MemoryStore.
+type memoryItem struct {
+	Value   string    `json:"v"`
+	Expires time.Time `json:"e,omitempty"` // Zero if the item never expires
+}
+
+// expired returns true if the item has expired by the given time.
+func (i memoryItem) expired(now time.Time) bool {
+	return !i.Expires.IsZero() && !now.Before(i.Expires)
+}
+
+// MemoryStore is a Store that keeps items in memory, safe for concurrent
+// use. Items can be given a time to live after which they are treated as
+// removed, making it suitable for runtime caches such as the last known
+// device status. Use OpenMemoryStore to keep a snapshot on disk that
+// survives restarts.
+type MemoryStore struct {
+	TTL time.Duration // Time to live used by Write (zero never expires)
+
+	lock     sync.RWMutex
+	data     map[string]map[string]memoryItem
+	watchers storeWatchers
+	path     string        // Snapshot file, if any
+	done     chan struct{} // Stops the background snapshot loop
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: map[string]map[string]memoryItem{}}
+}
+
+// OpenMemoryStore creates an in-memory store that is loaded from the
+// snapshot file at path (if it exists) and saved back to it every interval
+// and on Close. Expired items are also removed every interval.
+func OpenMemoryStore(path string, interval time.Duration) (*MemoryStore, error) {
+	path, err := PrepPath(path)
+	if err != nil {
+		return nil, err
+	}
+	m := NewMemoryStore()
+	m.path = path
+	text, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(text, &m.data); err != nil {
+			return nil, err
+		}
+	}
+	if interval > 0 {
+		done := make(chan struct{})
+		m.done = done
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					m.Expire()
+					if err := m.Save(); err != nil {
+						log.Println("store could not save snapshot", m.path, err)
+					}
+				}
+			}
+		}()
+	}
+	return m, nil
+}
+
+// Read a value from the provided collection with a given ID.
+func (m *MemoryStore) Read(collection, id string) (string, error) {
+	if err := validateNames(collection, id); err != nil {
+		return "", err
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	item, ok := m.data[collection][id]
+	if !ok || item.expired(time.Now()) {
+		return "", &NotFoundError{collection, id}
+	}
+	return item.Value, nil
+}
+
+// Write a value to the provided collection with a given ID using the
+// store's default TTL.
+func (m *MemoryStore) Write(collection, id, value string) error {
+	return m.WriteTTL(collection, id, value, m.TTL)
+}
+
+// WriteTTL writes a value that expires after ttl (zero never expires).
+func (m *MemoryStore) WriteTTL(collection, id, value string, ttl time.Duration) error {
+	if err := validateItem(collection, id, value); err != nil {
+		return err
+	}
+	now := time.Now()
+	item := memoryItem{Value: value}
+	if ttl > 0 {
+		item.Expires = now.Add(ttl)
+	}
+	m.lock.Lock()
+	if m.data == nil {
+		m.data = map[string]map[string]memoryItem{}
+	}
+	c, ok := m.data[collection]
+	if !ok {
+		c = map[string]memoryItem{}
+		m.data[collection] = c
+	}
+	event := StoreEvent{EventCreate, collection, id}
+	if previous, ok := c[id]; ok && !previous.expired(now) {
+		event.Type = EventUpdate
+	}
+	c[id] = item
+	m.lock.Unlock()
+	m.watchers.notify(event)
+	return nil
+}
+
+// Remove a value from the provided collection with a given ID.
+func (m *MemoryStore) Remove(collection, id string) error {
+	if err := validateNames(collection, id); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	item, ok := m.data[collection][id]
+	if ok {
+		delete(m.data[collection], id)
+	}
+	m.lock.Unlock()
+	if !ok || item.expired(time.Now()) {
+		return &NotFoundError{collection, id}
+	}
+	m.watchers.notify(StoreEvent{EventDelete, collection, id})
+	return nil
+}
+
+// RemoveAll removes all values from the provided collection.
+func (m *MemoryStore) RemoveAll(collection string) error {
+	ids, err := m.List(collection)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	delete(m.data, collection)
+	m.lock.Unlock()
+	for _, id := range ids {
+		m.watchers.notify(StoreEvent{EventDelete, collection, id})
+	}
+	return nil
+}
+
+// List the IDs of all unexpired items in a collection in sorted order.
+func (m *MemoryStore) List(collection string) ([]string, error) {
+	if err := validateNames(collection); err != nil {
+		return nil, err
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	now := time.Now()
+	ids := []string{}
+	for id, item := range m.data[collection] {
+		if !item.expired(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Each calls fn with every unexpired item in a collection in ID order.
+func (m *MemoryStore) Each(collection string, fn func(id, value string) error) error {
+	ids, err := m.List(collection)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		value, err := m.Read(collection, id)
+		if IsNotFound(err) {
+			continue // Removed or expired during iteration
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(id, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load all the values from a collection in ID order.
+func (m *MemoryStore) Load(collection string) ([]string, error) {
+	return loadValues(m, collection)
+}
+
+// LoadMap loads all the items from a collection keyed by ID.
+func (m *MemoryStore) LoadMap(collection string) (map[string]string, error) {
+	return loadMap(m, collection)
+}
+
+// Watch reports changes to a collection. Expired items are reported as
+// deleted when Expire removes them.
+func (m *MemoryStore) Watch(collection string) (*Watcher, error) {
+	if err := validateNames(collection); err != nil {
+		return nil, err
+	}
+	return m.watchers.watch(collection), nil
+}
+
+// Collections lists the names of all collections in sorted order.
+func (m *MemoryStore) Collections() ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	now := time.Now()
+	names := []string{}
+	for name, c := range m.data {
+		for _, item := range c {
+			if !item.expired(now) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Expire removes expired items returning how many were removed.
+func (m *MemoryStore) Expire() int {
+	now := time.Now()
+	events := []StoreEvent{}
+	m.lock.Lock()
+	for collection, c := range m.data {
+		for id, item := range c {
+			if item.expired(now) {
+				delete(c, id)
+				events = append(events, StoreEvent{EventDelete, collection, id})
+			}
+		}
+		if len(c) == 0 {
+			delete(m.data, collection)
+		}
+	}
+	m.lock.Unlock()
+	m.watchers.notify(events...)
+	return len(events)
+}
+
+// Save writes a snapshot of the store to its snapshot file. Stores created
+// without a snapshot file are not saved.
+func (m *MemoryStore) Save() error {
+	if len(m.path) == 0 {
+		return nil
+	}
+	m.lock.RLock()
+	text, err := json.Marshal(m.data)
+	m.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(m.path)
+	if err = os.MkdirAll(dir, storeDirMode); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(m.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(text)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.path)
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Close stops the background snapshot loop and saves a final snapshot.
+func (m *MemoryStore) Close() error {
+	m.lock.Lock()
+	if m.done != nil {
+		close(m.done)
+		m.done = nil
+	}
+	m.lock.Unlock()
+	err := m.Save()
+	if err != nil {
+		log.Println("store could not save snapshot", m.path, err)
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package lights_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+
+	Describe("Memory store", func() {
+		It("should expire items after their TTL", func() {
+			s := lights.NewMemoryStore()
+			Ω(s.WriteTTL("status", "lamp", "#F00", 20*time.Millisecond)).Should(Succeed())
+			Ω(s.Write("status", "hub", "online")).Should(Succeed())
+			Ω(s.Read("status", "lamp")).Should(Equal("#F00"))
+			w, err := s.Watch("status")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer w.Close()
+
+			time.Sleep(30 * time.Millisecond)
+			_, err = s.Read("status", "lamp")
+			Ω(lights.IsNotFound(err)).Should(BeTrue())
+			Ω(s.List("status")).Should(Equal([]string{"hub"}))
+			Ω(s.Expire()).Should(Equal(1))
+			Eventually(w.Events).Should(Receive(Equal(lights.StoreEvent{Type: lights.EventDelete, Collection: "status", ID: "lamp"})))
+		})
+
+		It("should snapshot to disk", func() {
+			dir, err := ioutil.TempDir("", "lights-memory")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "status.json")
+			s, err := lights.OpenMemoryStore(path, 0)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("status", "hub", "online")).Should(Succeed())
+			Ω(s.WriteTTL("status", "lamp", "#F00", time.Hour)).Should(Succeed())
+			Ω(s.Close()).Should(Succeed())
+
+			s, err = lights.OpenMemoryStore(path, 0)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer s.Close()
+			Ω(s.LoadMap("status")).Should(Equal(map[string]string{"hub": "online", "lamp": "#F00"}))
+		})
+	})
+})
@@ -15,8 +15,13 @@ type Command struct {
 }
 
 // NewCommand parses a command string to obtain it's command, message, and ID.
-// An error is returned if the command or message is not recognized.
+// Commands may start with a protocol version header (see SplitVersion). An
+// UnknownCodeError is returned if the action or type is not recognized.
 func NewCommand(cmd string) (*Command, error) {
+	version, cmd, err := SplitVersion(cmd)
+	if err != nil {
+		return nil, err
+	}
 	if len(cmd) < 2 {
 		// Command is too small
 		return nil, fmt.Errorf("Truncated command received: %s", cmd)
@@ -32,21 +37,11 @@ func NewCommand(cmd string) (*Command, error) {
 	case '?':
 		command.Action = "query"
 	default:
-		return nil, fmt.Errorf("Unknown action code '%s' in command: %s", string(cmd[0]), cmd)
+		return nil, &UnknownCodeError{"action", cmd[0], version, cmd}
 	}
-	switch cmd[1] {
-	case '#': // Color
-		command.Type = "color"
-	case ':': // Pattern
-		command.Type = "pattern"
-	case '~': // Schedule
-		command.Type = "schedule"
-	case '^': // Scene
-		command.Type = "scene"
-	case '-': // Property
-		command.Type = "property"
-	default:
-		return nil, fmt.Errorf("Unknown type code '%s' in command: %s", string(cmd[1]), cmd)
+	var ok bool
+	if command.Type, ok = lookupType(cmd[1]); !ok {
+		return nil, &UnknownCodeError{"type", cmd[1], version, cmd}
 	}
 	if len(cmd) > 2 {
 		command.Parts = strings.Split(cmd[2:], "|")
@@ -55,11 +50,7 @@ func NewCommand(cmd string) (*Command, error) {
 			command.ID = "#" + command.Parts[0]
 		case "pattern":
 			command.ID = strings.Split(command.Parts[0], ":")[0]
-		case "schedule":
-			command.ID = command.Parts[0]
-		case "scene":
-			command.ID = command.Parts[0]
-		case "property":
+		default: // Schedules, scenes, properties and extension types
 			command.ID = command.Parts[0]
 		}
 	} else {
@@ -68,3 +59,40 @@ func NewCommand(cmd string) (*Command, error) {
 
 	return command, nil
 }
+
+// Body returns the command parts joined back into the command payload
+// (everything after the action and type codes).
+func (c *Command) Body() string {
+	return strings.Join(c.Parts, "|")
+}
+
+// String encodes the command in the compact wire format.
+func (c *Command) String() string {
+	code, err := commandCode(c.Action, c.Type)
+	if err != nil {
+		return ""
+	}
+	return code + c.Body()
+}
+
+// commandCode returns the wire format action and type codes for a command.
+func commandCode(action, commandType string) (string, error) {
+	code := ""
+	switch action {
+	case "execute":
+		code = "!"
+	case "add":
+		code = "+"
+	case "remove":
+		code = "-"
+	case "query":
+		code = "?"
+	default:
+		return "", fmt.Errorf("Unknown command action '%s'", action)
+	}
+	typeCode, ok := lookupTypeCode(commandType)
+	if !ok {
+		return "", fmt.Errorf("Unknown command type '%s'", commandType)
+	}
+	return code + string(typeCode), nil
+}
@@ -2,17 +2,21 @@ package lights
 
 import (
 	"errors"
+	"fmt"
 	"image/color"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// Pattern captures all data needed for a light pattern.
+// Pattern captures all data needed for a light pattern. Patterns with an
+// effect ID (see EffectPrefix) render an Effect over their slots.
 type Pattern struct {
-	ID    string
-	Loops int
-	Slots []*Slot
+	ID     string
+	Loops  int
+	Slots  []*Slot
+	Effect *Effect // Nil unless the ID refers to a built-in effect
 }
 
 // NewPattern creates a pattern from a pattern specification string.
@@ -27,13 +31,16 @@ func NewPattern(pattern string) (*Pattern, error) {
 	// Determine the number of loops will be of the form `:ID[:loops]`
 	headers := strings.Split(parts[0], ":")
 	p.Loops = -1
+	if len(headers) > 3 {
+		return nil, errors.New("Pattern header must be :ID[:loops] - found " + parts[0])
+	}
 	switch len(headers) {
 	case 3:
 		loop := strings.TrimSpace(headers[2])
 		if len(loop) > 0 {
 			p.Loops, err = strconv.Atoi(loop)
 			if err != nil {
-				return nil, errors.New("Loop count was not an integer")
+				return nil, fmt.Errorf("Loop count %q was not an integer", loop)
 			}
 		}
 		fallthrough
@@ -41,28 +48,83 @@ func NewPattern(pattern string) (*Pattern, error) {
 		p.ID = strings.TrimSpace(headers[1])
 	}
 
-	if len(parts) == 1 {
+	slots := parts[1:]
+	if IsEffectID(p.ID) {
+		// Effect parameters come before any slots
+		params := ""
+		if len(slots) > 0 {
+			params, slots = slots[0], slots[1:]
+		}
+		if p.Effect, err = ParseEffect(EffectName(p.ID), params); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(slots) == 0 {
 		p.Slots = []*Slot{}
 		return p, nil // No slots - we don't need to do anything.
 	}
 
-	for _, slot := range parts[1:] {
+	for i, slot := range slots {
 		// Each slot is a color, fade, hold, transition separated by ","
 		s, err := NewSlot(slot)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Invalid slot %d: %s", i+1, err)
 		}
 		p.Slots = append(p.Slots, s)
 	}
 	return p, nil
 }
 
-// Slot captures the information about a single slot in a pattern.
+// Validate checks the pattern has an ID, a valid loop count and at least
+// one slot with a color (effect patterns need no slots).
+func (p *Pattern) Validate() error {
+	if len(p.ID) == 0 {
+		return errors.New("Missing ID in pattern: " + p.String())
+	}
+	if p.Loops < -1 {
+		return fmt.Errorf("Pattern loop count must not be negative - found %d", p.Loops)
+	}
+	if len(p.Slots) == 0 && p.Effect == nil {
+		return errors.New("Pattern has no slots: " + p.String())
+	}
+	for i, slot := range p.Slots {
+		if slot.Color == nil {
+			return fmt.Errorf("Pattern %s slot %d has no color", p.ID, i+1)
+		}
+		if slot.Fade < 0 || slot.Hold < 0 {
+			return fmt.Errorf("Pattern %s slot %d has a negative duration", p.ID, i+1)
+		}
+	}
+	return nil
+}
+
+// String returns the canonical pattern specification for the pattern.
+func (p *Pattern) String() string {
+	header := ":" + p.ID
+	if p.Loops >= 0 {
+		header += ":" + strconv.Itoa(p.Loops)
+	}
+	parts := []string{header}
+	if p.Effect != nil {
+		parts = append(parts, p.Effect.String())
+	}
+	for _, slot := range p.Slots {
+		parts = append(parts, slot.String())
+	}
+	return strings.Join(parts, "|")
+}
+
+// Slot captures the information about a single slot in a pattern. Slots
+// for addressable strips may color segments of the strip separately (Color
+// is then the first segment color) and move with a Motion effect.
 type Slot struct {
 	Color      color.Color
 	Fade       time.Duration
 	Hold       time.Duration
 	Transition string
+	Segments   []*Segment // Nil if the whole strip shows Color
+	Motion     *Motion    // Nil if the slot does not move
 }
 
 // NewSlot creates a slot from a slot specification.
@@ -73,6 +135,16 @@ func NewSlot(slot string) (s *Slot, err error) {
 	case 0:
 		// Empty slot found - ignore
 		return nil, errors.New("No slot information found")
+	case 5:
+		// color, fade, hold, transition and motion
+		value := strings.TrimSpace(items[4])
+		if len(value) > 0 {
+			s.Motion, err = ParseMotion(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		fallthrough
 	case 4:
 		// color, fade, hold, and transition
 		value := strings.TrimSpace(items[3])
@@ -83,29 +155,83 @@ func NewSlot(slot string) (s *Slot, err error) {
 	case 3:
 		value := strings.TrimSpace(items[2])
 		if len(value) > 0 {
-			s.Hold, err = time.ParseDuration(value)
+			s.Hold, err = ParseDuration(value)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("Invalid hold %q: %s", value, err)
 			}
 		}
 		fallthrough
 	case 2:
 		value := strings.TrimSpace(items[1])
 		if len(value) > 0 {
-			s.Fade, err = time.ParseDuration(value)
+			s.Fade, err = ParseDuration(value)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("Invalid fade %q: %s", value, err)
 			}
 		}
 		fallthrough
 	case 1:
-		value := strings.TrimSpace(items[0])
-		if len(value) > 0 {
-			s.Color, err = ParseColorCode(value)
-			if err != nil {
-				return nil, err
-			}
+		if err = s.setColorSpec(strings.TrimSpace(items[0])); err != nil {
+			return nil, err
 		}
+	default:
+		return nil, fmt.Errorf("Slot has %d fields - expected at most 5: %s", len(items), slot)
 	}
 	return
 }
+
+// String returns the canonical slot specification for the slot.
+func (s *Slot) String() string {
+	fields := []string{s.ColorSpec(), s.Fade.String(), s.Hold.String(), s.Transition}
+	if s.Motion != nil {
+		fields = append(fields, s.Motion.String())
+	}
+	return strings.Join(fields, ",")
+}
+
+// ColorSpec returns the color field of the slot specification.
+func (s *Slot) ColorSpec() string {
+	switch {
+	case len(s.Segments) > 0:
+		return FormatSegments(s.Segments)
+	case s.Color != nil:
+		return FormatColorCode(s.Color)
+	default:
+		return ""
+	}
+}
+
+// setColorSpec sets the slot color (and segments) from a color field.
+func (s *Slot) setColorSpec(value string) (err error) {
+	switch {
+	case strings.ContainsAny(value, "/>@"):
+		if s.Segments, err = ParseSegments(value); err != nil {
+			return err
+		}
+		s.Color = s.Segments[0].Colors[0]
+	case len(value) > 0:
+		s.Color, err = ParseColorCode(value)
+	}
+	return err
+}
+
+// ParseDuration parses a slot duration. Durations may use any unit
+// understood by time.ParseDuration or be a bare number of seconds (as used
+// in compact commands like `!:ab|#F00,2,1`). Durations that do not fit
+// in a time.Duration are rejected.
+func ParseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return secondsDuration(seconds, value)
+	}
+	return time.ParseDuration(value)
+}
+
+// secondsDuration converts a number of seconds (written as value) to a
+// duration, rejecting values that do not fit.
+func secondsDuration(seconds float64, value string) (time.Duration, error) {
+	ns := seconds * float64(time.Second)
+	if math.IsNaN(ns) || ns >= math.MaxInt64 || ns < math.MinInt64 {
+		return 0, errors.New("Duration out of range: " + value)
+	}
+	return time.Duration(ns), nil
+}
@@ -2,6 +2,7 @@ package lights_test
 
 import (
 	"image/color"
+	"math"
 	"time"
 
 	"github.com/inceptionllc/go-lights"
@@ -39,5 +40,22 @@ var _ = Describe("Core", func() {
 			Ω(pattern.Loops).Should(Equal(-1))
 			Ω(pattern.Slots).Should(HaveLen(3))
 		})
+		It("should format canonical pattern specs", func() {
+			pattern, err := lights.NewPattern(":1:3|#f00,1,2|#0F0,1.5s,|#00f")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(pattern.String()).Should(Equal(":1:3|#FF0000,1s,2s,ease|#00FF00,1.5s,0s,ease|#0000FF,0s,0s,ease"))
+			again, err := lights.NewPattern(pattern.String())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(again).Should(Equal(pattern))
+		})
+		It("should reject durations out of range", func() {
+			for _, spec := range []string{":ab|#F00,1e300,1", ":ab|#F00,NaN", ":ab|#F00,1,Inf", ":ab|#F00,-1e10"} {
+				_, err := lights.NewPattern(spec)
+				Ω(err).Should(MatchError(ContainSubstring("out of range")), spec)
+			}
+			pattern, err := lights.NewPattern(":ab|#F00,2562047h,2562047h|#00F,2562047h")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(pattern.Cycle()).Should(Equal(time.Duration(math.MaxInt64)))
+		})
 	})
 })
@@ -0,0 +1,163 @@
+package lights
+
+import (
+	"image/color"
+	"math"
+	"time"
+)
+
+// Transitions lists the slot transitions understood by pattern playback.
+var Transitions = []string{"ease", "linear", "ease-in", "ease-out", "step"}
+
+// Ease maps the linear progress t (0 to 1) of a fade to the eased progress
+// for a transition. Unknown transitions use "ease".
+func Ease(transition string, t float64) float64 {
+	switch {
+	case t <= 0:
+		return 0
+	case t >= 1:
+		return 1
+	}
+	switch transition {
+	case "linear":
+		return t
+	case "ease-in":
+		return t * t
+	case "ease-out":
+		return 1 - (1-t)*(1-t)
+	case "step":
+		return 1
+	default: // "ease"
+		return t * t * (3 - 2*t)
+	}
+}
+
+// Cycle returns how long one play through the pattern's slots takes. The
+// cycle of an effect pattern without slots is the effect period. Cycles too
+// long for a time.Duration are capped.
+func (p *Pattern) Cycle() time.Duration {
+	if len(p.Slots) == 0 && p.Effect != nil {
+		return p.Effect.Period
+	}
+	var cycle time.Duration
+	for _, s := range p.Slots {
+		cycle = addDurations(cycle, addDurations(s.Fade, s.Hold))
+	}
+	return cycle
+}
+
+// addDurations adds two durations, capping the sum if it is too long for a
+// time.Duration.
+func addDurations(a, b time.Duration) time.Duration {
+	switch {
+	case b > 0 && a > math.MaxInt64-b:
+		return math.MaxInt64
+	case b < 0 && a < math.MinInt64-b:
+		return math.MinInt64
+	}
+	return a + b
+}
+
+// Duration returns how long the pattern plays. Patterns with Loops of -1
+// repeat forever and return a negative duration. Otherwise the slots play
+// Loops times (zero plays once).
+func (p *Pattern) Duration() time.Duration {
+	if p.Loops < 0 {
+		return -1
+	}
+	plays := p.Loops
+	if plays == 0 {
+		plays = 1
+	}
+	cycle := p.Cycle()
+	if cycle > 0 && time.Duration(plays) > math.MaxInt64/cycle {
+		return math.MaxInt64
+	}
+	return time.Duration(plays) * cycle
+}
+
+// ColorAt returns the pattern's color after it has played for elapsed and
+// whether the pattern has finished. Each slot fades from the previous
+// slot's color (the last slot's color for the first slot) and then holds.
+// Slots without a color keep the previous color. Effect patterns return
+// the color of a single pixel strip.
+func (p *Pattern) ColorAt(elapsed time.Duration) (color.Color, bool) {
+	if p.Effect != nil {
+		frame, finished := p.effectFrame(elapsed, 1)
+		return frame[0], finished
+	}
+	colors := p.slotColors()
+	if len(colors) == 0 {
+		return color.RGBA{}, true
+	}
+	pos := p.locate(elapsed)
+	if pos.finished || pos.cycle <= 0 {
+		return colors[len(colors)-1], pos.finished
+	}
+	if !pos.fading {
+		return colors[pos.slot], false
+	}
+	previous := colors[(pos.slot+len(colors)-1)%len(colors)]
+	return MixColors(previous, colors[pos.slot], Ease(p.Slots[pos.slot].Transition, pos.progress)), false
+}
+
+// playPosition is where playback is within a pattern.
+type playPosition struct {
+	cycle    time.Duration
+	finished bool
+	slot     int           // The slot playing
+	fading   bool          // True while fading into the slot
+	progress float64       // Linear progress through the fade
+	since    time.Duration // Time since the slot started
+}
+
+// locate finds the playback position after the pattern has played for
+// elapsed.
+func (p *Pattern) locate(elapsed time.Duration) playPosition {
+	pos := playPosition{cycle: p.Cycle()}
+	if duration := p.Duration(); duration >= 0 && elapsed >= duration {
+		pos.finished = true
+		return pos
+	}
+	if pos.cycle <= 0 {
+		return pos
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	offset := elapsed % pos.cycle
+	for i, s := range p.Slots {
+		length := addDurations(s.Fade, s.Hold)
+		if offset < length || i == len(p.Slots)-1 {
+			pos.slot = i
+			pos.since = offset
+			if offset < s.Fade {
+				pos.fading = true
+				pos.progress = float64(offset) / float64(s.Fade)
+			}
+			return pos
+		}
+		offset -= length
+	}
+	return pos
+}
+
+// slotColors returns the color shown by each slot, carrying colors forward
+// (cyclically) into slots without one.
+func (p *Pattern) slotColors() []color.Color {
+	colors := make([]color.Color, len(p.Slots))
+	var last color.Color = color.RGBA{}
+	for i := len(p.Slots) - 1; i >= 0; i-- {
+		if p.Slots[i].Color != nil {
+			last = p.Slots[i].Color
+			break
+		}
+	}
+	for i, s := range p.Slots {
+		if s.Color != nil {
+			last = s.Color
+		}
+		colors[i] = last
+	}
+	return colors
+}
@@ -0,0 +1,74 @@
+package lights_test
+
+import (
+	"image/color"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Playback", func() {
+		It("should ease transitions", func() {
+			for _, transition := range lights.Transitions {
+				Ω(lights.Ease(transition, 0)).Should(BeNumerically("==", 0), transition)
+				Ω(lights.Ease(transition, 1)).Should(BeNumerically("==", 1), transition)
+			}
+			Ω(lights.Ease("linear", 0.25)).Should(BeNumerically("~", 0.25))
+			Ω(lights.Ease("ease", 0.5)).Should(BeNumerically("~", 0.5))
+			Ω(lights.Ease("ease", 0.25)).Should(BeNumerically("<", 0.25))
+			Ω(lights.Ease("ease-in", 0.5)).Should(BeNumerically("~", 0.25))
+			Ω(lights.Ease("ease-out", 0.5)).Should(BeNumerically("~", 0.75))
+			Ω(lights.Ease("step", 0.01)).Should(BeNumerically("==", 1))
+		})
+
+		It("should mix colors", func() {
+			red := color.RGBA{0xff, 0, 0, 0}
+			blue := color.RGBA{0, 0, 0xff, 0}
+			Ω(lights.MixColors(red, blue, 0)).Should(Equal(red))
+			Ω(lights.MixColors(red, blue, 1)).Should(Equal(blue))
+			Ω(lights.MixColors(red, blue, 0.5)).Should(Equal(color.RGBA{0x80, 0, 0x80, 0}))
+			Ω(lights.MixColors(nil, red, 2)).Should(Equal(red))
+		})
+
+		It("should play pattern slots", func() {
+			p, err := lights.NewPattern(":ab:2|#F00,1,1,linear|#00F,1,1,linear")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.Cycle()).Should(Equal(4 * time.Second))
+			Ω(p.Duration()).Should(Equal(8 * time.Second))
+			at := func(d time.Duration) color.Color {
+				c, _ := p.ColorAt(d)
+				return c
+			}
+			Ω(at(0)).Should(Equal(color.RGBA{0, 0, 0xff, 0}))
+			Ω(at(500 * time.Millisecond)).Should(Equal(color.RGBA{0x80, 0, 0x80, 0}))
+			Ω(at(1500 * time.Millisecond)).Should(Equal(color.RGBA{0xff, 0, 0, 0}))
+			Ω(at(2500 * time.Millisecond)).Should(Equal(color.RGBA{0x80, 0, 0x80, 0}))
+			Ω(at(3500 * time.Millisecond)).Should(Equal(color.RGBA{0, 0, 0xff, 0}))
+			Ω(at(5500 * time.Millisecond)).Should(Equal(color.RGBA{0xff, 0, 0, 0}))
+			c, done := p.ColorAt(9 * time.Second)
+			Ω(done).Should(BeTrue())
+			Ω(c).Should(Equal(color.RGBA{0, 0, 0xff, 0}))
+		})
+
+		It("should carry colors into slots without one", func() {
+			p, err := lights.NewPattern(":ab|#F00,0,1|,0,1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.Duration()).Should(BeNumerically("<", 0))
+			c, done := p.ColorAt(time.Hour + 1500*time.Millisecond)
+			Ω(done).Should(BeFalse())
+			Ω(c).Should(Equal(color.RGBA{0xff, 0, 0, 0}))
+		})
+
+		It("should play slots too long for a time.Duration", func() {
+			p, err := lights.NewPattern(":ab|#F00,2562047h,2562047h|#00F,0,1|#0F0,0,1")
+			Ω(err).ShouldNot(HaveOccurred())
+			c, done := p.ColorAt(2562000 * time.Hour)
+			Ω(done).Should(BeFalse())
+			Ω(c).Should(Equal(color.RGBA{0xff, 0, 0, 0}))
+		})
+	})
+})
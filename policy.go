@@ -0,0 +1,217 @@
+package lights
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PolicyCollection is the Store collection holding gatekeeper policies.
+const PolicyCollection = "policies"
+
+// Anonymous is the principal used for messages that do not carry a signed
+// envelope.
+const Anonymous = "anonymous"
+
+// Policy allows or denies a principal (a certificate subject or API token
+// ID) permission to perform actions on command types for a set of devices.
+// Policies are stored as `effect|principal|actions|types|devices` where each
+// list is comma separated and `*` matches anything. For example:
+//
+//	allow|gateway|execute,query|color,pattern|*
+//	deny|*|remove|*|0123456789ab
+type Policy struct {
+	Allow     bool
+	Principal string
+	Actions   []string
+	Types     []string
+	Devices   []string
+}
+
+// NewPolicy parses a policy specification string.
+func NewPolicy(spec string) (*Policy, error) {
+	parts := strings.Split(spec, "|")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("Policy must have 5 parts - found %d: %s", len(parts), spec)
+	}
+	p := &Policy{Principal: strings.TrimSpace(parts[1])}
+	switch strings.TrimSpace(parts[0]) {
+	case "allow":
+		p.Allow = true
+	case "deny":
+		p.Allow = false
+	default:
+		return nil, fmt.Errorf("Unknown policy effect '%s' in policy: %s", parts[0], spec)
+	}
+	if len(p.Principal) == 0 {
+		return nil, fmt.Errorf("Missing principal in policy: %s", spec)
+	}
+	p.Actions = splitList(parts[2])
+	for _, action := range p.Actions {
+		switch action {
+		case "*", "execute", "add", "remove", "query":
+		default:
+			return nil, fmt.Errorf("Unknown action '%s' in policy: %s", action, spec)
+		}
+	}
+	p.Types = splitList(parts[3])
+	for _, t := range p.Types {
+		if _, known := lookupTypeCode(t); !known && t != "*" {
+			return nil, fmt.Errorf("Unknown type '%s' in policy: %s", t, spec)
+		}
+	}
+	p.Devices = splitList(parts[4])
+	return p, nil
+}
+
+// String encodes the policy in its specification format.
+func (p *Policy) String() string {
+	effect := "deny"
+	if p.Allow {
+		effect = "allow"
+	}
+	return strings.Join([]string{
+		effect,
+		p.Principal,
+		strings.Join(p.Actions, ","),
+		strings.Join(p.Types, ","),
+		strings.Join(p.Devices, ","),
+	}, "|")
+}
+
+// Applies returns true if the policy covers the principal performing the
+// command on the device.
+func (p *Policy) Applies(principal string, cmd *Command, device *DeviceID) bool {
+	if p.Principal != "*" && p.Principal != principal {
+		return false
+	}
+	if !matchList(p.Actions, cmd.Action) || !matchList(p.Types, cmd.Type) {
+		return false
+	}
+	for _, d := range p.Devices {
+		if d == "*" || (device != nil && device.Matches(d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Gatekeeper decides whether principals may run commands using policies
+// kept in a Store. Deny policies win over allow policies and anything not
+// explicitly allowed is denied.
+type Gatekeeper struct {
+	Store  Store
+	Device *DeviceID      // The device commands are executed on
+	Guard  *EnvelopeGuard // Verifies envelope senders (envelopes are rejected if nil)
+}
+
+// NewGatekeeper creates a gatekeeper for the device using policies in the
+// store and verifying envelopes with the guard.
+func NewGatekeeper(store Store, device *DeviceID, guard *EnvelopeGuard) *Gatekeeper {
+	return &Gatekeeper{Store: store, Device: device, Guard: guard}
+}
+
+// AddPolicy saves a policy to the store under the given ID.
+func (g *Gatekeeper) AddPolicy(id string, policy *Policy) error {
+	return g.Store.Write(PolicyCollection, id, policy.String())
+}
+
+// RemovePolicy removes a policy from the store.
+func (g *Gatekeeper) RemovePolicy(id string) error {
+	return g.Store.Remove(PolicyCollection, id)
+}
+
+// Authorize returns an error if the principal may not perform the command.
+func (g *Gatekeeper) Authorize(principal string, cmd *Command) error {
+	specs, err := g.Store.Load(PolicyCollection)
+	if err != nil {
+		return err
+	}
+	allowed := false
+	for _, spec := range specs {
+		policy, err := NewPolicy(spec)
+		if err != nil {
+			return err
+		}
+		if !policy.Applies(principal, cmd, g.Device) {
+			continue
+		}
+		if !policy.Allow {
+			allowed = false
+			break
+		}
+		allowed = true
+	}
+	if !allowed {
+		return fmt.Errorf("Principal %s may not %s %s %s", principal, cmd.Action, cmd.Type, cmd.ID)
+	}
+	return nil
+}
+
+// Wrap returns a WorkerFunc that only passes authorized commands on to the
+// handler. Signed envelopes are opened with the guard and their commands
+// authorized for the principal the signature proves, while unsigned
+// messages (and envelopes signed with a shared Default key) are authorized
+// for Anonymous. Every command in a batch message must be
+// authorized. The handler receives the message without its envelope, so
+// the gatekeeper replaces the guard's own middleware:
+//
+//	worker.Use(gatekeeper.Wrap)
+func (g *Gatekeeper) Wrap(handler WorkerFunc) WorkerFunc {
+	return func(message string) error {
+		principal := Anonymous
+		if strings.HasPrefix(message, EnvelopePrefix) {
+			if g.Guard == nil {
+				return errors.New("Gatekeeper has no guard to verify envelopes")
+			}
+			e, err := g.Guard.Open(message)
+			if err != nil {
+				return err
+			}
+			if len(e.Principal) > 0 {
+				principal = e.Principal
+			}
+			message = e.Command
+		}
+		var commands []*Command
+		var err error
+		if isBatch(message) {
+			commands, err = ParseBatch(message)
+		} else {
+			var cmd *Command
+			cmd, err = NewCommand(message)
+			commands = []*Command{cmd}
+		}
+		if err != nil {
+			return err
+		}
+		for _, cmd := range commands {
+			if err = g.Authorize(principal, cmd); err != nil {
+				return err
+			}
+		}
+		return handler(message)
+	}
+}
+
+// splitList splits a comma separated list trimming each item.
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// matchList returns true if the list contains the value or a `*` wildcard.
+func matchList(list []string, value string) bool {
+	for _, item := range list {
+		if item == "*" || item == value {
+			return true
+		}
+	}
+	return false
+}
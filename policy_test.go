@@ -0,0 +1,168 @@
+package lights_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+
+	Describe("Policy", func() {
+		It("should parse policy specs", func() {
+			p, err := lights.NewPolicy("allow|gateway|execute,query|color,pattern|*")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.Allow).Should(BeTrue())
+			Ω(p.Principal).Should(Equal("gateway"))
+			Ω(p.Actions).Should(Equal([]string{"execute", "query"}))
+			Ω(p.Types).Should(Equal([]string{"color", "pattern"}))
+			Ω(p.Devices).Should(Equal([]string{"*"}))
+			Ω(p.String()).Should(Equal("allow|gateway|execute,query|color,pattern|*"))
+			_, err = lights.NewPolicy("allow|gateway|launch|color|*")
+			Ω(err).Should(HaveOccurred())
+			_, err = lights.NewPolicy("allow|gateway|execute|lava|*")
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should accept registered command types", func() {
+			Ω(lights.RegisterType('$', "sample")).Should(Succeed())
+			defer lights.UnregisterType("sample")
+			Ω(lights.CommandTypes()).Should(ContainElement("sample"))
+			for _, t := range append(lights.CommandTypes(), "*") {
+				_, err := lights.NewPolicy("allow|gateway|execute|" + t + "|*")
+				Ω(err).ShouldNot(HaveOccurred(), t)
+			}
+		})
+
+		It("should authorize commands with deny taking priority", func() {
+			store := &lights.MockStore{}
+			g := lights.NewGatekeeper(store, &lights.DeviceID{ID: "0123456789ab"}, nil)
+			p, _ := lights.NewPolicy("allow|gateway|*|*|0123")
+			Ω(g.AddPolicy("1", p)).Should(Succeed())
+			p, _ = lights.NewPolicy("deny|*|remove|schedule|*")
+			Ω(g.AddPolicy("2", p)).Should(Succeed())
+
+			cmd, _ := lights.NewCommand("!#F00")
+			Ω(g.Authorize("gateway", cmd)).Should(Succeed())
+			Ω(g.Authorize(lights.Anonymous, cmd)).ShouldNot(Succeed())
+			cmd, _ = lights.NewCommand("-~4")
+			Ω(g.Authorize("gateway", cmd)).ShouldNot(Succeed())
+
+			other := lights.NewGatekeeper(store, &lights.DeviceID{ID: "fedcba987654"}, nil)
+			cmd, _ = lights.NewCommand("!#F00")
+			Ω(other.Authorize("gateway", cmd)).ShouldNot(Succeed())
+		})
+
+		It("should enforce policies before handlers run", func() {
+			store := &lights.MockStore{}
+			signer := &lights.HMACSigner{Key: []byte("secret")}
+			guard := lights.NewEnvelopeGuard(&lights.HMACVerifier{Keys: map[string][]byte{"gateway": []byte("secret")}}, 0)
+			g := lights.NewGatekeeper(store, &lights.DeviceID{ID: "0123456789ab"}, guard)
+			p, _ := lights.NewPolicy("allow|gateway|execute|color|*")
+			Ω(g.AddPolicy("1", p)).Should(Succeed())
+			received := []string{}
+			handler := g.Wrap(func(message string) error {
+				received = append(received, message)
+				return nil
+			})
+			msg, err := lights.Seal("gateway", "!#F00", signer)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(handler(msg)).Should(Succeed())
+			Ω(handler(msg)).ShouldNot(Succeed())
+			msg, err = lights.Seal("gateway", "+:ab|#F00", signer)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(handler(msg)).ShouldNot(Succeed())
+			Ω(handler("!#F00")).ShouldNot(Succeed())
+			Ω(received).Should(Equal([]string{"!#F00"}))
+		})
+
+		It("should reject envelopes from unverified senders", func() {
+			store := &lights.MockStore{}
+			signer := &lights.HMACSigner{Key: []byte("secret")}
+			verifier := &lights.HMACVerifier{Keys: map[string][]byte{"admin": []byte("secret")}}
+			g := lights.NewGatekeeper(store, &lights.DeviceID{ID: "0123456789ab"}, lights.NewEnvelopeGuard(verifier, 0))
+			p, _ := lights.NewPolicy("allow|admin|*|*|*")
+			Ω(g.AddPolicy("1", p)).Should(Succeed())
+			calls := 0
+			handler := g.Wrap(func(message string) error {
+				calls++
+				return nil
+			})
+			forged := &lights.Envelope{Sender: "admin", Timestamp: time.Now(), Nonce: "abc", Command: "-:ab"}
+			Ω(handler(forged.String())).ShouldNot(Succeed())
+			Ω(forged.Sign(&lights.HMACSigner{Key: []byte("guess")})).Should(Succeed())
+			Ω(handler(forged.String())).ShouldNot(Succeed())
+
+			unguarded := lights.NewGatekeeper(store, &lights.DeviceID{ID: "0123456789ab"}, nil).Wrap(handler)
+			msg, err := lights.Seal("admin", "-:ab", signer)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(unguarded(msg)).Should(MatchError(ContainSubstring("no guard")))
+			Ω(calls).Should(BeZero())
+			Ω(handler(msg)).Should(Succeed())
+			Ω(calls).Should(Equal(1))
+		})
+
+		It("should not trust senders of Default key envelopes", func() {
+			store := &lights.MockStore{}
+			verifier := &lights.HMACVerifier{
+				Keys:    map[string][]byte{"gateway": []byte("secret")},
+				Default: []byte("shared"),
+			}
+			g := lights.NewGatekeeper(store, &lights.DeviceID{ID: "0123456789ab"}, lights.NewEnvelopeGuard(verifier, 0))
+			p, _ := lights.NewPolicy("allow|admin|*|*|*")
+			Ω(g.AddPolicy("1", p)).Should(Succeed())
+			p, _ = lights.NewPolicy("allow|" + lights.Anonymous + "|query|*|*")
+			Ω(g.AddPolicy("2", p)).Should(Succeed())
+			calls := 0
+			handler := g.Wrap(func(message string) error {
+				calls++
+				return nil
+			})
+			shared := &lights.HMACSigner{Key: []byte("shared")}
+			msg, err := lights.Seal("admin", "-:ab", shared)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(handler(msg)).ShouldNot(Succeed())
+			msg, err = lights.Seal("admin", "?:ab", shared)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(handler(msg)).Should(Succeed())
+			Ω(calls).Should(Equal(1))
+		})
+
+		It("should authorize every command in a batch", func() {
+			store := &lights.MockStore{}
+			signer := &lights.HMACSigner{Key: []byte("secret")}
+			guard := lights.NewEnvelopeGuard(&lights.HMACVerifier{Keys: map[string][]byte{"gateway": []byte("secret")}}, 0)
+			g := lights.NewGatekeeper(store, &lights.DeviceID{ID: "0123456789ab"}, guard)
+			p, _ := lights.NewPolicy("allow|gateway|*|color|*")
+			Ω(g.AddPolicy("1", p)).Should(Succeed())
+			w, err := lights.NewWorker("gateway")
+			Ω(err).ShouldNot(HaveOccurred())
+			w.Use(g.Wrap)
+			received := []string{}
+			Ω(w.Handler("/test/policy/batch", func(message string) error {
+				received = append(received, message)
+				return nil
+			})).Should(Succeed())
+			post := func(command string) int {
+				msg, err := lights.Seal("gateway", command, signer)
+				Ω(err).ShouldNot(HaveOccurred())
+				resp := httptest.NewRecorder()
+				http.DefaultServeMux.ServeHTTP(resp, httptest.NewRequest("POST", "/test/policy/batch", strings.NewReader(msg)))
+				return resp.Code
+			}
+			Ω(post("&\n!#F00\n+:ab|#F00")).ShouldNot(Equal(http.StatusOK))
+			Ω(post("&\n+:ab|#F00\n!#F00")).ShouldNot(Equal(http.StatusOK))
+			Ω(post("@2;&\n!#F00\n+:ab|#F00")).ShouldNot(Equal(http.StatusOK))
+			Ω(received).Should(BeEmpty())
+			Ω(post("&\n!#F00\n!#00F")).Should(Equal(http.StatusOK))
+			Ω(post("@2;&\n!#F00\n!#00F")).Should(Equal(http.StatusOK))
+			Ω(received).Should(Equal([]string{"&\n!#F00\n!#00F", "@2;&\n!#F00\n!#00F"}))
+		})
+	})
+})
@@ -0,0 +1,231 @@
+package lights
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the agent software version reported by the `version`
+// property. Agents set it at build time with:
+//
+//	go build -ldflags "-X github.com/inceptionllc/go-lights.Version=1.2.0"
+var Version = "dev"
+
+// Property value types.
+const (
+	PropertyString   = "string"
+	PropertyInt      = "int"
+	PropertyFloat    = "float"
+	PropertyBool     = "bool"
+	PropertyDuration = "duration"
+)
+
+// Property is a named, typed value an agent exposes for `?-name` queries
+// and, if Set is provided, `!-name|value` changes. Get returns a value of
+// the Go type matching Type: string, int, float64, bool or time.Duration.
+type Property struct {
+	Name string
+	Type string
+	Get  func() interface{}
+	Set  func(value interface{}) error // Nil for read-only properties
+}
+
+// PropertyValue is a property value as encoded in query responses. Responses
+// have the form `name|type|value`, for example `brightness|int|80`.
+type PropertyValue struct {
+	Name  string
+	Type  string
+	Value interface{}
+}
+
+// String encodes the property value as a response.
+func (v *PropertyValue) String() string {
+	return strings.Join([]string{v.Name, v.Type, formatProperty(v.Value)}, "|")
+}
+
+// ParsePropertyValue decodes a property query response.
+func ParsePropertyValue(response string) (*PropertyValue, error) {
+	parts := strings.SplitN(response, "|", 3)
+	if len(parts) != 3 {
+		return nil, errors.New("Invalid property response: " + response)
+	}
+	value, err := parseProperty(parts[1], parts[2])
+	if err != nil {
+		return nil, err
+	}
+	return &PropertyValue{parts[0], parts[1], value}, nil
+}
+
+// Properties is a registry of the properties an agent exposes. It answers
+// `?-name` queries and applies `!-name|value` changes when installed on a
+// Dispatcher. Properties is safe for concurrent use.
+type Properties struct {
+	lock       sync.RWMutex
+	properties map[string]*Property
+}
+
+// NewProperties creates a registry with the read-only `version`, `id` and
+// `uptime` properties.
+func NewProperties() *Properties {
+	p := &Properties{properties: map[string]*Property{}}
+	p.Register(&Property{Name: "version", Type: PropertyString, Get: func() interface{} {
+		return Version
+	}})
+	p.Register(&Property{Name: "id", Type: PropertyString, Get: func() interface{} {
+		id, err := NewID()
+		if err != nil {
+			return ""
+		}
+		return id.ID
+	}})
+	p.Register(&Property{Name: "uptime", Type: PropertyDuration, Get: func() interface{} {
+		return time.Since(started).Truncate(time.Second)
+	}})
+	return p
+}
+
+// Register adds a property replacing any existing property with the name.
+func (p *Properties) Register(property *Property) error {
+	if len(property.Name) == 0 || strings.Contains(property.Name, "|") {
+		return fmt.Errorf("Invalid property name '%s'", property.Name)
+	}
+	switch property.Type {
+	case PropertyString, PropertyInt, PropertyFloat, PropertyBool, PropertyDuration:
+	default:
+		return fmt.Errorf("Unknown type '%s' for property %s", property.Type, property.Name)
+	}
+	if property.Get == nil {
+		return fmt.Errorf("Property %s has no getter", property.Name)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.properties == nil {
+		p.properties = map[string]*Property{}
+	}
+	p.properties[property.Name] = property
+	return nil
+}
+
+// Names lists the registered property names in sorted order.
+func (p *Properties) Names() []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	names := []string{}
+	for name := range p.properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the current value of a property.
+func (p *Properties) Get(name string) (*PropertyValue, error) {
+	property, err := p.lookup("query", name)
+	if err != nil {
+		return nil, err
+	}
+	return &PropertyValue{property.Name, property.Type, property.Get()}, nil
+}
+
+// Set parses a value according to the property type and sets it, returning
+// the property's new value.
+func (p *Properties) Set(name, value string) (*PropertyValue, error) {
+	property, err := p.lookup("execute", name)
+	if err != nil {
+		return nil, err
+	}
+	if property.Set == nil {
+		return nil, fmt.Errorf("Property %s is read-only", name)
+	}
+	parsed, err := parseProperty(property.Type, value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid value for property %s: %s", name, err)
+	}
+	if err = property.Set(parsed); err != nil {
+		return nil, err
+	}
+	return p.Get(name)
+}
+
+// Install registers the registry as the dispatcher's `?-` and `!-` handlers.
+// Read-only `commands` and `capabilities` properties describe the
+// dispatcher's handlers.
+func (p *Properties) Install(d *Dispatcher) {
+	p.Register(&Property{Name: "commands", Type: PropertyString, Get: func() interface{} {
+		return strings.Join(d.Commands(), ",")
+	}})
+	p.Register(&Property{Name: "capabilities", Type: PropertyString, Get: func() interface{} {
+		return d.Capabilities().String()
+	}})
+	d.OnQuery("property", p.Query)
+	d.OnExecute("property", p.Execute)
+}
+
+// Query answers a `?-name` command with the encoded property value.
+func (p *Properties) Query(cmd *Command) (string, error) {
+	value, err := p.Get(cmd.ID)
+	if err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
+
+// Execute applies a `!-name|value` command answering with the encoded new
+// property value.
+func (p *Properties) Execute(cmd *Command) (string, error) {
+	if len(cmd.Parts) < 2 {
+		return "", fmt.Errorf("Missing value for property %s", cmd.ID)
+	}
+	value, err := p.Set(cmd.ID, strings.Join(cmd.Parts[1:], "|"))
+	if err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
+
+// lookup finds a registered property for an action.
+func (p *Properties) lookup(action, name string) (*Property, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	property, ok := p.properties[name]
+	if !ok {
+		return nil, &UnsupportedError{action, "property", name}
+	}
+	return property, nil
+}
+
+// parseProperty parses a property value of a type.
+func parseProperty(propertyType, value string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	switch propertyType {
+	case PropertyString:
+		return value, nil
+	case PropertyInt:
+		return strconv.Atoi(value)
+	case PropertyFloat:
+		return strconv.ParseFloat(value, 64)
+	case PropertyBool:
+		return strconv.ParseBool(value)
+	case PropertyDuration:
+		return ParseDuration(value)
+	default:
+		return nil, fmt.Errorf("Unknown property type '%s'", propertyType)
+	}
+}
+
+// formatProperty formats a property value.
+func formatProperty(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Duration:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
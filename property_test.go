@@ -0,0 +1,93 @@
+package lights_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Properties", func() {
+		var props *lights.Properties
+		var d *lights.Dispatcher
+		var brightness int
+
+		BeforeEach(func() {
+			brightness = 80
+			props = lights.NewProperties()
+			Ω(props.Register(&lights.Property{
+				Name: "brightness",
+				Type: lights.PropertyInt,
+				Get:  func() interface{} { return brightness },
+				Set: func(value interface{}) error {
+					level := value.(int)
+					if level < 0 || level > 100 {
+						return errors.New("Brightness must be 0-100")
+					}
+					brightness = level
+					return nil
+				},
+			})).Should(Succeed())
+			d = lights.NewDispatcher()
+			props.Install(d)
+		})
+
+		It("should answer property queries", func() {
+			Ω(d.Dispatch("?-brightness")).Should(Equal("brightness|int|80"))
+			Ω(d.Dispatch("?-version")).Should(Equal("version|string|" + lights.Version))
+			response, err := d.Dispatch("?-uptime")
+			Ω(err).ShouldNot(HaveOccurred())
+			value, err := lights.ParsePropertyValue(response)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(value.Type).Should(Equal(lights.PropertyDuration))
+			Ω(value.Value).Should(BeAssignableToTypeOf(time.Second))
+			Ω(d.Dispatch("?-commands")).Should(Equal("commands|string|execute property,query property"))
+			_, err = d.Dispatch("?-missing")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.UnsupportedError{}))
+			Ω(props.Names()).Should(Equal([]string{"brightness", "capabilities", "commands", "id", "uptime", "version"}))
+		})
+
+		It("should set writable properties", func() {
+			Ω(d.Dispatch("!-brightness|40")).Should(Equal("brightness|int|40"))
+			Ω(brightness).Should(Equal(40))
+			_, err := d.Dispatch("!-brightness|bright")
+			Ω(err).Should(HaveOccurred())
+			_, err = d.Dispatch("!-brightness|140")
+			Ω(err).Should(MatchError("Brightness must be 0-100"))
+			_, err = d.Dispatch("!-brightness")
+			Ω(err).Should(HaveOccurred())
+			_, err = d.Dispatch("!-version|2.0")
+			Ω(err).Should(MatchError("Property version is read-only"))
+			Ω(brightness).Should(Equal(40))
+		})
+
+		It("should encode and decode typed values", func() {
+			for _, value := range []*lights.PropertyValue{
+				{Name: "pattern", Type: lights.PropertyString, Value: ":ab|#F00"},
+				{Name: "level", Type: lights.PropertyFloat, Value: 0.25},
+				{Name: "on", Type: lights.PropertyBool, Value: true},
+				{Name: "fade", Type: lights.PropertyDuration, Value: 1500 * time.Millisecond},
+			} {
+				decoded, err := lights.ParsePropertyValue(value.String())
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(decoded).Should(Equal(value))
+			}
+			_, err := lights.ParsePropertyValue("level|float")
+			Ω(err).Should(HaveOccurred())
+			_, err = lights.ParsePropertyValue("level|complex|1i")
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should reject invalid properties", func() {
+			get := func() interface{} { return "" }
+			Ω(props.Register(&lights.Property{Name: "", Type: lights.PropertyString, Get: get})).ShouldNot(Succeed())
+			Ω(props.Register(&lights.Property{Name: "a|b", Type: lights.PropertyString, Get: get})).ShouldNot(Succeed())
+			Ω(props.Register(&lights.Property{Name: "ab", Type: "complex", Get: get})).ShouldNot(Succeed())
+			Ω(props.Register(&lights.Property{Name: "ab", Type: lights.PropertyString})).ShouldNot(Succeed())
+		})
+	})
+})
@@ -0,0 +1,230 @@
+package lights
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ProtocolVersion is the command protocol version spoken by this package.
+// Each version adds to the one before:
+//
+//	1  the original protocol, whose messages have no header
+//	2  `@<version>;` headers, extension command types (see RegisterType)
+//	   and `?-capabilities` queries
+const ProtocolVersion = 2
+
+// UnknownCodeError is returned by NewCommand for an unrecognized action or
+// type code. Version is the protocol version of the message, so receivers
+// can tell commands from newer senders apart from malformed ones.
+type UnknownCodeError struct {
+	Kind    string // "action" or "type"
+	Code    byte
+	Version int
+	Command string
+}
+
+// Error describes the unknown code.
+func (e *UnknownCodeError) Error() string {
+	return fmt.Sprintf("Unknown %s code '%s' in command: %s", e.Kind, string(e.Code), e.Command)
+}
+
+// Newer returns true if the command came from a newer protocol version.
+func (e *UnknownCodeError) Newer() bool {
+	return e.Version > ProtocolVersion
+}
+
+// SplitVersion splits the optional protocol version header from a message.
+// Headers have the form `@<version>;` (for example `@2;!:ab|#F00`).
+// Messages without a header are protocol version 1.
+func SplitVersion(message string) (int, string, error) {
+	if !strings.HasPrefix(message, "@") {
+		return 1, message, nil
+	}
+	end := strings.IndexByte(message, ';')
+	if end < 0 {
+		return 0, "", errors.New("Unterminated protocol version header: " + message)
+	}
+	version, err := strconv.Atoi(message[1:end])
+	if err != nil || version < 1 {
+		return 0, "", errors.New("Invalid protocol version header: " + message)
+	}
+	return version, message[end+1:], nil
+}
+
+// VersionedMessage encodes a command with a protocol version header.
+func VersionedMessage(cmd *Command) string {
+	return "@" + strconv.Itoa(ProtocolVersion) + ";" + cmd.String()
+}
+
+// builtinTypeCodes are the type codes of the built-in command types.
+const builtinTypeCodes = "#:~^-"
+
+// commandTypes maps type codes to command types, including registered
+// extension types.
+var commandTypes = struct {
+	sync.RWMutex
+	names map[byte]string
+	codes map[string]byte
+}{
+	names: map[byte]string{'#': "color", ':': "pattern", '~': "schedule", '^': "scene", '-': "property"},
+	codes: map[string]byte{"color": '#', "pattern": ':', "schedule": '~', "scene": '^', "property": '-'},
+}
+
+// RegisterType adds an extension command type with a type code so new
+// command types can be rolled out without changing NewCommand. Extension
+// command IDs are their first part.
+func RegisterType(code byte, commandType string) error {
+	if code <= ' ' || code > '~' || strings.IndexByte("!+-?@%&|", code) >= 0 {
+		return fmt.Errorf("Type code '%s' is reserved", string(code))
+	}
+	if len(commandType) == 0 || strings.ContainsAny(commandType, " ,|") {
+		return fmt.Errorf("Invalid command type '%s'", commandType)
+	}
+	commandTypes.Lock()
+	defer commandTypes.Unlock()
+	if existing, ok := commandTypes.names[code]; ok {
+		return fmt.Errorf("Type code '%s' is already used by %s", string(code), existing)
+	}
+	if _, ok := commandTypes.codes[commandType]; ok {
+		return fmt.Errorf("Command type %s is already registered", commandType)
+	}
+	commandTypes.names[code] = commandType
+	commandTypes.codes[commandType] = code
+	return nil
+}
+
+// UnregisterType removes an extension command type registered with
+// RegisterType. Built-in types can not be removed.
+func UnregisterType(commandType string) error {
+	commandTypes.Lock()
+	defer commandTypes.Unlock()
+	code, ok := commandTypes.codes[commandType]
+	if !ok {
+		return fmt.Errorf("Command type %s is not registered", commandType)
+	}
+	if strings.IndexByte(builtinTypeCodes, code) >= 0 {
+		return fmt.Errorf("Command type %s is built in", commandType)
+	}
+	delete(commandTypes.names, code)
+	delete(commandTypes.codes, commandType)
+	return nil
+}
+
+// CommandTypes lists the known command types in sorted order.
+func CommandTypes() []string {
+	commandTypes.RLock()
+	defer commandTypes.RUnlock()
+	types := []string{}
+	for name := range commandTypes.codes {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// lookupType finds the command type for a type code.
+func lookupType(code byte) (string, bool) {
+	commandTypes.RLock()
+	defer commandTypes.RUnlock()
+	name, ok := commandTypes.names[code]
+	return name, ok
+}
+
+// lookupTypeCode finds the type code for a command type.
+func lookupTypeCode(commandType string) (byte, bool) {
+	commandTypes.RLock()
+	defer commandTypes.RUnlock()
+	code, ok := commandTypes.codes[commandType]
+	return code, ok
+}
+
+// Capabilities describes the protocol version, command types and handled
+// commands of an agent. Capabilities are the string value answered to
+// `?-capabilities` queries, encoded as `version|types|commands`, for
+// example:
+//
+//	2|color,pattern,property|execute pattern,query property
+type Capabilities struct {
+	Version  int
+	Types    []string
+	Commands []string // "action type" pairs (empty if unknown)
+}
+
+// ParseCapabilities decodes the value of a `?-capabilities` response.
+func ParseCapabilities(text string) (*Capabilities, error) {
+	parts := strings.Split(text, "|")
+	if len(parts) != 3 {
+		return nil, errors.New("Invalid capabilities: " + text)
+	}
+	version, err := strconv.Atoi(parts[0])
+	if err != nil || version < 1 {
+		return nil, errors.New("Invalid capabilities version: " + text)
+	}
+	return &Capabilities{version, splitList(parts[1]), splitList(parts[2])}, nil
+}
+
+// String encodes the capabilities.
+func (c *Capabilities) String() string {
+	return strings.Join([]string{
+		strconv.Itoa(c.Version),
+		strings.Join(c.Types, ","),
+		strings.Join(c.Commands, ","),
+	}, "|")
+}
+
+// Supports returns true if the agent understands a command's type and
+// handles its action.
+func (c *Capabilities) Supports(cmd *Command) bool {
+	if !matchList(c.Types, cmd.Type) {
+		return false
+	}
+	return len(c.Commands) == 0 || matchList(c.Commands, cmd.Action+" "+cmd.Type)
+}
+
+// DowngradeFunc rewrites a command into an equivalent using older command
+// types, or returns an error if it can not.
+type DowngradeFunc func(cmd *Command) (*Command, error)
+
+// downgrades holds the registered downgrades by command type.
+var downgrades = struct {
+	sync.RWMutex
+	funcs map[string]DowngradeFunc
+}{funcs: map[string]DowngradeFunc{}}
+
+// RegisterDowngrade registers how commands of a type are rewritten for
+// agents that do not support the type. A nil fn removes the downgrade.
+func RegisterDowngrade(commandType string, fn DowngradeFunc) {
+	downgrades.Lock()
+	defer downgrades.Unlock()
+	if fn == nil {
+		delete(downgrades.funcs, commandType)
+		return
+	}
+	downgrades.funcs[commandType] = fn
+}
+
+// Adapt prepares a command for an agent with these capabilities. Supported
+// commands are returned unchanged, unsupported commands are downgraded if
+// possible and otherwise an UnsupportedError is returned so the sender can
+// skip the command.
+func (c *Capabilities) Adapt(cmd *Command) (*Command, error) {
+	for seen := map[string]bool{}; !c.Supports(cmd); {
+		downgrades.RLock()
+		fn, ok := downgrades.funcs[cmd.Type]
+		downgrades.RUnlock()
+		if !ok || seen[cmd.Type] {
+			return nil, &UnsupportedError{cmd.Action, cmd.Type, cmd.ID}
+		}
+		seen[cmd.Type] = true
+		downgraded, err := fn(cmd)
+		if err != nil {
+			return nil, err
+		}
+		cmd = downgraded
+	}
+	return cmd, nil
+}
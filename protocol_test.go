@@ -0,0 +1,122 @@
+package lights_test
+
+import (
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Protocol", func() {
+		It("should split protocol version headers", func() {
+			version, body, err := lights.SplitVersion("@3;!:ab|#F00")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(version).Should(Equal(3))
+			Ω(body).Should(Equal("!:ab|#F00"))
+			version, body, err = lights.SplitVersion("!:ab|#F00")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(version).Should(Equal(1))
+			Ω(body).Should(Equal("!:ab|#F00"))
+			for _, message := range []string{"@2!:ab", "@x;!:ab", "@0;!:ab"} {
+				_, _, err = lights.SplitVersion(message)
+				Ω(err).Should(HaveOccurred(), message)
+			}
+			cmd, err := lights.NewCommand("@2;!:ab|#F00")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(cmd.ID).Should(Equal("ab"))
+			Ω(lights.VersionedMessage(cmd)).Should(Equal("@2;!:ab|#F00"))
+		})
+
+		It("should report unknown codes with the message version", func() {
+			_, err := lights.NewCommand("@9;!=ab|1")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.UnknownCodeError{}))
+			unknown := err.(*lights.UnknownCodeError)
+			Ω(unknown.Kind).Should(Equal("type"))
+			Ω(unknown.Newer()).Should(BeTrue())
+			Ω(err.Error()).Should(Equal("Unknown type code '=' in command: !=ab|1"))
+			_, err = lights.NewCommand("*:ab")
+			Ω(err.(*lights.UnknownCodeError).Newer()).Should(BeFalse())
+		})
+
+		It("should skip unknown commands from newer senders", func() {
+			d := lights.NewDispatcher()
+			Ω(d.Dispatch("@9;!=ab|1")).Should(Equal(lights.Skipped))
+			_, err := d.Dispatch("!=ab|1")
+			Ω(err).Should(HaveOccurred())
+			_, err = d.Dispatch("@2;!=ab|1")
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should register extension types", func() {
+			Ω(lights.RegisterType('$', "sample")).Should(Succeed())
+			defer lights.UnregisterType("sample")
+			Ω(lights.CommandTypes()).Should(ContainElement("sample"))
+			cmd, err := lights.NewCommand("!$ab|1|2")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(cmd.Type).Should(Equal("sample"))
+			Ω(cmd.ID).Should(Equal("ab"))
+			Ω(cmd.String()).Should(Equal("!$ab|1|2"))
+			Ω(lights.RegisterType('$', "other")).ShouldNot(Succeed())
+			Ω(lights.RegisterType('%', "other")).ShouldNot(Succeed())
+			Ω(lights.RegisterType('*', "pattern")).ShouldNot(Succeed())
+			Ω(lights.RegisterType('*', "a b")).ShouldNot(Succeed())
+
+			Ω(lights.UnregisterType("pattern")).ShouldNot(Succeed())
+			Ω(lights.UnregisterType("sample")).Should(Succeed())
+			Ω(lights.CommandTypes()).ShouldNot(ContainElement("sample"))
+			_, err = lights.NewCommand("!$ab|1|2")
+			Ω(err).Should(HaveOccurred())
+			Ω(lights.UnregisterType("sample")).ShouldNot(Succeed())
+		})
+
+		It("should answer capability queries", func() {
+			d := lights.NewDispatcher()
+			d.OnExecute("pattern", func(cmd *lights.Command) (string, error) { return "", nil })
+			response, err := d.Dispatch("?-capabilities")
+			Ω(err).ShouldNot(HaveOccurred())
+			value, err := lights.ParsePropertyValue(response)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(value.Type).Should(Equal(lights.PropertyString))
+			caps, err := lights.ParseCapabilities(value.Value.(string))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(caps.Version).Should(Equal(lights.ProtocolVersion))
+			Ω(caps.Types).Should(Equal(lights.CommandTypes()))
+			Ω(caps.Commands).Should(Equal([]string{"execute pattern", "query property"}))
+			Ω(caps.String()).Should(Equal(value.Value))
+
+			props := lights.NewProperties()
+			props.Install(d)
+			response, err = d.Dispatch("?-capabilities")
+			Ω(err).ShouldNot(HaveOccurred())
+			value, err = lights.ParsePropertyValue(response)
+			Ω(err).ShouldNot(HaveOccurred())
+			caps, err = lights.ParseCapabilities(value.Value.(string))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(caps.Commands).Should(Equal([]string{"execute pattern", "execute property", "query property"}))
+		})
+
+		It("should adapt commands to the target capabilities", func() {
+			caps, err := lights.ParseCapabilities("1|color,pattern,property|execute color,execute pattern")
+			Ω(err).ShouldNot(HaveOccurred())
+			supported, _ := lights.NewCommand("!:ab|#F00")
+			Ω(caps.Adapt(supported)).Should(BeIdenticalTo(supported))
+
+			lights.RegisterDowngrade("scene", func(cmd *lights.Command) (*lights.Command, error) {
+				return lights.NewCommand("!" + cmd.Parts[1])
+			})
+			defer lights.RegisterDowngrade("scene", nil)
+			scene, _ := lights.NewCommand("!^32|#F00,2|1|3")
+			adapted, err := caps.Adapt(scene)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(adapted.String()).Should(Equal("!#F00,2"))
+
+			query, _ := lights.NewCommand("?:ab")
+			_, err = caps.Adapt(query)
+			Ω(err).Should(BeAssignableToTypeOf(&lights.UnsupportedError{}))
+			schedule, _ := lights.NewCommand("+~8|||0 0 20 * * *|:ab|")
+			_, err = caps.Adapt(schedule)
+			Ω(err).Should(BeAssignableToTypeOf(&lights.UnsupportedError{}))
+		})
+	})
+})
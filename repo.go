@@ -0,0 +1,254 @@
+package lights
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Collections used by the typed repositories.
+const (
+	PatternCollection  = "patterns"
+	ScheduleCollection = "schedules"
+	SceneCollection    = "scenes"
+	GroupCollection    = "groups"
+)
+
+// PatternRepo stores patterns in the PatternCollection of a Store. Patterns
+// are validated and written in their canonical form.
+type PatternRepo struct {
+	Store Store
+}
+
+// NewPatternRepo creates a pattern repository backed by store.
+func NewPatternRepo(store Store) *PatternRepo {
+	return &PatternRepo{Store: store}
+}
+
+// Get reads and parses a pattern. Effect IDs that are not stored give the
+// effect with its default parameters.
+func (r *PatternRepo) Get(id string) (*Pattern, error) {
+	spec, err := r.Store.Read(PatternCollection, id)
+	if IsNotFound(err) && IsEffectID(id) {
+		return NewPattern(":" + id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewPattern(spec)
+}
+
+// Resolve returns the pattern an `!:` command plays. Commands with only an
+// ID play the stored pattern (or built-in effect), others play the pattern
+// they specify.
+func (r *PatternRepo) Resolve(cmd *Command) (*Pattern, error) {
+	if len(cmd.Parts) > 1 {
+		return NewPattern(":" + cmd.Body())
+	}
+	return r.Get(cmd.ID)
+}
+
+// Put validates and writes a pattern.
+func (r *PatternRepo) Put(p *Pattern) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	return r.Store.Write(PatternCollection, p.ID, p.String())
+}
+
+// Delete removes a pattern.
+func (r *PatternRepo) Delete(id string) error {
+	return r.Store.Remove(PatternCollection, id)
+}
+
+// All loads every pattern in ID order.
+func (r *PatternRepo) All() ([]*Pattern, error) {
+	patterns := []*Pattern{}
+	err := r.Store.Each(PatternCollection, func(id, spec string) error {
+		p, err := NewPattern(spec)
+		if err != nil {
+			return fmt.Errorf("Stored pattern %s is invalid: %s", id, err)
+		}
+		patterns = append(patterns, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// Apply adds (`+:`) or removes (`-:`) a pattern as directed by a command.
+func (r *PatternRepo) Apply(cmd *Command) error {
+	if err := checkApply(cmd, "pattern"); err != nil {
+		return err
+	}
+	if cmd.Action == "remove" {
+		return r.Delete(cmd.ID)
+	}
+	p, err := NewPattern(":" + cmd.Body())
+	if err != nil {
+		return err
+	}
+	return r.Put(p)
+}
+
+// ScheduleRepo stores schedules in the ScheduleCollection of a Store.
+type ScheduleRepo struct {
+	Store Store
+}
+
+// NewScheduleRepo creates a schedule repository backed by store.
+func NewScheduleRepo(store Store) *ScheduleRepo {
+	return &ScheduleRepo{Store: store}
+}
+
+// Get reads and parses a schedule.
+func (r *ScheduleRepo) Get(id string) (*Schedule, error) {
+	spec, err := r.Store.Read(ScheduleCollection, id)
+	if err != nil {
+		return nil, err
+	}
+	return NewSchedule(spec)
+}
+
+// Put validates and writes a schedule.
+func (r *ScheduleRepo) Put(s *Schedule) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	return r.Store.Write(ScheduleCollection, s.ID, s.String())
+}
+
+// Delete removes a schedule.
+func (r *ScheduleRepo) Delete(id string) error {
+	return r.Store.Remove(ScheduleCollection, id)
+}
+
+// All loads every schedule in ID order.
+func (r *ScheduleRepo) All() ([]*Schedule, error) {
+	schedules := []*Schedule{}
+	err := r.Store.Each(ScheduleCollection, func(id, spec string) error {
+		s, err := NewSchedule(spec)
+		if err != nil {
+			return fmt.Errorf("Stored schedule %s is invalid: %s", id, err)
+		}
+		schedules = append(schedules, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// Apply adds (`+~`) or removes (`-~`) a schedule as directed by a command.
+func (r *ScheduleRepo) Apply(cmd *Command) error {
+	if err := checkApply(cmd, "schedule"); err != nil {
+		return err
+	}
+	if cmd.Action == "remove" {
+		return r.Delete(cmd.ID)
+	}
+	s, err := NewSchedule(cmd.Body())
+	if err != nil {
+		return err
+	}
+	return r.Put(s)
+}
+
+// SceneRepo stores scenes in the SceneCollection of a Store.
+type SceneRepo struct {
+	Store Store
+}
+
+// NewSceneRepo creates a scene repository backed by store.
+func NewSceneRepo(store Store) *SceneRepo {
+	return &SceneRepo{Store: store}
+}
+
+// Get reads and parses a scene.
+func (r *SceneRepo) Get(id string) (*Scene, error) {
+	spec, err := r.Store.Read(SceneCollection, id)
+	if err != nil {
+		return nil, err
+	}
+	return NewScene(spec)
+}
+
+// Put validates and writes a scene.
+func (r *SceneRepo) Put(s *Scene) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	return r.Store.Write(SceneCollection, s.ID, s.String())
+}
+
+// Delete removes a scene.
+func (r *SceneRepo) Delete(id string) error {
+	return r.Store.Remove(SceneCollection, id)
+}
+
+// All loads every scene in ID order.
+func (r *SceneRepo) All() ([]*Scene, error) {
+	scenes := []*Scene{}
+	err := r.Store.Each(SceneCollection, func(id, spec string) error {
+		s, err := NewScene(spec)
+		if err != nil {
+			return fmt.Errorf("Stored scene %s is invalid: %s", id, err)
+		}
+		scenes = append(scenes, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scenes, nil
+}
+
+// Apply adds (`+^`) or removes (`-^`) a scene as directed by a command.
+func (r *SceneRepo) Apply(cmd *Command) error {
+	if err := checkApply(cmd, "scene"); err != nil {
+		return err
+	}
+	if cmd.Action == "remove" {
+		return r.Delete(cmd.ID)
+	}
+	s, err := NewScene(cmd.Body())
+	if err != nil {
+		return err
+	}
+	return r.Put(s)
+}
+
+// Group returns the devices in a scene group.
+func (r *SceneRepo) Group(name string) ([]string, error) {
+	value, err := r.Store.Read(GroupCollection, name)
+	if err != nil {
+		return nil, err
+	}
+	return splitList(value), nil
+}
+
+// PutGroup writes the devices in a scene group.
+func (r *SceneRepo) PutGroup(name string, devices []string) error {
+	for _, device := range devices {
+		if len(device) == 0 || strings.ContainsAny(device, ",|=@") {
+			return fmt.Errorf("Invalid device '%s' in group %s", device, name)
+		}
+	}
+	return r.Store.Write(GroupCollection, name, strings.Join(devices, ","))
+}
+
+// checkApply checks a command can be applied to a repository of a type.
+func checkApply(cmd *Command, commandType string) error {
+	if cmd.Type != commandType {
+		return fmt.Errorf("Can not apply %s command to %s repository", cmd.Type, commandType)
+	}
+	if cmd.Action != "add" && cmd.Action != "remove" {
+		return fmt.Errorf("Can not apply %s command to %s repository", cmd.Action, commandType)
+	}
+	if len(strings.TrimSpace(cmd.ID)) == 0 {
+		return fmt.Errorf("Missing %s ID in command", commandType)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package lights_test
+
+import (
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Repositories", func() {
+		var store *lights.MockStore
+
+		BeforeEach(func() {
+			store = &lights.MockStore{}
+		})
+
+		apply := func(message string, fn func(*lights.Command) error) error {
+			cmd, err := lights.NewCommand(message)
+			Ω(err).ShouldNot(HaveOccurred())
+			return fn(cmd)
+		}
+
+		It("should add and remove patterns in canonical form", func() {
+			repo := lights.NewPatternRepo(store)
+			Ω(apply("+:ab:2|#f00,2,1|#0F0,500ms", repo.Apply)).Should(Succeed())
+			Ω(store.Read(lights.PatternCollection, "ab")).Should(Equal(":ab:2|#FF0000,2s,1s,ease|#00FF00,500ms,0s,ease"))
+			p, err := repo.Get("ab")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.Loops).Should(Equal(2))
+			Ω(p.Slots[0].Fade).Should(Equal(2 * time.Second))
+			Ω(repo.All()).Should(HaveLen(1))
+			Ω(apply("-:ab", repo.Apply)).Should(Succeed())
+			_, err = repo.Get("ab")
+			Ω(lights.IsNotFound(err)).Should(BeTrue())
+		})
+
+		It("should reject invalid patterns", func() {
+			repo := lights.NewPatternRepo(store)
+			Ω(apply("+:ab", repo.Apply)).ShouldNot(Succeed())
+			Ω(apply("+:ab|,2,1", repo.Apply)).ShouldNot(Succeed())
+			Ω(apply("+:|#F00", repo.Apply)).ShouldNot(Succeed())
+			Ω(apply("!:ab|#F00", repo.Apply)).ShouldNot(Succeed())
+			Ω(apply("+~ab|||0 0 20 * * *|#F00|", repo.Apply)).ShouldNot(Succeed())
+			Ω(store.List(lights.PatternCollection)).Should(BeEmpty())
+		})
+
+		It("should add and remove schedules", func() {
+			repo := lights.NewScheduleRepo(store)
+			Ω(apply("+~8|2015-07-04|2015-07-05|0  0 20 * * *|:ab|1", repo.Apply)).Should(Succeed())
+			Ω(store.Read(lights.ScheduleCollection, "8")).Should(Equal("8|2015-07-04|2015-07-05|0 0 20 * * *|:ab|1"))
+			s, err := repo.Get("8")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Start).Should(Equal(time.Date(2015, 7, 4, 0, 0, 0, 0, time.UTC)))
+			Ω(s.Target).Should(Equal(":ab"))
+			Ω(apply("+~4|||0 30 * * * *|#000|", repo.Apply)).Should(Succeed())
+			Ω(repo.All()).Should(HaveLen(2))
+			Ω(apply("-~8", repo.Apply)).Should(Succeed())
+			Ω(store.List(lights.ScheduleCollection)).Should(Equal([]string{"4"}))
+		})
+
+		It("should reject invalid schedules", func() {
+			repo := lights.NewScheduleRepo(store)
+			Ω(apply("+~8|||0 20 * * *|:ab|", repo.Apply)).ShouldNot(Succeed())
+			Ω(apply("+~8|2015-07-05|2015-07-04|0 0 20 * * *|:ab|", repo.Apply)).ShouldNot(Succeed())
+			Ω(apply("+~8|07/04/2015||0 0 20 * * *|:ab|", repo.Apply)).ShouldNot(Succeed())
+			Ω(apply("+~8|||0 0 20 * * *|ab|", repo.Apply)).ShouldNot(Succeed())
+			Ω(store.List(lights.ScheduleCollection)).Should(BeEmpty())
+		})
+
+		It("should add and remove scenes", func() {
+			repo := lights.NewSceneRepo(store)
+			Ω(apply("+^32|#F00,2|1|3|ab", repo.Apply)).Should(Succeed())
+			s, err := repo.Get("32")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Target).Should(Equal("#F00,2"))
+			Ω(s.Devices).Should(Equal([]string{"1", "3", "ab"}))
+			Ω(apply("+^2|red|4", repo.Apply)).ShouldNot(Succeed())
+			Ω(apply("-^32", repo.Apply)).Should(Succeed())
+			Ω(repo.All()).Should(BeEmpty())
+		})
+	})
+})
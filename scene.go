@@ -0,0 +1,164 @@
+package lights
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultCrossfade is the time taken to crossfade into a pattern target that
+// does not give a fade. Color targets use their slot fade.
+const DefaultCrossfade = time.Second
+
+// Scene sets a group of devices to a target color or pattern. Scenes are
+// specified as `ID|target|member|member...` where the target is a slot like
+// `#F00,2s` or a pattern reference like `:ab` (crossfading for 3 seconds
+// with `:ab,3s`). Members are device IDs or
+// `@group` names and may override the scene target with `member=target`.
+// Later members override earlier ones. For example:
+//
+//	32|#F00,2|1|3|ab
+//	40|:ab|@kitchen|@kitchen-island=#FFF,3s|7=:cd,500ms
+type Scene struct {
+	ID      string
+	Target  string
+	Devices []string
+}
+
+// NewScene parses a scene specification string.
+func NewScene(spec string) (*Scene, error) {
+	parts := strings.Split(spec, "|")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("Scene must have at least 2 parts - found %d: %s", len(parts), spec)
+	}
+	s := &Scene{
+		ID:      strings.TrimSpace(parts[0]),
+		Target:  strings.TrimSpace(parts[1]),
+		Devices: []string{},
+	}
+	for _, device := range parts[2:] {
+		if device = strings.TrimSpace(device); len(device) > 0 {
+			s.Devices = append(s.Devices, device)
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate checks the scene has an ID and a valid target.
+func (s *Scene) Validate() error {
+	if len(s.ID) == 0 {
+		return fmt.Errorf("Missing ID in scene: %s", s)
+	}
+	if err := validateTarget(s.Target); err != nil {
+		return fmt.Errorf("Invalid scene %s: %s", s.ID, err)
+	}
+	for _, member := range s.Devices {
+		name, target := splitMember(member)
+		if len(name) == 0 || name == "@" {
+			return fmt.Errorf("Invalid scene %s member '%s'", s.ID, member)
+		}
+		if len(target) > 0 {
+			if err := validateTarget(target); err != nil {
+				return fmt.Errorf("Invalid scene %s member '%s': %s", s.ID, member, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Members returns the target for each device in the scene. Groups are
+// expanded using the groups function.
+func (s *Scene) Members(groups func(name string) ([]string, error)) (map[string]string, error) {
+	members := map[string]string{}
+	for _, member := range s.Devices {
+		name, target := splitMember(member)
+		if len(target) == 0 {
+			target = s.Target
+		}
+		devices := []string{name}
+		if strings.HasPrefix(name, "@") {
+			var err error
+			if devices, err = groups(name[1:]); err != nil {
+				return nil, err
+			}
+		}
+		for _, device := range devices {
+			members[device] = target
+		}
+	}
+	return members, nil
+}
+
+// TargetFade returns the time taken to crossfade to a scene target.
+func TargetFade(target string) time.Duration {
+	switch {
+	case strings.HasPrefix(target, "#"):
+		if slot, err := NewSlot(target); err == nil {
+			return slot.Fade
+		}
+	case strings.HasPrefix(target, ":"):
+		if _, fade, err := patternTarget(target); err == nil {
+			return fade
+		}
+	}
+	return DefaultCrossfade
+}
+
+// patternTarget splits a pattern target like `:ab,3s` into the pattern ID
+// and crossfade (DefaultCrossfade if no fade is given).
+func patternTarget(target string) (string, time.Duration, error) {
+	parts := strings.SplitN(strings.TrimPrefix(target, ":"), ",", 2)
+	id := strings.TrimSpace(parts[0])
+	if len(id) == 0 {
+		return "", 0, fmt.Errorf("Target %s has no pattern ID", target)
+	}
+	if len(parts) == 1 {
+		return id, DefaultCrossfade, nil
+	}
+	fade, err := ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid fade in target %s: %s", target, err)
+	}
+	if fade < 0 {
+		return "", 0, fmt.Errorf("Target %s has a negative fade", target)
+	}
+	return id, fade, nil
+}
+
+// splitMember splits a scene member into its name and optional target.
+func splitMember(member string) (string, string) {
+	parts := strings.SplitN(member, "=", 2)
+	if len(parts) == 1 {
+		return strings.TrimSpace(parts[0]), ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// validateTarget checks a color slot or pattern reference target.
+func validateTarget(target string) error {
+	switch {
+	case strings.HasPrefix(target, "#"):
+		slot, err := NewSlot(target)
+		if err != nil {
+			return fmt.Errorf("Invalid target %s: %s", target, err)
+		}
+		if slot.Color == nil {
+			return fmt.Errorf("Target %s has no color", target)
+		}
+	case strings.HasPrefix(target, ":"):
+		if _, _, err := patternTarget(target); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Target must be a color or pattern: %s", target)
+	}
+	return nil
+}
+
+// String returns the canonical scene specification for the scene.
+func (s *Scene) String() string {
+	return strings.Join(append([]string{s.ID, s.Target}, s.Devices...), "|")
+}
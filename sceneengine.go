@@ -0,0 +1,191 @@
+package lights
+
+import (
+	"image/color"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deviceOutput is what a SceneEngine is showing on a device.
+type deviceOutput struct {
+	scene   string
+	target  string
+	from    color.Color // Output when the crossfade started
+	pattern *Pattern    // Color targets are single slot patterns
+	start   time.Time
+	fade    time.Duration
+}
+
+// SceneEngine executes scenes and tracks the output of every member
+// device. Switching scenes crossfades each device from the color it is
+// currently showing (even part way through a crossfade) rather than
+// snapping to the new target. Pattern targets start playing when the
+// crossfade starts.
+type SceneEngine struct {
+	Scenes     *SceneRepo
+	Patterns   *PatternRepo
+	Brightness *Brightness      // Dims outputs if set
+	Now        func() time.Time // Clock used for crossfades (time.Now if nil)
+
+	lock    sync.Mutex
+	outputs map[string]*deviceOutput
+}
+
+// NewSceneEngine creates a scene engine using the scenes, groups and
+// patterns in store.
+func NewSceneEngine(store Store) *SceneEngine {
+	return &SceneEngine{
+		Scenes:   NewSceneRepo(store),
+		Patterns: NewPatternRepo(store),
+		outputs:  map[string]*deviceOutput{},
+	}
+}
+
+// Install registers the engine as the dispatcher's scene handler. Added and
+// removed scenes are stored in the scene repository.
+func (e *SceneEngine) Install(d *Dispatcher) {
+	d.OnExecute("scene", e.Command)
+	apply := func(cmd *Command) (string, error) {
+		return "", e.Scenes.Apply(cmd)
+	}
+	d.OnAdd("scene", apply)
+	d.OnRemove("scene", apply)
+}
+
+// Command executes a `!^` command. Commands with only an ID run the stored
+// scene, others run the scene they specify.
+func (e *SceneEngine) Command(cmd *Command) (string, error) {
+	var scene *Scene
+	var err error
+	if len(cmd.Parts) > 1 {
+		scene, err = NewScene(cmd.Body())
+	} else {
+		scene, err = e.Scenes.Get(cmd.ID)
+	}
+	if err != nil {
+		return "", err
+	}
+	parts, err := e.Execute(scene)
+	if err != nil {
+		return "", err
+	}
+	devices := []string{}
+	for device := range parts {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	return strings.Join(devices, ","), nil
+}
+
+// Execute starts crossfading every member device of a scene to its target
+// and returns each device's target. Nothing changes if a target pattern can
+// not be loaded.
+func (e *SceneEngine) Execute(scene *Scene) (map[string]string, error) {
+	parts, err := scene.Members(e.Scenes.Group)
+	if err != nil {
+		return nil, err
+	}
+	patterns := map[string]*Pattern{}
+	for device, target := range parts {
+		if patterns[device], err = e.targetPattern(target); err != nil {
+			return nil, err
+		}
+	}
+	now := e.now()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.outputs == nil {
+		e.outputs = map[string]*deviceOutput{}
+	}
+	for device, target := range parts {
+		e.outputs[device] = &deviceOutput{
+			scene:   scene.ID,
+			target:  target,
+			from:    e.output(device, now),
+			pattern: patterns[device],
+			start:   now,
+			fade:    TargetFade(target),
+		}
+	}
+	return parts, nil
+}
+
+// Output returns the color a device is currently showing (black if no scene
+// has included the device).
+func (e *SceneEngine) Output(device string) color.Color {
+	now := e.now()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.dim(device, e.output(device, now))
+}
+
+// Outputs returns the current color of every device.
+func (e *SceneEngine) Outputs() map[string]color.Color {
+	now := e.now()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	outputs := map[string]color.Color{}
+	for device := range e.outputs {
+		outputs[device] = e.dim(device, e.output(device, now))
+	}
+	return outputs
+}
+
+// dim applies the device brightness to an output.
+func (e *SceneEngine) dim(device string, c color.Color) color.Color {
+	if e.Brightness == nil {
+		return c
+	}
+	return e.Brightness.ApplyColor(device, c)
+}
+
+// Scene returns the ID of the last scene executed on a device.
+func (e *SceneEngine) Scene(device string) string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if o, ok := e.outputs[device]; ok {
+		return o.scene
+	}
+	return ""
+}
+
+// output computes a device's color. The caller must hold the lock.
+func (e *SceneEngine) output(device string, now time.Time) color.Color {
+	o, ok := e.outputs[device]
+	if !ok {
+		return color.RGBA{}
+	}
+	elapsed := now.Sub(o.start)
+	target, _ := o.pattern.ColorAt(elapsed)
+	if elapsed < o.fade {
+		return MixColors(o.from, target, Ease("ease", float64(elapsed)/float64(o.fade)))
+	}
+	return target
+}
+
+// targetPattern loads the pattern for a scene target. Color targets become
+// a single slot pattern.
+func (e *SceneEngine) targetPattern(target string) (*Pattern, error) {
+	if strings.HasPrefix(target, ":") {
+		id, _, err := patternTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		return e.Patterns.Get(id)
+	}
+	slot, err := NewSlot(target)
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{Loops: -1, Slots: []*Slot{{Color: slot.Color, Transition: slot.Transition}}}, nil
+}
+
+// now returns the current time from the engine's clock.
+func (e *SceneEngine) now() time.Time {
+	if e.Now != nil {
+		return e.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,119 @@
+package lights_test
+
+import (
+	"image/color"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("SceneEngine", func() {
+		var store *lights.MockStore
+		var engine *lights.SceneEngine
+		var d *lights.Dispatcher
+		var now time.Time
+
+		red := color.RGBA{0xff, 0, 0, 0}
+		blue := color.RGBA{0, 0, 0xff, 0}
+		white := color.RGBA{0xff, 0xff, 0xff, 0}
+
+		BeforeEach(func() {
+			store = &lights.MockStore{}
+			now = time.Date(2015, 7, 4, 20, 0, 0, 0, time.UTC)
+			engine = lights.NewSceneEngine(store)
+			engine.Now = func() time.Time { return now }
+			d = lights.NewDispatcher()
+			engine.Install(d)
+		})
+
+		It("should validate scene members", func() {
+			_, err := lights.NewScene("40|:ab|@kitchen|island=#FFF,3s")
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = lights.NewScene("40|:ab|island=red")
+			Ω(err).Should(HaveOccurred())
+			_, err = lights.NewScene("40|:ab|=#FFF")
+			Ω(err).Should(HaveOccurred())
+			_, err = lights.NewScene("40|:ab|@")
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should compute each member device's part", func() {
+			Ω(engine.Scenes.PutGroup("kitchen", []string{"1", "2", "3"})).Should(Succeed())
+			Ω(engine.Scenes.PutGroup("bad", []string{"a,b"})).ShouldNot(Succeed())
+			scene, err := lights.NewScene("40|#F00,2|@kitchen|2=#00F|4")
+			Ω(err).ShouldNot(HaveOccurred())
+			parts, err := engine.Execute(scene)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(parts).Should(Equal(map[string]string{"1": "#F00,2", "2": "#00F", "3": "#F00,2", "4": "#F00,2"}))
+			Ω(engine.Scene("4")).Should(Equal("40"))
+
+			scene, _ = lights.NewScene("41|#F00|@missing")
+			_, err = engine.Execute(scene)
+			Ω(lights.IsNotFound(err)).Should(BeTrue())
+			scene, _ = lights.NewScene("41|:nope|1")
+			_, err = engine.Execute(scene)
+			Ω(lights.IsNotFound(err)).Should(BeTrue())
+			Ω(engine.Scene("1")).Should(Equal("40"))
+		})
+
+		It("should crossfade from the current output", func() {
+			Ω(d.Dispatch("+^red|#F00,2s|1")).Should(BeEmpty())
+			Ω(d.Dispatch("+^blue|#00F,2s|1")).Should(BeEmpty())
+			Ω(d.Dispatch("!^red")).Should(Equal("1"))
+			Ω(engine.Output("1")).Should(Equal(color.RGBA{}))
+			now = now.Add(time.Second)
+			Ω(engine.Output("1")).Should(Equal(color.RGBA{0x80, 0, 0, 0}))
+			now = now.Add(time.Second)
+			Ω(engine.Output("1")).Should(Equal(red))
+
+			Ω(d.Dispatch("!^blue")).Should(Equal("1"))
+			Ω(engine.Output("1")).Should(Equal(red))
+			now = now.Add(time.Second)
+			Ω(engine.Output("1")).Should(Equal(color.RGBA{0x80, 0, 0x80, 0}))
+
+			// Switching part way through starts from the blended color
+			Ω(d.Dispatch("!^white|#FFF,1s|1")).Should(Equal("1"))
+			Ω(engine.Output("1")).Should(Equal(color.RGBA{0x80, 0, 0x80, 0}))
+			now = now.Add(time.Second)
+			Ω(engine.Outputs()).Should(Equal(map[string]color.Color{"1": white}))
+			Ω(engine.Output("2")).Should(Equal(color.RGBA{}))
+		})
+
+		It("should play pattern targets after crossfading", func() {
+			p, err := lights.NewPattern(":ab|#00F,0,1|#F00,0,1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(engine.Patterns.Put(p)).Should(Succeed())
+			Ω(d.Dispatch("!^40|:ab|1")).Should(Equal("1"))
+			now = now.Add(lights.DefaultCrossfade / 2)
+			Ω(engine.Output("1")).Should(Equal(color.RGBA{0, 0, 0x80, 0}))
+			now = now.Add(lights.DefaultCrossfade / 2)
+			Ω(engine.Output("1")).Should(Equal(red))
+			now = now.Add(time.Second)
+			Ω(engine.Output("1")).Should(Equal(blue))
+		})
+
+		It("should crossfade to pattern targets for their fade", func() {
+			p, err := lights.NewPattern(":ab|#00F,0,1|#F00,0,1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(engine.Patterns.Put(p)).Should(Succeed())
+			Ω(lights.TargetFade(":ab,2s")).Should(Equal(2 * time.Second))
+			Ω(lights.TargetFade(":ab")).Should(Equal(lights.DefaultCrossfade))
+			Ω(d.Dispatch("!^41|:ab,2s|2")).Should(Equal("2"))
+			now = now.Add(time.Second)
+			Ω(engine.Output("2")).Should(Equal(color.RGBA{0x80, 0, 0, 0}))
+			now = now.Add(time.Second)
+			Ω(engine.Output("2")).Should(Equal(blue))
+
+			for _, spec := range []string{"42|:ab,x|1", "42|:,1s|1", "42|:ab,-1|1", "42|#F00|1=:ab,"} {
+				_, err := lights.NewScene(spec)
+				Ω(err).Should(HaveOccurred(), spec)
+			}
+			_, err = lights.NewScene("42|#F00|1=:ab,500ms")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,99 @@
+package lights
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScheduleDate is the layout of schedule start and end dates.
+const ScheduleDate = "2006-01-02"
+
+// Schedule runs a target color or pattern according to a cron expression
+// between optional start and end dates. Schedules are specified as
+// `ID|start|end|cron|target|extra` where the cron expression has six fields
+// (seconds first) and the target is a slot like `#F00,2s` or a pattern
+// reference like `:ab` (or `:ab,3s` to crossfade for 3 seconds). For
+// example:
+//
+//	8|2015-07-04|2015-07-05|0 0 20 * * *|:ab|1
+type Schedule struct {
+	ID     string
+	Start  time.Time // Zero if the schedule has no start date
+	End    time.Time // Zero if the schedule has no end date
+	Cron   string
+	Target string
+	Extra  string
+}
+
+// NewSchedule parses a schedule specification string.
+func NewSchedule(spec string) (*Schedule, error) {
+	parts := strings.Split(spec, "|")
+	if len(parts) < 5 {
+		return nil, fmt.Errorf("Schedule must have at least 5 parts - found %d: %s", len(parts), spec)
+	}
+	s := &Schedule{
+		ID:     strings.TrimSpace(parts[0]),
+		Cron:   strings.Join(strings.Fields(parts[3]), " "),
+		Target: strings.TrimSpace(parts[4]),
+		Extra:  strings.Join(parts[5:], "|"),
+	}
+	var err error
+	if s.Start, err = parseScheduleDate(parts[1]); err != nil {
+		return nil, fmt.Errorf("Invalid start date in schedule: %s", spec)
+	}
+	if s.End, err = parseScheduleDate(parts[2]); err != nil {
+		return nil, fmt.Errorf("Invalid end date in schedule: %s", spec)
+	}
+	if err = s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate checks the schedule has an ID, a six field cron expression, a
+// valid target and does not end before it starts.
+func (s *Schedule) Validate() error {
+	if len(s.ID) == 0 {
+		return fmt.Errorf("Missing ID in schedule: %s", s)
+	}
+	if fields := strings.Fields(s.Cron); len(fields) != 6 {
+		return fmt.Errorf("Schedule cron must have 6 fields - found %d: %s", len(fields), s)
+	}
+	if !s.Start.IsZero() && !s.End.IsZero() && s.End.Before(s.Start) {
+		return fmt.Errorf("Schedule ends before it starts: %s", s)
+	}
+	if err := validateTarget(s.Target); err != nil {
+		return fmt.Errorf("Invalid schedule %s: %s", s.ID, err)
+	}
+	return nil
+}
+
+// String returns the canonical schedule specification for the schedule.
+func (s *Schedule) String() string {
+	return strings.Join([]string{
+		s.ID,
+		formatScheduleDate(s.Start),
+		formatScheduleDate(s.End),
+		s.Cron,
+		s.Target,
+		s.Extra,
+	}, "|")
+}
+
+// parseScheduleDate parses an optional schedule date.
+func parseScheduleDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	return time.Parse(ScheduleDate, value)
+}
+
+// formatScheduleDate formats an optional schedule date.
+func formatScheduleDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(ScheduleDate)
+}
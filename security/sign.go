@@ -0,0 +1,71 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/inceptionllc/go-lights"
+)
+
+// LoadEd25519Signer loads a certificate key pair (`.pem` and `.key` files)
+// and returns a signer for command envelopes along with the certificate
+// subject common name that should be used as the envelope sender.
+func LoadEd25519Signer(path string) (*lights.Ed25519Signer, string, error) {
+	path, err := lights.PrepPath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	cert, err := tls.LoadX509KeyPair(path+".pem", path+".key")
+	if err != nil {
+		return nil, "", err
+	}
+	key, ok := cert.PrivateKey.(ed25519.PrivateKey)
+	if !ok {
+		return nil, "", errors.New("Certificate key is not an Ed25519 key: " + path)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, "", err
+	}
+	return &lights.Ed25519Signer{Key: key}, leaf.Subject.CommonName, nil
+}
+
+// LoadEd25519Verifier creates an envelope verifier from any number of PEM
+// encoded certificates. Each certificate's public key is registered for the
+// sender named by the certificate subject common name.
+func LoadEd25519Verifier(paths ...string) (*lights.Ed25519Verifier, error) {
+	verifier := &lights.Ed25519Verifier{Keys: map[string]ed25519.PublicKey{}}
+	for _, path := range paths {
+		path, err := lights.PrepPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasSuffix(path, ".pem") {
+			path += ".pem"
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for block, rest := pem.Decode(raw); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := cert.PublicKey.(ed25519.PublicKey)
+			if !ok {
+				return nil, errors.New("Certificate key is not an Ed25519 key: " + path)
+			}
+			verifier.Keys[cert.Subject.CommonName] = key
+		}
+	}
+	return verifier, nil
+}
@@ -0,0 +1,153 @@
+package security_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+	"github.com/inceptionllc/go-lights/security"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Security", func() {
+	Describe("Ed25519 keys", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "lights-security")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		// writePEM writes a PEM block to a file in the test folder.
+		writePEM := func(name, blockType string, data []byte) {
+			raw := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data})
+			Ω(ioutil.WriteFile(filepath.Join(dir, name), raw, 0600)).Should(Succeed())
+		}
+
+		// writeCert writes a self-signed certificate (`.pem`) and its private
+		// key (`.key`) for a common name, returning the path without suffix.
+		writeCert := func(name, cn string, public crypto.PublicKey, private crypto.Signer) string {
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: cn},
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(time.Hour),
+			}
+			cert, err := x509.CreateCertificate(rand.Reader, template, template, public, private)
+			Ω(err).ShouldNot(HaveOccurred())
+			key, err := x509.MarshalPKCS8PrivateKey(private)
+			Ω(err).ShouldNot(HaveOccurred())
+			writePEM(name+".pem", "CERTIFICATE", cert)
+			writePEM(name+".key", "PRIVATE KEY", key)
+			return filepath.Join(dir, name)
+		}
+
+		newEd25519 := func(name, cn string) string {
+			public, private, err := ed25519.GenerateKey(rand.Reader)
+			Ω(err).ShouldNot(HaveOccurred())
+			return writeCert(name, cn, public, private)
+		}
+
+		It("should sign and verify envelopes with certificate keys", func() {
+			gateway := newEd25519("gateway", "gateway")
+			newEd25519("controller", "controller")
+			signer, sender, err := security.LoadEd25519Signer(gateway)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(sender).Should(Equal("gateway"))
+			Ω(signer.Key).Should(HaveLen(ed25519.PrivateKeySize))
+
+			verifier, err := security.LoadEd25519Verifier(gateway, filepath.Join(dir, "controller.pem"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(verifier.Keys).Should(HaveLen(2))
+			msg, err := lights.Seal(sender, "!#F00", signer)
+			Ω(err).ShouldNot(HaveOccurred())
+			envelope, err := lights.ParseEnvelope(msg)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(envelope.Verify(verifier)).Should(Succeed())
+
+			envelope.Sender = "controller"
+			Ω(envelope.Verify(verifier)).ShouldNot(Succeed())
+		})
+
+		It("should load every certificate in a bundle", func() {
+			gateway := newEd25519("gateway", "gateway")
+			controller := newEd25519("controller", "controller")
+			bundle := []byte{}
+			for _, path := range []string{gateway, controller} {
+				raw, err := ioutil.ReadFile(path + ".pem")
+				Ω(err).ShouldNot(HaveOccurred())
+				bundle = append(bundle, raw...)
+			}
+			key, err := ioutil.ReadFile(gateway + ".key")
+			Ω(err).ShouldNot(HaveOccurred())
+			bundle = append(bundle, key...)
+			Ω(ioutil.WriteFile(filepath.Join(dir, "bundle.pem"), bundle, 0600)).Should(Succeed())
+
+			verifier, err := security.LoadEd25519Verifier(filepath.Join(dir, "bundle"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(verifier.Keys).Should(HaveKey("gateway"))
+			Ω(verifier.Keys).Should(HaveKey("controller"))
+		})
+
+		It("should reject keys that are not Ed25519", func() {
+			private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Ω(err).ShouldNot(HaveOccurred())
+			path := writeCert("ecdsa", "gateway", &private.PublicKey, private)
+			_, _, err = security.LoadEd25519Signer(path)
+			Ω(err).Should(MatchError(HavePrefix("Certificate key is not an Ed25519 key")))
+			_, err = security.LoadEd25519Verifier(path)
+			Ω(err).Should(MatchError(HavePrefix("Certificate key is not an Ed25519 key")))
+		})
+
+		It("should reject malformed and missing key files", func() {
+			path := newEd25519("gateway", "gateway")
+			writePEM("gateway.key", "PRIVATE KEY", []byte("not a key"))
+			_, _, err := security.LoadEd25519Signer(path)
+			Ω(err).Should(HaveOccurred())
+
+			writePEM("broken.pem", "CERTIFICATE", []byte("not a certificate"))
+			_, err = security.LoadEd25519Verifier(filepath.Join(dir, "broken"))
+			Ω(err).Should(HaveOccurred())
+
+			_, _, err = security.LoadEd25519Signer(filepath.Join(dir, "missing"))
+			Ω(os.IsNotExist(err)).Should(BeTrue())
+			_, err = security.LoadEd25519Verifier(filepath.Join(dir, "missing"))
+			Ω(os.IsNotExist(err)).Should(BeTrue())
+		})
+
+		It("should reject wrong size keys", func() {
+			public, private, err := ed25519.GenerateKey(rand.Reader)
+			Ω(err).ShouldNot(HaveOccurred())
+			signer := &lights.Ed25519Signer{Key: private[:16]}
+			_, err = lights.Seal("gateway", "!#F00", signer)
+			Ω(err).Should(HaveOccurred())
+
+			msg, err := lights.Seal("gateway", "!#F00", &lights.Ed25519Signer{Key: private})
+			Ω(err).ShouldNot(HaveOccurred())
+			envelope, err := lights.ParseEnvelope(msg)
+			Ω(err).ShouldNot(HaveOccurred())
+			verifier := &lights.Ed25519Verifier{Keys: map[string]ed25519.PublicKey{"gateway": public[:16]}}
+			Ω(envelope.Verify(verifier)).Should(MatchError(HavePrefix("Invalid Ed25519 public key")))
+			verifier.Keys["gateway"] = public
+			Ω(envelope.Verify(verifier)).Should(Succeed())
+		})
+	})
+})
@@ -2,10 +2,17 @@ package lights
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // Data Store implementation
@@ -21,14 +28,181 @@ type Store interface {
 	Remove(collection, id string) error
 	// Remove all values from the provided collection.
 	RemoveAll(collection string) error
-	// Load reads all the values out of a collection.
-	Load(colection string) ([]string, error)
+	// Load reads all the values out of a collection in ID order.
+	Load(collection string) ([]string, error)
+	// List returns the IDs of all items in a collection in sorted order.
+	List(collection string) ([]string, error)
+	// LoadMap reads all the items out of a collection keyed by ID.
+	LoadMap(collection string) (map[string]string, error)
+	// Each calls fn for every item in a collection in ID order, stopping
+	// and returning the first error.
+	Each(collection string, fn func(id, value string) error) error
+	// Watch reports items created, updated or deleted in a collection.
+	Watch(collection string) (*Watcher, error)
 }
 
+// ErrEmptyValue is returned when writing an empty value. Use Remove to
+// clear an item.
+var ErrEmptyValue = errors.New("Store values must not be empty")
+
+// NotFoundError is returned when reading or removing an item that is not
+// in a Store.
+type NotFoundError struct {
+	Collection string
+	ID         string
+}
+
+// Error describes the missing item.
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("No item with ID %s found in collection %s", e.ID, e.Collection)
+}
+
+// IsNotFound returns true if the error is (or wraps) a NotFoundError.
+func IsNotFound(err error) bool {
+	var notFound *NotFoundError
+	return errors.As(err, &notFound)
+}
+
+// validateItem checks the names and value of an item being written.
+func validateItem(collection, id, value string) error {
+	if err := validateNames(collection, id); err != nil {
+		return err
+	}
+	if len(value) == 0 {
+		return ErrEmptyValue
+	}
+	return nil
+}
+
+// CollectionLister is implemented by stores that can list the names of the
+// collections they hold.
+type CollectionLister interface {
+	Collections() ([]string, error)
+}
+
+// Updater is implemented by stores that can apply a Batch of changes
+// atomically. Update runs fn with a new batch and commits it if fn returns
+// nil. Store wrappers forward Update and return ErrBatchUnsupported (without
+// running fn) if the store they wrap can not apply batches.
+type Updater interface {
+	Update(fn func(b *Batch) error) error
+}
+
+// ErrBatchUnsupported is returned by Update when a wrapped store can not
+// apply batches atomically.
+var ErrBatchUnsupported = errors.New("Store can not apply batches atomically")
+
+// WriteLocker is implemented by stores that can hold off writers in every
+// process sharing the store while a value is checked and changed.
+// LockWrites waits for the lock and returns a function that releases it.
+type WriteLocker interface {
+	LockWrites() (unlock func(), err error)
+}
+
+// loadValues collects the values from Each into a slice.
+func loadValues(s Store, collection string) ([]string, error) {
+	items := []string{}
+	err := s.Each(collection, func(id, value string) error {
+		items = append(items, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// loadMap collects the items from Each into a map.
+func loadMap(s Store, collection string) (map[string]string, error) {
+	items := map[string]string{}
+	err := s.Each(collection, func(id, value string) error {
+		items[id] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// NewStore creates the Store configured by the INC_STORE environment
+// variable. See OpenStore for the supported settings. If INC_STORE_KEY
+// names a key file the store is wrapped in an EncryptedStore, which also
+// reads values written before encryption was enabled if
+// INC_STORE_PLAINTEXT is true.
+func NewStore() (Store, error) {
+	store, err := OpenStore(os.Getenv("INC_STORE"))
+	if err != nil {
+		return nil, err
+	}
+	keyFile := os.Getenv("INC_STORE_KEY")
+	if len(keyFile) == 0 {
+		return store, nil
+	}
+	key, err := LoadKeyFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := NewEncryptedStore(store, key)
+	if err != nil {
+		return nil, err
+	}
+	if plaintext := os.Getenv("INC_STORE_PLAINTEXT"); len(plaintext) > 0 {
+		if encrypted.AllowPlaintext, err = strconv.ParseBool(plaintext); err != nil {
+			return nil, errors.New("INC_STORE_PLAINTEXT must be true or false: " + plaintext)
+		}
+	}
+	return encrypted, nil
+}
+
+// OpenStore creates a Store from a specification of the form `kind:path`.
+// The `file` kind creates a FileStore using path as the base folder, the
+// `log` kind creates a LogStore using path as the log file and the `memory`
+// kind creates a MemoryStore, saved every minute to path if one is given.
+// An empty specification creates a FileStore in the default location.
+func OpenStore(spec string) (Store, error) {
+	if len(spec) == 0 {
+		return NewFileStore()
+	}
+	parts := strings.SplitN(spec, ":", 2)
+	path := []string{}
+	if len(parts) == 2 && len(parts[1]) > 0 {
+		path = append(path, parts[1])
+	}
+	switch parts[0] {
+	case "file":
+		return NewFileStore(path...)
+	case "log":
+		if len(path) == 0 {
+			return NewLogStore("/var/lib/inception/lighting/data.log")
+		}
+		return NewLogStore(path[0])
+	case "memory":
+		if len(path) == 0 {
+			return NewMemoryStore(), nil
+		}
+		return OpenMemoryStore(path[0], time.Minute)
+	default:
+		return nil, fmt.Errorf("Unknown store kind '%s' in: %s", parts[0], spec)
+	}
+}
+
+// Store folders and files are shared by the agents in a group (so they can
+// all open the same data folder) but hidden from other users.
+const (
+	storeDirMode  = 0750
+	storeFileMode = 0660
+)
+
 // FileStore implements the Store interface by storing each value in
 // a file named after the item ID and folders for each collection.
-// Note that IDs and collections must be file name friendly.
-// On disk, the files will have a `.txt` file extension added.
+// IDs and collections are escaped with EscapeName so any name, including
+// IDs from untrusted commands, stays inside the base folder. On disk, the
+// files will have a `.txt` file extension added. Folders and files written
+// before names were escaped are renamed to their escaped form when the
+// store is created. Writes go to a temporary file that is synced and
+// renamed into place so a power loss never leaves a partially written item
+// behind.
 type FileStore struct {
 	Base string // The path to the file store base
 }
@@ -47,17 +221,26 @@ func NewFileStore(base ...string) (*FileStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = os.MkdirAll(root, 0755)
+	err = makeStoreDir(root)
 	if err != nil {
 		return nil, err
 	}
-	return &FileStore{root}, nil
+	f := &FileStore{root}
+	if err = f.migrate(); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 // Read a value from the provided collection and ID.
 func (f *FileStore) Read(collection, id string) (string, error) {
-	name := filepath.Join(f.Base, collection, id+".txt")
-	text, err := ioutil.ReadFile(name)
+	if err := validateNames(collection, id); err != nil {
+		return "", err
+	}
+	text, err := ioutil.ReadFile(f.path(collection, id))
+	if os.IsNotExist(err) {
+		return "", &NotFoundError{collection, id}
+	}
 	if err != nil {
 		return "", err
 	}
@@ -66,84 +249,332 @@ func (f *FileStore) Read(collection, id string) (string, error) {
 
 // Write a value to the provided collection and ID.
 func (f *FileStore) Write(collection, id, value string) error {
-	base := filepath.Join(f.Base, collection)
-	err := os.MkdirAll(base, 0755)
+	if err := validateItem(collection, id, value); err != nil {
+		return err
+	}
+	base := f.dir(collection)
+	err := makeStoreDir(base)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(filepath.Join(base, id+".txt"), []byte(value), 0660)
+	tmp, err := ioutil.TempFile(base, EscapeName(id)+tempMarker+"*")
+	if err != nil {
+		return err
+	}
+	// Clean up the temp file if anything goes wrong before the rename
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(value)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), storeFileMode); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), f.path(collection, id)); err != nil {
+		return err
+	}
+	return syncDir(base)
 }
 
 // Remove a value from the provided collection and ID.
 func (f *FileStore) Remove(collection, id string) error {
-	return os.Remove(filepath.Join(f.Base, collection, id+".txt"))
+	if err := validateNames(collection, id); err != nil {
+		return err
+	}
+	err := os.Remove(f.path(collection, id))
+	if os.IsNotExist(err) {
+		return &NotFoundError{collection, id}
+	}
+	return err
 }
 
 // RemoveAll removes all items from a collection.
 func (f *FileStore) RemoveAll(collection string) error {
-	return os.RemoveAll(filepath.Join(f.Base, collection))
+	if err := validateNames(collection); err != nil {
+		return err
+	}
+	return os.RemoveAll(f.dir(collection))
 }
 
-// Load all the values for a collection.
-func (f *FileStore) Load(collection string) ([]string, error) {
-	items := []string{}
-	base := filepath.Join(f.Base, collection)
-	base, err := filepath.Abs(base)
+// List the IDs of all items in a collection sorted by ID. Sub-directories
+// are ignored and stale temporary files left over from interrupted writes
+// are quarantined.
+func (f *FileStore) List(collection string) ([]string, error) {
+	if err := validateNames(collection); err != nil {
+		return nil, err
+	}
+	base := f.dir(collection)
+	files, err := ioutil.ReadDir(base)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
 	if err != nil {
-		log.Println("Error getting abs path to", collection, err)
 		return nil, err
 	}
-	log.Println("Loading collection", collection, "from", base)
-	filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			// Ignore
-			log.Println("store ignoring walk error", err)
-			return filepath.SkipDir
-		}
-		/*
-			if info.IsDir() {
-				log.Println("skipping dir", path)
-				return filepath.SkipDir
+	ids := []string{}
+	for _, info := range files {
+		path := filepath.Join(base, info.Name())
+		switch {
+		case info.IsDir():
+			log.Println("skipping dir", path)
+		case strings.Contains(info.Name(), tempMarker):
+			// Left over from an interrupted write unless another agent
+			// sharing the folder is still writing it
+			if time.Since(info.ModTime()) > staleTempAge {
+				f.quarantine(collection, path)
 			}
-		*/
-		if filepath.Ext(path) == ".txt" {
-			log.Println("loading item", path)
-			text, err := ioutil.ReadFile(path)
+		case filepath.Ext(path) == ".txt":
+			id, err := UnescapeName(strings.TrimSuffix(info.Name(), ".txt"))
 			if err != nil {
-				return err
+				log.Println("skipping badly named item", path, err)
+				continue
 			}
-			items = append(items, string(text))
-		} else {
+			ids = append(ids, id)
+		default:
 			log.Println("skipping non item", path, filepath.Ext(path))
 		}
-		return nil
-	})
-	return items, nil
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
 
+// Each calls fn with every item in a collection in ID order. Iteration stops
+// at the first error which is returned. Corrupted items are quarantined and
+// skipped.
+func (f *FileStore) Each(collection string, fn func(id, value string) error) error {
+	ids, err := f.List(collection)
+	if err != nil {
+		return err
+	}
+	log.Println("Loading collection", collection, "from", f.dir(collection))
+	for _, id := range ids {
+		path := f.path(collection, id)
+		text, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue // Removed since we listed the collection
+		}
+		if err != nil {
+			return err
+		}
+		if len(text) == 0 || !utf8.Valid(text) {
+			f.quarantine(collection, path)
+			continue
+		}
+		if err = fn(id, string(text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LockWrites takes an exclusive lock on the `.lock` file in the store
+// folder, waiting for other stores (in any process) sharing the folder to
+// release it.
+func (f *FileStore) LockWrites() (func(), error) {
+	file, err := os.OpenFile(filepath.Join(f.Base, ".lock"), os.O_RDWR|os.O_CREATE, storeFileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(file, true); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
+
+// Collections lists the names of all collections in sorted order.
+func (f *FileStore) Collections() ([]string, error) {
+	files, err := ioutil.ReadDir(f.Base)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, info := range files {
+		if !info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		name, err := UnescapeName(info.Name())
+		if err != nil {
+			log.Println("skipping badly named collection", info.Name(), err)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Load all the values for a collection in ID order.
+func (f *FileStore) Load(collection string) ([]string, error) {
+	return loadValues(f, collection)
+}
+
+// LoadMap loads all the items for a collection keyed by ID.
+func (f *FileStore) LoadMap(collection string) (map[string]string, error) {
+	return loadMap(f, collection)
+}
+
+// dir returns the folder holding a collection.
+func (f *FileStore) dir(collection string) string {
+	return filepath.Join(f.Base, EscapeName(collection))
+}
+
+// path returns the file holding an item.
+func (f *FileStore) path(collection, id string) string {
+	return filepath.Join(f.dir(collection), EscapeName(id)+".txt")
+}
+
+// migrate renames collection folders and item files named before names
+// were escaped (such as `my.pattern.txt`) to their escaped form. Names that
+// are already escaped are left alone, as are legacy names whose escaped
+// form is taken.
+func (f *FileStore) migrate() error {
+	folders, err := ioutil.ReadDir(f.Base)
+	if err != nil {
+		return err
+	}
+	for _, folder := range folders {
+		if !folder.IsDir() || strings.HasPrefix(folder.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(f.Base, folder.Name())
+		if err := makeStoreDir(dir); err != nil {
+			return err
+		}
+		if _, err := UnescapeName(folder.Name()); err != nil {
+			escaped := filepath.Join(f.Base, EscapeName(folder.Name()))
+			if !migrateFile(dir, escaped) {
+				continue
+			}
+			dir = escaped
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, info := range files {
+			name := info.Name()
+			if info.IsDir() || strings.Contains(name, tempMarker) || filepath.Ext(name) != ".txt" {
+				continue
+			}
+			id := strings.TrimSuffix(name, ".txt")
+			if _, err := UnescapeName(id); err != nil {
+				migrateFile(filepath.Join(dir, name), filepath.Join(dir, EscapeName(id)+".txt"))
+			}
+		}
+	}
+	return nil
+}
+
+// migrateFile renames a legacy file or folder unless the new name is taken,
+// returning whether it was renamed.
+func migrateFile(from, to string) bool {
+	if _, err := os.Lstat(to); err == nil {
+		log.Println("store can not migrate", from, "as", to, "already exists")
+		return false
+	}
+	log.Println("store migrating", from, "to", to)
+	if err := os.Rename(from, to); err != nil {
+		log.Println("store could not migrate", from, err)
+		return false
+	}
+	return true
+}
+
+// tempMarker is part of the name of temporary files used while writing.
+const tempMarker = ".txt.tmp"
+
+// staleTempAge is how old a temporary file must be before it is treated as
+// left over from an interrupted write.
+const staleTempAge = time.Minute
+
+// quarantine moves a corrupted or left over file out of a collection into
+// the `.quarantine` folder so it can be inspected later.
+func (f *FileStore) quarantine(collection, path string) {
+	dir := filepath.Join(f.Base, ".quarantine", EscapeName(collection))
+	name := fmt.Sprintf("%s.%d", filepath.Base(path), time.Now().UnixNano())
+	log.Println("store quarantining", path, "to", dir)
+	err := makeStoreDir(dir)
+	if err == nil {
+		err = os.Rename(path, filepath.Join(dir, name))
+	}
+	if err != nil {
+		log.Println("store could not quarantine", path, err)
+	}
+}
+
+// makeStoreDir creates a store folder, tightening the permissions of an
+// existing folder other users can access.
+func makeStoreDir(dir string) error {
+	if err := os.MkdirAll(dir, storeDirMode); err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if mode := info.Mode().Perm(); mode&^storeDirMode != 0 {
+		return os.Chmod(dir, mode&storeDirMode)
+	}
+	return nil
+}
+
+// syncDir flushes directory entries (such as a rename) to disk.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
 }
 
 // MockStore is used to test services that rely on Store implementations.
+// Use MemoryStore for in-memory storage at runtime. MockStore is safe for
+// concurrent use through its methods; tests that read Data directly should
+// do so while no other goroutines use the store.
 type MockStore struct {
 	Data map[string]map[string]string
+
+	lock     sync.RWMutex
+	watchers storeWatchers
 }
 
 // Read a value from the provided collection with a given ID.
 func (s *MockStore) Read(collection, id string) (string, error) {
-	c, ok := s.Data[collection]
-	if !ok {
-		return "", errors.New("No collection found " + collection)
+	if err := validateNames(collection, id); err != nil {
+		return "", err
 	}
-	item, ok := c[id]
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	item, ok := s.Data[collection][id]
 	if !ok {
-		return "", errors.New("No item with ID found " + id)
+		return "", &NotFoundError{collection, id}
 	}
 	return item, nil
 }
 
 // Write a value to the provided collection with a given ID.
 func (s *MockStore) Write(collection, id, value string) error {
+	if err := validateItem(collection, id, value); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	event := StoreEvent{EventCreate, collection, id}
 	c, ok := s.Data[collection]
 	if ok {
+		if _, ok = c[id]; ok {
+			event.Type = EventUpdate
+		}
 		c[id] = value
 	} else {
 		c = map[string]string{id: value}
@@ -153,34 +584,114 @@ func (s *MockStore) Write(collection, id, value string) error {
 			s.Data[collection] = c
 		}
 	}
+	s.lock.Unlock()
+	s.watchers.notify(event)
 	return nil
 }
 
 // Remove a value from the provided collection with a given ID.
 func (s *MockStore) Remove(collection, id string) error {
-	c, ok := s.Data[collection]
+	if err := validateNames(collection, id); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	_, ok := s.Data[collection][id]
 	if ok {
-		delete(c, id)
+		delete(s.Data[collection], id)
+	}
+	s.lock.Unlock()
+	if !ok {
+		return &NotFoundError{collection, id}
 	}
+	s.watchers.notify(StoreEvent{EventDelete, collection, id})
 	return nil
 }
 
 // RemoveAll clears all items from a collection.
 func (s *MockStore) RemoveAll(collection string) error {
+	ids, err := s.List(collection)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
 	delete(s.Data, collection)
+	s.lock.Unlock()
+	for _, id := range ids {
+		s.watchers.notify(StoreEvent{EventDelete, collection, id})
+	}
+	return nil
+}
+
+// List the IDs of all items in a collection in sorted order.
+func (s *MockStore) List(collection string) ([]string, error) {
+	if err := validateNames(collection); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	ids := []string{}
+	for id := range s.Data[collection] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Each calls fn with every item in a collection in ID order.
+func (s *MockStore) Each(collection string, fn func(id, value string) error) error {
+	ids, err := s.List(collection)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		s.lock.RLock()
+		value, ok := s.Data[collection][id]
+		s.lock.RUnlock()
+		if !ok {
+			continue // Removed during iteration
+		}
+		if err = fn(id, value); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Load all the values from a collection.
+// Load all the values from a collection in ID order.
 func (s *MockStore) Load(collection string) ([]string, error) {
-	items := []string{}
-	for _, item := range s.Data[collection] {
-		items = append(items, item)
+	return loadValues(s, collection)
+}
+
+// LoadMap loads all the items from a collection keyed by ID.
+func (s *MockStore) LoadMap(collection string) (map[string]string, error) {
+	return loadMap(s, collection)
+}
+
+// Watch reports changes made through this store to a collection.
+func (s *MockStore) Watch(collection string) (*Watcher, error) {
+	if err := validateNames(collection); err != nil {
+		return nil, err
 	}
-	return items, nil
+	return s.watchers.watch(collection), nil
+}
+
+// Collections lists the names of all collections in sorted order.
+func (s *MockStore) Collections() ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	names := []string{}
+	for name, c := range s.Data {
+		if len(c) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
 }
 
 // Reset removes all data from the store.
 func (s *MockStore) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.Data = map[string]map[string]string{}
 }
@@ -0,0 +1,17 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package lights
+
+import "os"
+
+// lockFile does nothing where flock is not available, so stores on these
+// platforms must only be opened by one process.
+func lockFile(file *os.File, wait bool) error {
+	return nil
+}
+
+// unlockFile does nothing where flock is not available.
+func unlockFile(file *os.File) error {
+	return nil
+}
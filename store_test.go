@@ -1,7 +1,14 @@
 package lights_test
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
 	"github.com/inceptionllc/go-lights"
+	"github.com/inceptionllc/go-lights/storetest"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -27,5 +34,182 @@ var _ = Describe("Core", func() {
 			found, err = s.Read("foo", "bar")
 			Ω(err).Should(HaveOccurred())
 		})
+
+		It("should write file store items atomically", func() {
+			dir, err := ioutil.TempDir("", "lights-store")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			s, err := lights.NewFileStore(dir)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("foo", "bar", "baz")).Should(Succeed())
+			Ω(s.Write("foo", "bar", "qux")).Should(Succeed())
+			found, err := s.Read("foo", "bar")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(found).Should(Equal("qux"))
+			files, err := ioutil.ReadDir(filepath.Join(dir, "foo"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(files).Should(HaveLen(1))
+		})
+
+		It("should keep file store folders private", func() {
+			dir, err := ioutil.TempDir("", "lights-store")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			root := filepath.Join(dir, "data")
+			s, err := lights.NewFileStore(root)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("foo", "bar", "baz")).Should(Succeed())
+			for _, folder := range []string{root, filepath.Join(root, "foo")} {
+				info, err := os.Stat(folder)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(info.Mode().Perm()).Should(Equal(os.FileMode(0750)), folder)
+			}
+
+			// Folders created world readable by older versions are tightened
+			legacy := filepath.Join(dir, "legacy")
+			Ω(os.MkdirAll(filepath.Join(legacy, "foo"), 0755)).Should(Succeed())
+			for _, folder := range []string{legacy, filepath.Join(legacy, "foo")} {
+				Ω(os.Chmod(folder, 0755)).Should(Succeed())
+			}
+			_, err = lights.NewFileStore(legacy)
+			Ω(err).ShouldNot(HaveOccurred())
+			for _, folder := range []string{legacy, filepath.Join(legacy, "foo")} {
+				info, err := os.Stat(folder)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(info.Mode().Perm()).Should(Equal(os.FileMode(0750)), folder)
+			}
+		})
+
+		It("should quarantine corrupted and left over file store items", func() {
+			dir, err := ioutil.TempDir("", "lights-store")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			s, err := lights.NewFileStore(dir)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("foo", "bar", "baz")).Should(Succeed())
+			base := filepath.Join(dir, "foo")
+			Ω(ioutil.WriteFile(filepath.Join(base, "empty.txt"), []byte{}, 0660)).Should(Succeed())
+			Ω(ioutil.WriteFile(filepath.Join(base, "half.txt.tmp123"), []byte("#F0"), 0660)).Should(Succeed())
+			Ω(ioutil.WriteFile(filepath.Join(base, "busy.txt.tmp456"), []byte("#F0"), 0660)).Should(Succeed())
+			old := time.Now().Add(-time.Hour)
+			Ω(os.Chtimes(filepath.Join(base, "half.txt.tmp123"), old, old)).Should(Succeed())
+			loaded, err := s.Load("foo")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(loaded).Should(Equal([]string{"baz"}))
+			files, err := ioutil.ReadDir(filepath.Join(dir, ".quarantine", "foo"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(files).Should(HaveLen(2))
+		})
+
+		It("should iterate items by ID", func() {
+			dir, err := ioutil.TempDir("", "lights-store")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			fs, err := lights.NewFileStore(dir)
+			Ω(err).ShouldNot(HaveOccurred())
+			for _, s := range []lights.Store{fs, &lights.MockStore{}} {
+				ids, err := s.List("foo")
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(ids).Should(BeEmpty())
+				Ω(s.Write("foo", "b", "2")).Should(Succeed())
+				Ω(s.Write("foo", "c", "3")).Should(Succeed())
+				Ω(s.Write("foo", "a", "1")).Should(Succeed())
+				ids, err = s.List("foo")
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(ids).Should(Equal([]string{"a", "b", "c"}))
+				loaded, err := s.Load("foo")
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(loaded).Should(Equal([]string{"1", "2", "3"}))
+				items, err := s.LoadMap("foo")
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(items).Should(Equal(map[string]string{"a": "1", "b": "2", "c": "3"}))
+				seen := []string{}
+				err = s.Each("foo", func(id, value string) error {
+					seen = append(seen, id)
+					if id == "b" {
+						return errors.New("stop")
+					}
+					return nil
+				})
+				Ω(err).Should(MatchError("stop"))
+				Ω(seen).Should(Equal([]string{"a", "b"}))
+			}
+		})
+
+		It("should not descend into sub-directories of a collection", func() {
+			dir, err := ioutil.TempDir("", "lights-store")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			s, err := lights.NewFileStore(dir)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(s.Write("foo", "a", "1")).Should(Succeed())
+			Ω(os.MkdirAll(filepath.Join(dir, "foo", "bar"), 0755)).Should(Succeed())
+			Ω(ioutil.WriteFile(filepath.Join(dir, "foo", "bar", "b.txt"), []byte("2"), 0660)).Should(Succeed())
+			loaded, err := s.Load("foo")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(loaded).Should(Equal([]string{"1"}))
+		})
+	})
+
+	Describe("Store implementations", func() {
+		var dir string
+		var closers []func() error
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "lights-conformance")
+			Ω(err).ShouldNot(HaveOccurred())
+			closers = nil
+		})
+
+		AfterEach(func() {
+			for _, close := range closers {
+				close()
+			}
+			os.RemoveAll(dir)
+		})
+
+		Describe("MockStore", func() {
+			storetest.Conformance(func() lights.Store {
+				return &lights.MockStore{}
+			})
+		})
+
+		Describe("FileStore", func() {
+			storetest.Conformance(func() lights.Store {
+				s, err := lights.NewFileStore(dir)
+				Ω(err).ShouldNot(HaveOccurred())
+				return s
+			})
+		})
+
+		Describe("LogStore", func() {
+			storetest.Conformance(func() lights.Store {
+				s, err := lights.NewLogStore(filepath.Join(dir, "data.log"))
+				Ω(err).ShouldNot(HaveOccurred())
+				closers = append(closers, s.Close)
+				return s
+			})
+		})
+
+		Describe("MemoryStore", func() {
+			storetest.Conformance(func() lights.Store {
+				return lights.NewMemoryStore()
+			})
+		})
+
+		Describe("VersionedStore", func() {
+			storetest.Conformance(func() lights.Store {
+				return lights.NewVersionedStore(&lights.MockStore{}, 0, "test")
+			})
+		})
+
+		Describe("EncryptedStore", func() {
+			storetest.Conformance(func() lights.Store {
+				s, err := lights.NewEncryptedStore(&lights.MockStore{}, lights.DeriveKey([]byte("secret"), "test"))
+				Ω(err).ShouldNot(HaveOccurred())
+				return s
+			})
+		})
 	})
 })
@@ -0,0 +1,142 @@
+package lights
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask selects the inotify events FileStore watchers react to.
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+
+// Watch reports changes to a collection using inotify, so changes made by
+// other agents sharing the data folder are seen immediately.
+func (f *FileStore) Watch(collection string) (*Watcher, error) {
+	if err := validateNames(collection); err != nil {
+		return nil, err
+	}
+	dir := f.dir(collection)
+	if err := makeStoreDir(dir); err != nil {
+		return nil, err
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = syscall.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// A non-blocking descriptor uses the runtime poller so Close unblocks Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	ids, err := f.List(collection)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, id := range ids {
+		known[id] = true
+	}
+	events := make(chan StoreEvent, watchBuffer)
+	done := make(chan struct{})
+	send := func(event StoreEvent) bool {
+		select {
+		case events <- event:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	go func() {
+		defer close(events)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				start := offset + syscall.SizeofInotifyEvent
+				offset = start + int(raw.Len)
+				if raw.Mask&syscall.IN_IGNORED != 0 {
+					// The collection folder was removed (RemoveAll)
+					for id := range known {
+						delete(known, id)
+						if !send(StoreEvent{EventDelete, collection, id}) {
+							return
+						}
+					}
+					if !f.rewatch(fd, collection, known, send) {
+						return
+					}
+					continue
+				}
+				name := strings.TrimRight(string(buf[start:offset]), "\x00")
+				if strings.Contains(name, tempMarker) || filepath.Ext(name) != ".txt" {
+					continue
+				}
+				id, err := UnescapeName(strings.TrimSuffix(name, ".txt"))
+				if err != nil {
+					continue
+				}
+				event := StoreEvent{Collection: collection, ID: id}
+				switch {
+				case raw.Mask&(syscall.IN_MOVED_TO|syscall.IN_CLOSE_WRITE) != 0:
+					event.Type = EventCreate
+					if known[id] {
+						event.Type = EventUpdate
+					}
+					known[id] = true
+				case raw.Mask&(syscall.IN_MOVED_FROM|syscall.IN_DELETE) != 0:
+					if !known[id] {
+						continue
+					}
+					event.Type = EventDelete
+					delete(known, id)
+				default:
+					continue
+				}
+				if !send(event) {
+					return
+				}
+			}
+		}
+	}()
+	return &Watcher{Events: events, stop: func() {
+		close(done)
+		file.Close()
+	}}, nil
+}
+
+// rewatch recreates a removed collection folder, watches it again and
+// reports any items written while it was not watched.
+func (f *FileStore) rewatch(fd int, collection string, known map[string]bool, send func(StoreEvent) bool) bool {
+	dir := f.dir(collection)
+	err := makeStoreDir(dir)
+	if err == nil {
+		_, err = syscall.InotifyAddWatch(fd, dir, inotifyMask)
+	}
+	if err != nil {
+		log.Println("store could not rewatch", dir, err)
+		return false
+	}
+	ids, err := f.List(collection)
+	if err != nil {
+		log.Println("store could not list", dir, err)
+		return false
+	}
+	for _, id := range ids {
+		if !known[id] {
+			known[id] = true
+			if !send(StoreEvent{EventCreate, collection, id}) {
+				return false
+			}
+		}
+	}
+	return true
+}
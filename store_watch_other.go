@@ -0,0 +1,64 @@
+//go:build !linux
+// +build !linux
+
+package lights
+
+import (
+	"log"
+	"time"
+)
+
+// watchInterval is how often FileStore watchers poll without inotify.
+const watchInterval = time.Second
+
+// Watch reports changes to a collection. Without inotify the collection is
+// polled so events may arrive up to a second after the change.
+func (f *FileStore) Watch(collection string) (*Watcher, error) {
+	known, err := f.LoadMap(collection)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan StoreEvent, watchBuffer)
+	done := make(chan struct{})
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			current, err := f.LoadMap(collection)
+			if err != nil {
+				log.Println("store watcher could not load", collection, err)
+				continue
+			}
+			changes := []StoreEvent{}
+			for id, value := range current {
+				previous, ok := known[id]
+				switch {
+				case !ok:
+					changes = append(changes, StoreEvent{EventCreate, collection, id})
+				case previous != value:
+					changes = append(changes, StoreEvent{EventUpdate, collection, id})
+				}
+			}
+			for id := range known {
+				if _, ok := current[id]; !ok {
+					changes = append(changes, StoreEvent{EventDelete, collection, id})
+				}
+			}
+			known = current
+			for _, event := range changes {
+				select {
+				case events <- event:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return &Watcher{Events: events, stop: func() { close(done) }}, nil
+}
@@ -0,0 +1,152 @@
+// Package storetest provides a Ginkgo conformance suite for implementations
+// of the lights.Store interface. Run it from a Describe block in the
+// implementation's own test suite:
+//
+//	var _ = Describe("MyStore", func() {
+//		storetest.Conformance(func() lights.Store {
+//			return NewMyStore()
+//		})
+//	})
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Conformance registers specs asserting the semantics shared by every Store
+// implementation. newStore is called before each spec and must return an
+// empty store.
+func Conformance(newStore func() lights.Store) {
+	var s lights.Store
+
+	BeforeEach(func() {
+		s = newStore()
+	})
+
+	Describe("Store conformance", func() {
+		It("should report missing items as not found", func() {
+			_, err := s.Read("patterns", "ab")
+			Ω(lights.IsNotFound(err)).Should(BeTrue(), "Read: %v", err)
+			err = s.Remove("patterns", "ab")
+			Ω(lights.IsNotFound(err)).Should(BeTrue(), "Remove: %v", err)
+			Ω(s.RemoveAll("patterns")).Should(Succeed())
+			ids, err := s.List("patterns")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ids).Should(BeEmpty())
+			values, err := s.Load("patterns")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(values).Should(BeEmpty())
+			items, err := s.LoadMap("patterns")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(items).Should(BeEmpty())
+		})
+
+		It("should read, overwrite and remove items", func() {
+			Ω(s.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			Ω(s.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			Ω(s.Write("scenes", "ab", "ab|#00F")).Should(Succeed())
+			Ω(s.Read("patterns", "ab")).Should(Equal(":ab|#F00"))
+			Ω(s.Write("patterns", "ab", ":ab|#FFF\nline two")).Should(Succeed())
+			Ω(s.Read("patterns", "ab")).Should(Equal(":ab|#FFF\nline two"))
+			Ω(s.Remove("patterns", "ab")).Should(Succeed())
+			_, err := s.Read("patterns", "ab")
+			Ω(lights.IsNotFound(err)).Should(BeTrue())
+			Ω(s.Read("patterns", "cd")).Should(Equal(":cd|#0F0"))
+			Ω(s.Read("scenes", "ab")).Should(Equal("ab|#00F"))
+		})
+
+		It("should remove whole collections", func() {
+			Ω(s.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			Ω(s.Write("patterns", "cd", ":cd|#0F0")).Should(Succeed())
+			Ω(s.Write("scenes", "ab", "ab|#00F")).Should(Succeed())
+			Ω(s.RemoveAll("patterns")).Should(Succeed())
+			Ω(s.List("patterns")).Should(BeEmpty())
+			Ω(s.List("scenes")).Should(Equal([]string{"ab"}))
+			Ω(s.Write("patterns", "ef", ":ef|#F0F")).Should(Succeed())
+			Ω(s.List("patterns")).Should(Equal([]string{"ef"}))
+		})
+
+		It("should iterate in ID order", func() {
+			for _, id := range []string{"b", "c", "a", "10", "2"} {
+				Ω(s.Write("patterns", id, "value "+id)).Should(Succeed())
+			}
+			order := []string{"10", "2", "a", "b", "c"}
+			Ω(s.List("patterns")).Should(Equal(order))
+			values := []string{}
+			for _, id := range order {
+				values = append(values, "value "+id)
+			}
+			Ω(s.Load("patterns")).Should(Equal(values))
+			Ω(s.LoadMap("patterns")).Should(HaveLen(5))
+			seen := []string{}
+			stop := errors.New("stop")
+			err := s.Each("patterns", func(id, value string) error {
+				seen = append(seen, id)
+				Ω(value).Should(Equal("value " + id))
+				if id == "a" {
+					return stop
+				}
+				return nil
+			})
+			Ω(err).Should(Equal(stop))
+			Ω(seen).Should(Equal([]string{"10", "2", "a"}))
+		})
+
+		It("should store untrusted names and reject invalid ones", func() {
+			for _, id := range []string{"../../etc/x", "a/b", ".", "..", "100%", "日本"} {
+				Ω(s.Write("patterns", id, "value")).Should(Succeed(), id)
+				Ω(s.Read("patterns", id)).Should(Equal("value"), id)
+			}
+			Ω(s.List("patterns")).Should(HaveLen(6))
+			Ω(s.Write("../x", "ab", "value")).Should(Succeed())
+			Ω(s.Read("../x", "ab")).Should(Equal("value"))
+
+			err := s.Write("patterns", "", "value")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.NameError{}))
+			err = s.Write("", "ab", "value")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.NameError{}))
+			_, err = s.Read("patterns", "")
+			Ω(err).Should(BeAssignableToTypeOf(&lights.NameError{}))
+			Ω(s.Write("patterns", "ab", "")).Should(Equal(lights.ErrEmptyValue))
+		})
+
+		It("should support concurrent use", func() {
+			wg := sync.WaitGroup{}
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for i := 0; i < 10; i++ {
+						id := fmt.Sprintf("%d-%d", w, i)
+						Ω(s.Write("status", id, id)).Should(Succeed())
+						Ω(s.Read("status", id)).Should(Equal(id))
+						_, err := s.Load("status")
+						Ω(err).ShouldNot(HaveOccurred())
+					}
+				}(w)
+			}
+			wg.Wait()
+			Ω(s.List("status")).Should(HaveLen(80))
+		})
+
+		It("should report changes to watchers", func() {
+			w, err := s.Watch("patterns")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer w.Close()
+			Ω(s.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			Eventually(w.Events, "3s").Should(Receive(Equal(lights.StoreEvent{Type: lights.EventCreate, Collection: "patterns", ID: "ab"})))
+			Ω(s.Write("patterns", "ab", ":ab|#0F0")).Should(Succeed())
+			Eventually(w.Events, "3s").Should(Receive(Equal(lights.StoreEvent{Type: lights.EventUpdate, Collection: "patterns", ID: "ab"})))
+			Ω(s.Remove("patterns", "ab")).Should(Succeed())
+			Eventually(w.Events, "3s").Should(Receive(Equal(lights.StoreEvent{Type: lights.EventDelete, Collection: "patterns", ID: "ab"})))
+		})
+	})
+}
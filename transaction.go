@@ -0,0 +1,288 @@
+package lights
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// txItem is the staged state of an item in a Transaction.
+type txItem struct {
+	value   string
+	removed bool
+}
+
+// Transaction is a Store that stages changes to another store. Reads see
+// the staged changes but nothing reaches the wrapped store until Commit.
+// Commits to an Updater (such as a LogStore) are atomic. Other stores, such
+// as a FileStore or MemoryStore, have the changes applied in order and undone
+// if one fails, holding the store's write lock if it is a WriteLocker. This
+// is best effort: a crash or a failing undo can leave part of the changes
+// applied, and writers that do not take the lock may see them.
+type Transaction struct {
+	Store Store
+
+	lock    sync.Mutex
+	ops     []logOp
+	items   map[string]map[string]txItem
+	cleared map[string]bool
+	done    bool
+}
+
+// ErrTransactionDone is returned when a committed or discarded transaction
+// is used.
+var ErrTransactionDone = errors.New("Transaction has already been committed or discarded")
+
+// NewTransaction starts a transaction against a store.
+func NewTransaction(store Store) *Transaction {
+	return &Transaction{Store: store, items: map[string]map[string]txItem{}, cleared: map[string]bool{}}
+}
+
+// Read a value, including staged changes.
+func (t *Transaction) Read(collection, id string) (string, error) {
+	if err := validateNames(collection, id); err != nil {
+		return "", err
+	}
+	t.lock.Lock()
+	item, staged := t.items[collection][id]
+	cleared := t.cleared[collection]
+	t.lock.Unlock()
+	switch {
+	case staged && item.removed, !staged && cleared:
+		return "", &NotFoundError{collection, id}
+	case staged:
+		return item.value, nil
+	default:
+		return t.Store.Read(collection, id)
+	}
+}
+
+// Write stages a value.
+func (t *Transaction) Write(collection, id, value string) error {
+	if err := validateItem(collection, id, value); err != nil {
+		return err
+	}
+	return t.stage(logOp{opWrite, collection, id, value}, txItem{value: value})
+}
+
+// Remove stages the removal of an existing value.
+func (t *Transaction) Remove(collection, id string) error {
+	if _, err := t.Read(collection, id); err != nil {
+		return err
+	}
+	return t.stage(logOp{op: opRemove, collection: collection, id: id}, txItem{removed: true})
+}
+
+// RemoveAll stages the removal of all values in a collection.
+func (t *Transaction) RemoveAll(collection string) error {
+	if err := validateNames(collection); err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return ErrTransactionDone
+	}
+	t.ops = append(t.ops, logOp{op: opRemoveAll, collection: collection})
+	t.items[collection] = map[string]txItem{}
+	t.cleared[collection] = true
+	return nil
+}
+
+// List the IDs of all items in a collection, including staged changes.
+func (t *Transaction) List(collection string) ([]string, error) {
+	if err := validateNames(collection); err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	cleared := t.cleared[collection]
+	staged := map[string]txItem{}
+	for id, item := range t.items[collection] {
+		staged[id] = item
+	}
+	t.lock.Unlock()
+	ids := map[string]bool{}
+	if !cleared {
+		base, err := t.Store.List(collection)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range base {
+			ids[id] = true
+		}
+	}
+	for id, item := range staged {
+		ids[id] = !item.removed
+	}
+	list := []string{}
+	for id, ok := range ids {
+		if ok {
+			list = append(list, id)
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// Each calls fn with every item in a collection in ID order.
+func (t *Transaction) Each(collection string, fn func(id, value string) error) error {
+	ids, err := t.List(collection)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		value, err := t.Read(collection, id)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(id, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load all the values from a collection in ID order.
+func (t *Transaction) Load(collection string) ([]string, error) {
+	return loadValues(t, collection)
+}
+
+// LoadMap loads all the items from a collection keyed by ID.
+func (t *Transaction) LoadMap(collection string) (map[string]string, error) {
+	return loadMap(t, collection)
+}
+
+// Watch reports committed changes to a collection.
+func (t *Transaction) Watch(collection string) (*Watcher, error) {
+	return t.Store.Watch(collection)
+}
+
+// Discard drops the staged changes.
+func (t *Transaction) Discard() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.ops = nil
+	t.done = true
+}
+
+// Commit applies the staged changes to the wrapped store.
+func (t *Transaction) Commit() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return ErrTransactionDone
+	}
+	t.done = true
+	if len(t.ops) == 0 {
+		return nil
+	}
+	if updater, ok := t.Store.(Updater); ok {
+		err := updater.Update(func(b *Batch) error {
+			for _, op := range t.ops {
+				if err := applyLogOp(b, op); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != ErrBatchUnsupported {
+			return err
+		}
+	}
+	if locker, ok := t.Store.(WriteLocker); ok {
+		unlock, err := locker.LockWrites()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	undo := []func() error{}
+	for _, op := range t.ops {
+		restore, err := t.undoFor(op)
+		if err == nil {
+			err = applyLogOp(t.Store, op)
+		}
+		if err != nil {
+			for i := len(undo) - 1; i >= 0; i-- {
+				undo[i]()
+			}
+			return err
+		}
+		undo = append(undo, restore)
+	}
+	return nil
+}
+
+// undoFor captures what is needed to undo an operation.
+func (t *Transaction) undoFor(op logOp) (func() error, error) {
+	if op.op == opRemoveAll {
+		previous, err := t.Store.LoadMap(op.collection)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			for id, value := range previous {
+				if err := t.Store.Write(op.collection, id, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil
+	}
+	previous, err := t.Store.Read(op.collection, op.id)
+	if IsNotFound(err) {
+		return func() error {
+			err := t.Store.Remove(op.collection, op.id)
+			if IsNotFound(err) {
+				return nil
+			}
+			return err
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return t.Store.Write(op.collection, op.id, previous)
+	}, nil
+}
+
+// stage records a change to a single item.
+func (t *Transaction) stage(op logOp, item txItem) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return ErrTransactionDone
+	}
+	t.ops = append(t.ops, op)
+	c, ok := t.items[op.collection]
+	if !ok {
+		c = map[string]txItem{}
+		t.items[op.collection] = c
+	}
+	c[op.id] = item
+	return nil
+}
+
+// applyLogOp applies a staged change.
+func applyLogOp(s interface {
+	Write(collection, id, value string) error
+	Remove(collection, id string) error
+	RemoveAll(collection string) error
+}, op logOp) error {
+	switch op.op {
+	case opWrite:
+		return s.Write(op.collection, op.id, op.value)
+	case opRemove:
+		err := s.Remove(op.collection, op.id)
+		if IsNotFound(err) {
+			return nil // Removed by an earlier change
+		}
+		return err
+	default:
+		return s.RemoveAll(op.collection)
+	}
+}
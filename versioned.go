@@ -0,0 +1,337 @@
+package lights
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyPrefix is added to a collection name to form the collection that
+// holds its revision history. VersionedStores do not change collections
+// starting with the prefix so they can not corrupt histories.
+const historyPrefix = "history:"
+
+// Revision is a past or current value of an item in a VersionedStore.
+type Revision struct {
+	Number  int       `json:"n"`
+	Time    time.Time `json:"t"`
+	Author  string    `json:"a,omitempty"`
+	Value   string    `json:"v,omitempty"`
+	Deleted bool      `json:"d,omitempty"`
+}
+
+// RevisionError is returned by a compare-and-swap write when the item has
+// changed since the expected revision.
+type RevisionError struct {
+	Collection string
+	ID         string
+	Expected   int
+	Actual     int
+}
+
+// Error describes the conflicting revisions.
+func (e *RevisionError) Error() string {
+	return fmt.Sprintf("Revision conflict for %s/%s: expected %d found %d", e.Collection, e.ID, e.Expected, e.Actual)
+}
+
+// VersionedStore wraps a Store keeping the last few revisions of every item
+// so bad changes can be rolled back. Histories are kept in the wrapped store
+// in a `history:<collection>` collection, so collection names starting
+// with `history:` are reserved. Compare-and-swap writes hold the
+// wrapped store's write lock if it implements WriteLocker (as FileStore and
+// LogStore do), so they are atomic for every VersionedStore in any process
+// sharing the store. Otherwise they are only atomic for writers sharing the
+// VersionedStore.
+type VersionedStore struct {
+	Store  Store
+	Keep   int    // The number of revisions to keep per item
+	Author string // Author recorded by Write, Remove and RemoveAll
+
+	lock sync.Mutex
+}
+
+// NewVersionedStore wraps a store keeping the given number of revisions
+// (zero keeps 10).
+func NewVersionedStore(store Store, keep int, author string) *VersionedStore {
+	if keep <= 0 {
+		keep = 10
+	}
+	return &VersionedStore{Store: store, Keep: keep, Author: author}
+}
+
+// Read a value from the provided collection with a given ID.
+func (v *VersionedStore) Read(collection, id string) (string, error) {
+	return v.Store.Read(collection, id)
+}
+
+// Write a value recording a new revision by the default author.
+func (v *VersionedStore) Write(collection, id, value string) error {
+	_, err := v.WriteIf(collection, id, value, v.Author, -1)
+	return err
+}
+
+// WriteAs writes a value recording a new revision by the author and returns
+// the new revision number.
+func (v *VersionedStore) WriteAs(collection, id, value, author string) (int, error) {
+	return v.WriteIf(collection, id, value, author, -1)
+}
+
+// WriteIf writes a value only if the item's current revision is expected
+// (use zero for items that must not exist yet, or -1 to always write). A
+// RevisionError is returned if the item has changed.
+func (v *VersionedStore) WriteIf(collection, id, value, author string, expected int) (int, error) {
+	if err := validateItem(collection, id, value); err != nil {
+		return 0, err
+	}
+	unlock, err := v.lockWrites()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	return v.change(collection, id, Revision{Author: author, Value: value}, expected)
+}
+
+// Remove a value recording the removal as a revision.
+func (v *VersionedStore) Remove(collection, id string) error {
+	unlock, err := v.lockWrites()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := v.Store.Read(collection, id); err != nil {
+		return err
+	}
+	_, err = v.change(collection, id, Revision{Author: v.Author, Deleted: true}, -1)
+	return err
+}
+
+// RemoveAll removes all values from a collection recording each removal.
+func (v *VersionedStore) RemoveAll(collection string) error {
+	unlock, err := v.lockWrites()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = checkHistoryName(collection); err != nil {
+		return err
+	}
+	ids, err := v.Store.List(collection)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err = v.change(collection, id, Revision{Author: v.Author, Deleted: true}, -1); err != nil {
+			return err
+		}
+	}
+	return v.Store.RemoveAll(collection)
+}
+
+// Load all the values from a collection in ID order.
+func (v *VersionedStore) Load(collection string) ([]string, error) {
+	return v.Store.Load(collection)
+}
+
+// List the IDs of all items in a collection in sorted order.
+func (v *VersionedStore) List(collection string) ([]string, error) {
+	return v.Store.List(collection)
+}
+
+// LoadMap loads all the items from a collection keyed by ID.
+func (v *VersionedStore) LoadMap(collection string) (map[string]string, error) {
+	return v.Store.LoadMap(collection)
+}
+
+// Each calls fn with every item in a collection in ID order.
+func (v *VersionedStore) Each(collection string, fn func(id, value string) error) error {
+	return v.Store.Each(collection, fn)
+}
+
+// Collections lists the collections in the wrapped store, including the
+// `history:` collections.
+func (v *VersionedStore) Collections() ([]string, error) {
+	lister, ok := v.Store.(CollectionLister)
+	if !ok {
+		return nil, errors.New("Wrapped store can not list collections")
+	}
+	return lister.Collections()
+}
+
+// Update runs fn with a new batch and commits its changes, together with
+// the revisions they record by the default author, in one batch of the
+// wrapped store.
+func (v *VersionedStore) Update(fn func(b *Batch) error) error {
+	updater, ok := v.Store.(Updater)
+	if !ok {
+		return ErrBatchUnsupported
+	}
+	unlock, err := v.lockWrites()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return updater.Update(func(b *Batch) error {
+		staged := &Batch{}
+		if err := fn(staged); err != nil {
+			return err
+		}
+		// Record revisions in a transaction so later changes in the batch
+		// see the histories of earlier ones
+		tx := NewTransaction(v.Store)
+		view := &VersionedStore{Store: tx, Keep: v.Keep, Author: v.Author}
+		for _, op := range staged.ops {
+			if err := applyLogOp(view, op); err != nil {
+				return err
+			}
+		}
+		b.ops = append(b.ops, tx.ops...)
+		return nil
+	})
+}
+
+// Watch reports changes to a collection.
+func (v *VersionedStore) Watch(collection string) (*Watcher, error) {
+	return v.Store.Watch(collection)
+}
+
+// Revision returns the current revision number of an item (zero if the item
+// has never been written).
+func (v *VersionedStore) Revision(collection, id string) (int, error) {
+	history, err := v.History(collection, id)
+	if err != nil || len(history) == 0 {
+		return 0, err
+	}
+	return history[len(history)-1].Number, nil
+}
+
+// History returns the kept revisions of an item, oldest first.
+func (v *VersionedStore) History(collection, id string) ([]Revision, error) {
+	history := []Revision{}
+	text, err := v.Store.Read(historyPrefix+collection, id)
+	if IsNotFound(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(text), &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// Rollback restores an item to the value it had at a kept revision. The
+// restored value is recorded as a new revision by the author.
+func (v *VersionedStore) Rollback(collection, id string, revision int, author string) (int, error) {
+	unlock, err := v.lockWrites()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	history, err := v.History(collection, id)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range history {
+		if r.Number == revision {
+			return v.change(collection, id, Revision{Author: author, Value: r.Value, Deleted: r.Deleted}, -1)
+		}
+	}
+	return 0, fmt.Errorf("Revision %d of %s/%s is not kept", revision, collection, id)
+}
+
+// lockWrites takes the lock and the wrapped store's write lock if it has
+// one, returning a function that releases both.
+func (v *VersionedStore) lockWrites() (func(), error) {
+	v.lock.Lock()
+	locker, ok := v.Store.(WriteLocker)
+	if !ok {
+		return v.lock.Unlock, nil
+	}
+	unlock, err := locker.LockWrites()
+	if err != nil {
+		v.lock.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		v.lock.Unlock()
+	}, nil
+}
+
+// change applies a new revision to an item. The caller must hold the write
+// locks.
+func (v *VersionedStore) change(collection, id string, r Revision, expected int) (int, error) {
+	if err := checkHistoryName(collection); err != nil {
+		return 0, err
+	}
+	history, err := v.History(collection, id)
+	if err != nil {
+		return 0, err
+	}
+	current := 0
+	if len(history) > 0 {
+		current = history[len(history)-1].Number
+	}
+	if expected >= 0 && expected != current {
+		return 0, &RevisionError{collection, id, expected, current}
+	}
+	r.Number = current + 1
+	r.Time = time.Now().UTC()
+	history = append(history, r)
+	keep := v.Keep
+	if keep <= 0 {
+		keep = 10
+	}
+	if len(history) > keep {
+		history = history[len(history)-keep:]
+	}
+	text, err := json.Marshal(history)
+	if err != nil {
+		return 0, err
+	}
+	if updater, ok := v.Store.(Updater); ok {
+		// Keep the value and history in step
+		_, missing := v.Store.Read(collection, id)
+		err = updater.Update(func(b *Batch) error {
+			var err error
+			if r.Deleted {
+				if !IsNotFound(missing) {
+					err = b.Remove(collection, id)
+				}
+			} else {
+				err = b.Write(collection, id, r.Value)
+			}
+			if err != nil {
+				return err
+			}
+			return b.Write(historyPrefix+collection, id, string(text))
+		})
+		if err != ErrBatchUnsupported {
+			if err != nil {
+				return 0, err
+			}
+			return r.Number, nil
+		}
+	}
+	if r.Deleted {
+		err = v.Store.Remove(collection, id)
+		if err != nil && !IsNotFound(err) {
+			return 0, err
+		}
+	} else if err = v.Store.Write(collection, id, r.Value); err != nil {
+		return 0, err
+	}
+	return r.Number, v.Store.Write(historyPrefix+collection, id, string(text))
+}
+
+// checkHistoryName rejects changes to collections reserved for histories.
+func checkHistoryName(collection string) error {
+	if strings.HasPrefix(collection, historyPrefix) {
+		return errors.New("Collection names starting with " + historyPrefix + " are reserved: " + collection)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package lights_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+
+	Describe("Versioned store", func() {
+		It("should keep a limited history of revisions", func() {
+			v := lights.NewVersionedStore(&lights.MockStore{}, 3, "scheduler")
+			for _, value := range []string{"#F00", "#0F0", "#00F", "#FFF"} {
+				Ω(v.Write("patterns", "ab", value)).Should(Succeed())
+			}
+			history, err := v.History("patterns", "ab")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(history).Should(HaveLen(3))
+			Ω(history[0].Number).Should(Equal(2))
+			Ω(history[0].Value).Should(Equal("#0F0"))
+			Ω(history[2].Number).Should(Equal(4))
+			Ω(history[2].Author).Should(Equal("scheduler"))
+			Ω(history[2].Time.IsZero()).Should(BeFalse())
+			revision, err := v.Revision("patterns", "ab")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(revision).Should(Equal(4))
+		})
+
+		It("should roll back to earlier values", func() {
+			v := lights.NewVersionedStore(&lights.MockStore{}, 0, "")
+			Ω(v.Write("patterns", "ab", "#F00")).Should(Succeed())
+			Ω(v.Write("patterns", "ab", "#BAD")).Should(Succeed())
+			revision, err := v.Rollback("patterns", "ab", 1, "gateway")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(revision).Should(Equal(3))
+			found, err := v.Read("patterns", "ab")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(found).Should(Equal("#F00"))
+
+			Ω(v.Remove("patterns", "ab")).Should(Succeed())
+			_, err = v.Read("patterns", "ab")
+			Ω(err).Should(HaveOccurred())
+			_, err = v.Rollback("patterns", "ab", 3, "gateway")
+			Ω(err).ShouldNot(HaveOccurred())
+			found, err = v.Read("patterns", "ab")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(found).Should(Equal("#F00"))
+			_, err = v.Rollback("patterns", "ab", 42, "gateway")
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should not change collections reserved for histories", func() {
+			dir, err := ioutil.TempDir("", "lights-versioned")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			ls, err := lights.NewLogStore(filepath.Join(dir, "data.log"))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer ls.Close()
+			v := lights.NewVersionedStore(ls, 0, "")
+			Ω(v.Write("foo", "ab", "#F00")).Should(Succeed())
+
+			Ω(v.Write("history:foo", "ab", "[]")).Should(MatchError(ContainSubstring("reserved")))
+			Ω(v.Remove("history:foo", "ab")).ShouldNot(Succeed())
+			Ω(v.RemoveAll("history:foo")).Should(MatchError(ContainSubstring("reserved")))
+			Ω(v.Update(func(b *lights.Batch) error {
+				return b.Write("history:foo", "ab", "[]")
+			})).Should(MatchError(ContainSubstring("reserved")))
+			history, err := v.History("foo", "ab")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(history).Should(HaveLen(1))
+			Ω(history[0].Value).Should(Equal("#F00"))
+		})
+
+		It("should reject compare-and-swap writes with stale revisions", func() {
+			dir, err := ioutil.TempDir("", "lights-versioned")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			ls, err := lights.NewLogStore(filepath.Join(dir, "data.log"))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer ls.Close()
+			v := lights.NewVersionedStore(ls, 0, "")
+			revision, err := v.WriteIf("scenes", "1", "1|:ab", "gateway", 0)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(revision).Should(Equal(1))
+			_, err = v.WriteIf("scenes", "1", "1|:cd", "scheduler", 0)
+			Ω(err).Should(BeAssignableToTypeOf(&lights.RevisionError{}))
+			revision, err = v.WriteIf("scenes", "1", "1|:cd", "scheduler", 1)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(revision).Should(Equal(2))
+			found, err := ls.Read("scenes", "1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(found).Should(Equal("1|:cd"))
+		})
+
+		It("should compare-and-swap atomically across stores sharing a folder", func() {
+			dir, err := ioutil.TempDir("", "lights-versioned")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			// Each writer has its own stores as if it were another process
+			increment := func(done chan<- error) {
+				fs, err := lights.NewFileStore(dir)
+				if err != nil {
+					done <- err
+					return
+				}
+				v := lights.NewVersionedStore(fs, 0, "")
+				for i := 0; i < 20; {
+					revision, err := v.Revision("counters", "hits")
+					if err != nil {
+						done <- err
+						return
+					}
+					count := 0
+					if text, err := v.Read("counters", "hits"); err == nil {
+						count, _ = strconv.Atoi(text)
+					}
+					_, err = v.WriteIf("counters", "hits", strconv.Itoa(count+1), "", revision)
+					if _, conflict := err.(*lights.RevisionError); err != nil && !conflict {
+						done <- err
+						return
+					}
+					if err == nil {
+						i++
+					}
+				}
+				done <- nil
+			}
+			done := make(chan error)
+			for i := 0; i < 4; i++ {
+				go increment(done)
+			}
+			for i := 0; i < 4; i++ {
+				Ω(<-done).Should(Succeed())
+			}
+			fs, err := lights.NewFileStore(dir)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(fs.Read("counters", "hits")).Should(Equal("80"))
+			Ω(lights.NewVersionedStore(fs, 0, "").Revision("counters", "hits")).Should(Equal(80))
+		})
+	})
+})
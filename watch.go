@@ -0,0 +1,80 @@
+package lights
+
+import (
+	"log"
+	"sync"
+)
+
+// Store change event types.
+const (
+	EventCreate = "create"
+	EventUpdate = "update"
+	EventDelete = "delete"
+)
+
+// watchBuffer is the number of events a Watcher queues before dropping.
+const watchBuffer = 64
+
+// StoreEvent describes a change to an item in a Store collection.
+type StoreEvent struct {
+	Type       string // EventCreate, EventUpdate or EventDelete
+	Collection string
+	ID         string
+}
+
+// Watcher delivers change events for a Store collection. Call Close when
+// done to release resources; the Events channel is closed afterwards.
+type Watcher struct {
+	Events <-chan StoreEvent
+
+	once sync.Once
+	stop func()
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() {
+	w.once.Do(w.stop)
+}
+
+// storeWatchers delivers events to in-process watchers. The zero value is
+// ready to use.
+type storeWatchers struct {
+	lock     sync.Mutex
+	channels map[string]map[chan StoreEvent]bool
+}
+
+// watch registers a new watcher for a collection.
+func (w *storeWatchers) watch(collection string) *Watcher {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.channels == nil {
+		w.channels = map[string]map[chan StoreEvent]bool{}
+	}
+	if w.channels[collection] == nil {
+		w.channels[collection] = map[chan StoreEvent]bool{}
+	}
+	events := make(chan StoreEvent, watchBuffer)
+	w.channels[collection][events] = true
+	return &Watcher{Events: events, stop: func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		delete(w.channels[collection], events)
+		close(events)
+	}}
+}
+
+// notify sends events to the watchers of each event's collection. Events
+// are dropped (and logged) for watchers that are not keeping up.
+func (w *storeWatchers) notify(events ...StoreEvent) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, event := range events {
+		for ch := range w.channels[event.Collection] {
+			select {
+			case ch <- event:
+			default:
+				log.Println("store watcher dropping event", event)
+			}
+		}
+	}
+}
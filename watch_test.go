@@ -0,0 +1,78 @@
+package lights_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+
+	Describe("Store watchers", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "lights-watch")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		stores := func() []lights.Store {
+			fs, err := lights.NewFileStore(filepath.Join(dir, "files"))
+			Ω(err).ShouldNot(HaveOccurred())
+			ls, err := lights.NewLogStore(filepath.Join(dir, "data.log"))
+			Ω(err).ShouldNot(HaveOccurred())
+			return []lights.Store{&lights.MockStore{}, fs, ls}
+		}
+
+		It("should report created, updated and deleted items", func() {
+			for _, s := range stores() {
+				w, err := s.Watch("patterns")
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(s.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+				Eventually(w.Events).Should(Receive(Equal(lights.StoreEvent{Type: lights.EventCreate, Collection: "patterns", ID: "ab"})))
+				Ω(s.Write("patterns", "ab", ":ab|#0F0")).Should(Succeed())
+				Eventually(w.Events).Should(Receive(Equal(lights.StoreEvent{Type: lights.EventUpdate, Collection: "patterns", ID: "ab"})))
+				Ω(s.Write("scenes", "1", "1|:ab")).Should(Succeed())
+				Ω(s.Remove("patterns", "ab")).Should(Succeed())
+				Eventually(w.Events).Should(Receive(Equal(lights.StoreEvent{Type: lights.EventDelete, Collection: "patterns", ID: "ab"})))
+				w.Close()
+				Eventually(w.Events).Should(BeClosed())
+			}
+		})
+
+		It("should report items removed with RemoveAll", func() {
+			for _, s := range stores() {
+				Ω(s.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+				w, err := s.Watch("patterns")
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(s.RemoveAll("patterns")).Should(Succeed())
+				Eventually(w.Events).Should(Receive(Equal(lights.StoreEvent{Type: lights.EventDelete, Collection: "patterns", ID: "ab"})))
+				Ω(s.Write("patterns", "cd", ":cd|#F00")).Should(Succeed())
+				Eventually(w.Events).Should(Receive(Equal(lights.StoreEvent{Type: lights.EventCreate, Collection: "patterns", ID: "cd"})))
+				w.Close()
+			}
+		})
+
+		It("should see changes made by other file stores", func() {
+			a, err := lights.NewFileStore(dir)
+			Ω(err).ShouldNot(HaveOccurred())
+			b, err := lights.NewFileStore(dir)
+			Ω(err).ShouldNot(HaveOccurred())
+			w, err := a.Watch("patterns")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer w.Close()
+			Ω(b.Write("patterns", "ab", ":ab|#F00")).Should(Succeed())
+			Eventually(w.Events, "3s").Should(Receive(Equal(lights.StoreEvent{Type: lights.EventCreate, Collection: "patterns", ID: "ab"})))
+		})
+	})
+})
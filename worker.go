@@ -1,10 +1,12 @@
 package lights
 
 import (
+	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 )
 
@@ -12,12 +14,21 @@ import (
 // message could not be handled.
 type WorkerFunc func(message string) error
 
+// ResponderFunc handles incoming string messages returning a response body
+// or an error if the message could not be handled.
+type ResponderFunc func(message string) (string, error)
+
+// Middleware wraps a WorkerFunc with extra behavior (for example signature
+// verification) that runs before the message reaches the handler.
+type Middleware func(handler WorkerFunc) WorkerFunc
+
 // Worker takes care of the common worker tasks that all message
 // driven agents must carry out. The worker takes care of bootstrapping
 // the system.
 type Worker struct {
-	agent  string
-	queues map[string]map[string]chan<- (QMessage) // Message queues for each agent/route combination
+	agent      string
+	queues     map[string]map[string]chan<- (QMessage) // Message queues for each agent/route combination
+	middleware []Middleware                            // Wraps every handler registered after Use
 }
 
 // NewWorker creates a new worker ready for configuration. Call Start() on
@@ -47,21 +58,82 @@ func (w *Worker) Consumer(handler WorkerFunc) error {
 	return w.Handler("/command", handler)
 }
 
+// Use adds middleware that wraps handlers registered after this call. The
+// first middleware added is the first to see each message.
+func (w *Worker) Use(middleware ...Middleware) {
+	w.middleware = append(w.middleware, middleware...)
+}
+
 // Handler registers a new API route handler for the worker.
 func (w *Worker) Handler(route string, handler WorkerFunc) error {
+	return w.Responder(route, func(message string) (string, error) {
+		return "", handler(message)
+	})
+}
+
+// Responder registers a new API route handler that answers each message with
+// the response it returns ("OK" if the response is empty). Requests with a
+// JSON Content-Type carry a JSON command or signed envelope (see
+// ParseJSONMessage) that is converted to the compact format before the
+// middleware runs, and are answered with JSON `{"response":"..."}` or
+// `{"error":"..."}` objects.
+func (w *Worker) Responder(route string, responder ResponderFunc) error {
+	middleware := append([]Middleware{}, w.middleware...)
 	http.HandleFunc(route, func(resp http.ResponseWriter, r *http.Request) {
 		body, err := ioutil.ReadAll(r.Body)
 		log.Println("<-", string(body))
-		if w.errorFree(err, resp) {
-			err = handler(string(body))
-			if w.errorFree(err, resp) {
-				io.WriteString(resp, "OK")
+		message := string(body)
+		jsonRequest := isJSON(r.Header.Get("Content-Type"))
+		status := http.StatusInternalServerError
+		if err == nil && jsonRequest {
+			if message, err = ParseJSONMessage(body); err != nil {
+				status = http.StatusBadRequest
 			}
 		}
+		reply := ""
+		if err == nil {
+			reply, err = respond(middleware, responder, message)
+		}
+		switch {
+		case jsonRequest:
+			resp.Header().Set("Content-Type", JSONContentType)
+			if err != nil {
+				resp.WriteHeader(status)
+				json.NewEncoder(resp).Encode(map[string]string{"error": err.Error()})
+			} else {
+				json.NewEncoder(resp).Encode(map[string]string{"response": reply})
+			}
+		case w.errorFree(err, resp):
+			if len(reply) == 0 {
+				reply = "OK"
+			}
+			io.WriteString(resp, reply)
+		}
 	})
 	return nil
 }
 
+// respond passes a message through the middleware to a responder.
+func respond(middleware []Middleware, responder ResponderFunc, message string) (string, error) {
+	reply := ""
+	var handler WorkerFunc = func(message string) error {
+		var err error
+		reply, err = responder(message)
+		return err
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	err := handler(message)
+	return reply, err
+}
+
+// isJSON returns true if a Content-Type header is JSON.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == JSONContentType
+}
+
 // Transmit reliably sends a message to another agent using HTTP.
 // Set consolidate to true if messages sent to the same agent and route should
 // only transmit the last message when an agent is offline. If consolidate is
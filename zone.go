@@ -0,0 +1,384 @@
+package lights
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MotionEffects lists the slot motion effects.
+var MotionEffects = []string{"chase", "scroll", "wipe"}
+
+// DefaultMotionSpeed is the speed of motion effects that do not give one, in
+// pixels per second.
+const DefaultMotionSpeed = 10
+
+// Segment colors a range of pixels on an addressable strip. Segments with
+// more than one color show a gradient across their pixels. Segments are
+// specified as `color[>color...][@start-end]` with inclusive pixel indexes,
+// and a slot's segments are separated by `/`. Segments without a range
+// share the strip evenly in order, then ranged segments are drawn over
+// them. For example:
+//
+//	#F00/#FFF/#00F        three even bands
+//	#F00>#00F             a gradient across the strip
+//	#000/#FFF@0-4         black with the first five pixels white
+type Segment struct {
+	Colors []color.Color
+	Start  int // First pixel (-1 for an even share of the strip)
+	End    int // Last pixel (-1 for an even share of the strip)
+}
+
+// ParseSegments parses the segments of a slot color field.
+func ParseSegments(spec string) ([]*Segment, error) {
+	segments := []*Segment{}
+	for _, part := range strings.Split(spec, "/") {
+		segment := &Segment{Start: -1, End: -1}
+		colors := strings.TrimSpace(part)
+		if at := strings.IndexByte(colors, '@'); at >= 0 {
+			bounds := strings.SplitN(colors[at+1:], "-", 2)
+			colors = colors[:at]
+			if len(bounds) != 2 {
+				return nil, errors.New("Segment range must be start-end: " + part)
+			}
+			var err error
+			if segment.Start, err = strconv.Atoi(strings.TrimSpace(bounds[0])); err != nil || segment.Start < 0 {
+				return nil, errors.New("Invalid segment start: " + part)
+			}
+			if segment.End, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || segment.End < segment.Start {
+				return nil, errors.New("Invalid segment end: " + part)
+			}
+		}
+		for _, code := range strings.Split(colors, ">") {
+			c, err := ParseColorCode(strings.TrimSpace(code))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid segment color %s: %s", code, err)
+			}
+			segment.Colors = append(segment.Colors, c)
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// FormatSegments formats segments as a slot color field.
+func FormatSegments(segments []*Segment) string {
+	parts := []string{}
+	for _, segment := range segments {
+		codes := []string{}
+		for _, c := range segment.Colors {
+			codes = append(codes, FormatColorCode(c))
+		}
+		part := strings.Join(codes, ">")
+		if segment.Start >= 0 {
+			part += "@" + strconv.Itoa(segment.Start) + "-" + strconv.Itoa(segment.End)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Motion moves a slot along the strip. Motions are specified in a slot's
+// fifth field as `effect[:speed[:direction]]` where speed is in pixels per
+// second and direction is `right` (towards higher pixels, the default) or
+// `left`. The effects are:
+//
+//	chase   every third pixel is lit and the lit pixels move along
+//	scroll  the slot's pixels rotate along the strip
+//	wipe    the slot wipes over the previous slot instead of fading
+//	        (at the fade speed if no speed is given)
+type Motion struct {
+	Effect  string
+	Speed   float64
+	Reverse bool // Moves left
+}
+
+// ParseMotion parses a motion specification.
+func ParseMotion(spec string) (*Motion, error) {
+	parts := strings.Split(spec, ":")
+	m := &Motion{Effect: strings.TrimSpace(parts[0]), Speed: DefaultMotionSpeed}
+	switch m.Effect {
+	case "chase", "scroll":
+	case "wipe":
+		m.Speed = 0
+	default:
+		return nil, errors.New("Unknown motion effect: " + spec)
+	}
+	if len(parts) > 3 {
+		return nil, errors.New("Motion has too many parts: " + spec)
+	}
+	if len(parts) > 1 && len(strings.TrimSpace(parts[1])) > 0 {
+		speed, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || speed < 0 || speed > 1e6 {
+			return nil, errors.New("Invalid motion speed: " + spec)
+		}
+		m.Speed = speed
+	}
+	if len(parts) > 2 {
+		switch strings.TrimSpace(parts[2]) {
+		case "right", "":
+		case "left":
+			m.Reverse = true
+		default:
+			return nil, errors.New("Motion direction must be left or right: " + spec)
+		}
+	}
+	return m, nil
+}
+
+// String returns the canonical motion specification.
+func (m *Motion) String() string {
+	direction := "right"
+	if m.Reverse {
+		direction = "left"
+	}
+	return m.Effect + ":" + strconv.FormatFloat(m.Speed, 'g', -1, 64) + ":" + direction
+}
+
+// offset returns how many pixels the motion has moved after elapsed.
+func (m *Motion) offset(elapsed time.Duration) int {
+	return int(m.Speed * elapsed.Seconds())
+}
+
+// Frame renders the pattern on a strip of pixels after it has played for
+// elapsed, returning the frame and whether the pattern has finished.
+// Single color slots fill the strip, so Frame agrees with ColorAt.
+func (p *Pattern) Frame(elapsed time.Duration, pixels int) ([]color.RGBA, bool) {
+	if p.Effect != nil {
+		return p.effectFrame(elapsed, pixels)
+	}
+	return p.slotsFrame(elapsed, pixels)
+}
+
+// slotsFrame renders the pattern's slots.
+func (p *Pattern) slotsFrame(elapsed time.Duration, pixels int) ([]color.RGBA, bool) {
+	if pixels < 0 {
+		pixels = 0
+	}
+	colors := p.slotColors()
+	if len(colors) == 0 {
+		return make([]color.RGBA, pixels), true
+	}
+	last := len(p.Slots) - 1
+	pos := p.locate(elapsed)
+	if pos.finished || pos.cycle <= 0 {
+		s := p.Slots[last]
+		return p.slotFrame(last, colors[last], pixels, s.Fade+s.Hold), pos.finished
+	}
+	s := p.Slots[pos.slot]
+	current := p.slotFrame(pos.slot, colors[pos.slot], pixels, pos.since)
+	if !pos.fading {
+		return current, false
+	}
+	prev := (pos.slot + last) % len(p.Slots)
+	ps := p.Slots[prev]
+	previous := p.slotFrame(prev, colors[prev], pixels, ps.Fade+ps.Hold)
+	if s.Motion != nil && s.Motion.Effect == "wipe" {
+		wiped := int(Ease(s.Transition, pos.progress) * float64(pixels))
+		if s.Motion.Speed > 0 {
+			wiped = s.Motion.offset(pos.since)
+		}
+		for i := 0; i < pixels && i < wiped; i++ {
+			j := i
+			if s.Motion.Reverse {
+				j = pixels - 1 - i
+			}
+			previous[j] = current[j]
+		}
+		return previous, false
+	}
+	t := Ease(s.Transition, pos.progress)
+	for i := range current {
+		current[i] = MixColors(previous[i], current[i], t)
+	}
+	return current, false
+}
+
+// slotFrame renders a slot (with its motion after it has shown for since).
+// Slots without segments fill the strip with fill.
+func (p *Pattern) slotFrame(index int, fill color.Color, pixels int, since time.Duration) []color.RGBA {
+	s := p.Slots[index]
+	frame := make([]color.RGBA, pixels)
+	if len(s.Segments) == 0 {
+		c := MixColors(nil, fill, 1)
+		for i := range frame {
+			frame[i] = c
+		}
+	} else {
+		drawSegments(frame, s.Segments)
+	}
+	if s.Motion == nil || pixels == 0 {
+		return frame
+	}
+	offset := s.Motion.offset(since)
+	if s.Motion.Reverse {
+		offset = -offset
+	}
+	switch s.Motion.Effect {
+	case "scroll":
+		moved := make([]color.RGBA, pixels)
+		for i, c := range frame {
+			moved[((i+offset)%pixels+pixels)%pixels] = c
+		}
+		return moved
+	case "chase":
+		for i := range frame {
+			if ((i-offset)%3+3)%3 != 0 {
+				frame[i] = color.RGBA{}
+			}
+		}
+	}
+	return frame
+}
+
+// drawSegments draws segments on a frame.
+func drawSegments(frame []color.RGBA, segments []*Segment) {
+	shared := 0
+	for _, segment := range segments {
+		if segment.Start < 0 {
+			shared++
+		}
+	}
+	n := 0
+	for _, segment := range segments {
+		if segment.Start < 0 {
+			drawSegment(frame, segment, n*len(frame)/shared, (n+1)*len(frame)/shared-1)
+			n++
+		}
+	}
+	for _, segment := range segments {
+		if segment.Start >= 0 {
+			drawSegment(frame, segment, segment.Start, segment.End)
+		}
+	}
+}
+
+// drawSegment draws a segment over the inclusive pixel range.
+func drawSegment(frame []color.RGBA, segment *Segment, start, end int) {
+	if end >= len(frame) {
+		end = len(frame) - 1
+	}
+	spans := len(segment.Colors) - 1
+	for i := start; i <= end; i++ {
+		if spans == 0 || end == start {
+			frame[i] = MixColors(nil, segment.Colors[0], 1)
+			continue
+		}
+		at := float64(i-start) / float64(end-start) * float64(spans)
+		span := int(at)
+		if span >= spans {
+			span = spans - 1
+		}
+		frame[i] = MixColors(segment.Colors[span], segment.Colors[span+1], at-float64(span))
+	}
+}
+
+// Player plays a pattern on a strip of pixels rendering a frame per tick.
+type Player struct {
+	Pixels     int
+	Device     string           // Device ID used for per-device brightness
+	Brightness *Brightness      // Dims frames if set
+	Now        func() time.Time // Clock used for playback (time.Now if nil)
+
+	lock    sync.Mutex
+	pattern *Pattern
+	start   time.Time
+}
+
+// NewPlayer creates a player for a strip of pixels.
+func NewPlayer(pixels int) *Player {
+	return &Player{Pixels: pixels}
+}
+
+// Play starts playing a pattern from its beginning.
+func (pl *Player) Play(p *Pattern) {
+	now := pl.now()
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	pl.pattern = p
+	pl.start = now
+}
+
+// Install registers the player as the dispatcher's `!:` handler, playing
+// patterns resolved from patterns. The response is the pattern ID.
+func (pl *Player) Install(d *Dispatcher, patterns *PatternRepo) {
+	d.OnExecute("pattern", func(cmd *Command) (string, error) {
+		p, err := patterns.Resolve(cmd)
+		if err != nil {
+			return "", err
+		}
+		pl.Play(p)
+		return p.ID, nil
+	})
+}
+
+// InstallProperties registers a read-only `pattern` property with the ID of
+// the pattern playing (empty if there is none).
+func (pl *Player) InstallProperties(props *Properties) {
+	props.Register(&Property{Name: "pattern", Type: PropertyString, Get: func() interface{} {
+		if p := pl.Pattern(); p != nil {
+			return p.ID
+		}
+		return ""
+	}})
+}
+
+// Pattern returns the pattern playing or nil if there is none.
+func (pl *Player) Pattern() *Pattern {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	return pl.pattern
+}
+
+// Frame renders the current frame and whether the pattern has finished. A
+// player without a pattern renders black.
+func (pl *Player) Frame() ([]color.RGBA, bool) {
+	now := pl.now()
+	pl.lock.Lock()
+	p, start := pl.pattern, pl.start
+	pl.lock.Unlock()
+	if p == nil {
+		return make([]color.RGBA, pl.Pixels), true
+	}
+	frame, finished := p.Frame(now.Sub(start), pl.Pixels)
+	if pl.Brightness != nil {
+		frame = pl.Brightness.Apply(pl.Device, frame)
+	}
+	return frame, finished
+}
+
+// Run renders a frame every tick until stop is closed or render returns an
+// error.
+func (pl *Player) Run(tick time.Duration, stop <-chan struct{}, render func(frame []color.RGBA) error) error {
+	return runFrames(tick, stop, pl.Frame, render)
+}
+
+// runFrames renders a frame from source every tick until stop is closed or
+// render returns an error.
+func runFrames(tick time.Duration, stop <-chan struct{}, source func() ([]color.RGBA, bool), render func(frame []color.RGBA) error) error {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			frame, _ := source()
+			if err := render(frame); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// now returns the current time from the player's clock.
+func (pl *Player) now() time.Time {
+	if pl.Now != nil {
+		return pl.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,140 @@
+package lights_test
+
+import (
+	"encoding/json"
+	"image/color"
+	"time"
+
+	"github.com/inceptionllc/go-lights"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Core", func() {
+	Describe("Zones", func() {
+		red := color.RGBA{0xff, 0, 0, 0}
+		green := color.RGBA{0, 0xff, 0, 0}
+		blue := color.RGBA{0, 0, 0xff, 0}
+		white := color.RGBA{0xff, 0xff, 0xff, 0}
+		black := color.RGBA{}
+
+		frame := func(spec string, elapsed time.Duration, pixels int) []color.RGBA {
+			p, err := lights.NewPattern(spec)
+			Ω(err).ShouldNot(HaveOccurred())
+			f, _ := p.Frame(elapsed, pixels)
+			return f
+		}
+
+		It("should parse and format segments and motion", func() {
+			slot, err := lights.NewSlot("#F00/#FFF>#00F@2-5,1,2,linear,scroll:4:left")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(slot.Color).Should(Equal(red))
+			Ω(slot.Segments).Should(HaveLen(2))
+			Ω(slot.Segments[1].Colors).Should(Equal([]color.Color{white, blue}))
+			Ω(slot.Segments[1].Start).Should(Equal(2))
+			Ω(slot.Segments[1].End).Should(Equal(5))
+			Ω(slot.Motion).Should(Equal(&lights.Motion{Effect: "scroll", Speed: 4, Reverse: true}))
+			Ω(slot.String()).Should(Equal("#FF0000/#FFFFFF>#0000FF@2-5,1s,2s,linear,scroll:4:left"))
+			again, err := lights.NewSlot(slot.String())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(again).Should(Equal(slot))
+
+			for _, spec := range []string{"#F00@5-2", "#F00@x-2", "#F00>red", "#F00,0,0,ease,spin", "#F00,0,0,ease,chase:fast", "#F00,0,0,ease,chase:1:up"} {
+				_, err = lights.NewSlot(spec)
+				Ω(err).Should(HaveOccurred(), spec)
+			}
+		})
+
+		It("should render segments and gradients", func() {
+			Ω(frame(":ab|#F00/#0F0/#00F", 0, 6)).Should(Equal([]color.RGBA{red, red, green, green, blue, blue}))
+			Ω(frame(":ab|#F00>#00F", 0, 3)).Should(Equal([]color.RGBA{red, {0x80, 0, 0x80, 0}, blue}))
+			Ω(frame(":ab|#000/#FFF@1-2", 0, 4)).Should(Equal([]color.RGBA{black, white, white, black}))
+			Ω(frame(":ab|#000/#FFF@3-9", 0, 4)).Should(Equal([]color.RGBA{black, black, black, white}))
+			Ω(frame(":ab|#0F0", 0, 3)).Should(Equal([]color.RGBA{green, green, green}))
+		})
+
+		It("should render motion effects", func() {
+			spec := ":ab|#F00/#000/#000/#000,0,10,ease,scroll:2"
+			Ω(frame(spec, 0, 4)).Should(Equal([]color.RGBA{red, black, black, black}))
+			Ω(frame(spec, time.Second, 4)).Should(Equal([]color.RGBA{black, black, red, black}))
+			Ω(frame(":ab|#F00/#000/#000/#000,0,10,ease,scroll:1:left", time.Second, 4)).Should(Equal([]color.RGBA{black, black, black, red}))
+			spec = ":ab|#00F,0,10,ease,chase:1"
+			Ω(frame(spec, 0, 4)).Should(Equal([]color.RGBA{blue, black, black, blue}))
+			Ω(frame(spec, time.Second, 4)).Should(Equal([]color.RGBA{black, blue, black, black}))
+			spec = ":ab|#F00,0,1|#00F,2,1,linear,wipe"
+			Ω(frame(spec, 2*time.Second, 4)).Should(Equal([]color.RGBA{blue, blue, red, red}))
+			spec = ":ab|#F00,0,1|#00F,2,1,linear,wipe:0:left"
+			Ω(frame(spec, 1500*time.Millisecond, 4)).Should(Equal([]color.RGBA{red, red, red, blue}))
+		})
+
+		It("should fade between frames", func() {
+			spec := ":ab:1|#F00/#00F,0,1|#00F/#F00,2,1,linear"
+			Ω(frame(spec, 2*time.Second, 2)).Should(Equal([]color.RGBA{{0x80, 0, 0x80, 0}, {0x80, 0, 0x80, 0}}))
+			p, _ := lights.NewPattern(spec)
+			f, done := p.Frame(time.Hour, 2)
+			Ω(done).Should(BeTrue())
+			Ω(f).Should(Equal([]color.RGBA{blue, red}))
+			c, _ := p.ColorAt(time.Hour)
+			Ω(c).Should(Equal(blue))
+		})
+
+		It("should keep zones in JSON and binary forms", func() {
+			cmd, err := lights.NewCommand("!:ab|#F00/#00F@0-1,1,1,ease,chase:5")
+			Ω(err).ShouldNot(HaveOccurred())
+			data, err := json.Marshal(cmd)
+			Ω(err).ShouldNot(HaveOccurred())
+			decoded, err := lights.ParseJSONCommand(data)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(decoded.String()).Should(Equal("!:ab|#FF0000/#0000FF@0-1,1s,1s,ease,chase:5:right"))
+			bin, err := cmd.MarshalBinary()
+			Ω(err).ShouldNot(HaveOccurred())
+			decoded, err = lights.ParseBinaryCommand(bin)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(decoded.String()).Should(Equal(cmd.String()))
+		})
+
+		It("should play frames on a player", func() {
+			now := time.Date(2015, 7, 4, 20, 0, 0, 0, time.UTC)
+			player := lights.NewPlayer(3)
+			player.Now = func() time.Time { return now }
+			f, done := player.Frame()
+			Ω(done).Should(BeTrue())
+			Ω(f).Should(HaveLen(3))
+			p, _ := lights.NewPattern(":ab|#F00,0,1|#00F,0,1")
+			player.Play(p)
+			now = now.Add(1500 * time.Millisecond)
+			f, _ = player.Frame()
+			Ω(f).Should(Equal([]color.RGBA{blue, blue, blue}))
+
+			stop := make(chan struct{})
+			frames := make(chan []color.RGBA, 10)
+			go player.Run(time.Millisecond, stop, func(frame []color.RGBA) error {
+				select {
+				case frames <- frame:
+				default:
+				}
+				return nil
+			})
+			Eventually(frames).Should(Receive(Equal([]color.RGBA{blue, blue, blue})))
+			close(stop)
+		})
+
+		It("should report the pattern playing as a property", func() {
+			player := lights.NewPlayer(1)
+			props := lights.NewProperties()
+			player.InstallProperties(props)
+			d := lights.NewDispatcher()
+			props.Install(d)
+			patterns := lights.NewPatternRepo(&lights.MockStore{})
+			player.Install(d, patterns)
+			Ω(player.Pattern()).Should(BeNil())
+			Ω(d.Dispatch("?-pattern")).Should(Equal("pattern|string|"))
+			Ω(d.Dispatch("!:ab|#F00")).Should(Equal("ab"))
+			Ω(player.Pattern().ID).Should(Equal("ab"))
+			Ω(d.Dispatch("?-pattern")).Should(Equal("pattern|string|ab"))
+			_, err := d.Dispatch("!-pattern|cd")
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})